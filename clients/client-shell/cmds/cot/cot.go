@@ -0,0 +1,54 @@
+// Package cot implements the chain of trust subcommands.
+package cot
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/taskcluster/taskcluster/v30/clients/client-shell/cmds/root"
+)
+
+var (
+	// Command is the root of the cot subtree.
+	Command = &cobra.Command{
+		Use:   "cot",
+		Short: "Provides chain of trust related commands.",
+	}
+	verifyCmd = &cobra.Command{
+		Use:   "verify [<taskId>]",
+		Short: "Verify the chain of trust certificate and artifacts of a task.",
+		Long: `Verify the chain of trust certificate and artifacts of a task.
+
+The certificate public/chain-of-trust.json is checked against its detached
+ed25519 signature public/chain-of-trust.json.sig (or, if that does not verify,
+against the signatures in public/chain-of-trust-signatures.json) using the
+given public keys, and must be for the given task and run. The SHA256 of every
+artifact listed in the certificate is then recomputed and compared against the
+certificate.
+
+By default the files are downloaded from the queue. With --dir, they are read
+from a local directory instead, using the artifact names as relative paths
+(e.g. <dir>/public/chain-of-trust.json), and no taskId is needed; if a taskId
+or --run is given, the certificate is checked to be for that task or run.
+
+The command exits with a non-zero exit code if verification fails.`,
+		RunE: verify,
+	}
+)
+
+var log = root.Logger
+
+func addVerifyFlags(flags *pflag.FlagSet) {
+	flags.IntP("run", "r", -1, "Specifies which run to consider (default: latest run).")
+	flags.StringArrayP("public-key", "k", nil, "Base64 encoded ed25519 public key to verify signatures with (may be repeated).")
+	flags.String("public-keys-file", "", "File containing base64 encoded ed25519 public keys, one per line.")
+	flags.String("dir", "", "Read the certificate, signature and artifacts from this directory rather than from the queue.")
+	flags.Bool("skip-artifacts", false, "Only verify the certificate signature, not the artifact hashes.")
+}
+
+func init() {
+	addVerifyFlags(verifyCmd.Flags())
+
+	Command.AddCommand(verifyCmd)
+
+	root.Command.AddCommand(Command)
+}
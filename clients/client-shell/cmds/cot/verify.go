@@ -0,0 +1,332 @@
+package cot
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	tcurls "github.com/taskcluster/taskcluster-lib-urls"
+	tcclient "github.com/taskcluster/taskcluster/v30/clients/client-go"
+	"github.com/taskcluster/taskcluster/v30/clients/client-go/tcqueue"
+	"github.com/taskcluster/taskcluster/v30/clients/client-shell/config"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	certName       = "public/chain-of-trust.json"
+	sigName        = "public/chain-of-trust.json.sig"
+	signaturesName = "public/chain-of-trust-signatures.json"
+)
+
+// httpClient is used to download artifacts, with a timeout so that a stalled
+// download does not hang the command forever.  This matches the lifetime of
+// the signed URLs used for the downloads.
+var httpClient = &http.Client{Timeout: 15 * time.Minute}
+
+// certificate contains the parts of a chain of trust certificate that are
+// needed for verification.
+type certificate struct {
+	Artifacts map[string]struct {
+		SHA256 string `json:"sha256"`
+	} `json:"artifacts"`
+	TaskID string `json:"taskId"`
+	RunID  uint   `json:"runId"`
+}
+
+// signatures is the content of public/chain-of-trust-signatures.json
+type signatures struct {
+	Signatures []struct {
+		Algorithm string `json:"algorithm"`
+		KeyID     string `json:"keyId"`
+		Signature string `json:"signature"`
+	} `json:"signatures"`
+}
+
+// artifactSource provides the content of artifacts by name.
+type artifactSource interface {
+	Open(name string) (io.ReadCloser, error)
+}
+
+// dirSource reads artifacts from a local directory, using the artifact name
+// as the relative path.
+type dirSource struct {
+	dir string
+}
+
+func (d *dirSource) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(d.dir, filepath.FromSlash(name)))
+}
+
+// queueSource downloads artifacts of a task run from the queue.
+type queueSource struct {
+	queue  *tcqueue.Queue
+	taskID string
+	runID  string
+}
+
+func (q *queueSource) Open(name string) (io.ReadCloser, error) {
+	// public artifacts can be fetched without credentials
+	artifactURL := tcurls.API(q.queue.RootURL, "queue", "v1", fmt.Sprintf("task/%s/runs/%s/artifacts/%s", q.taskID, q.runID, name))
+	if q.queue.Credentials != nil {
+		u, err := q.queue.GetArtifact_SignedURL(q.taskID, q.runID, name, 15*time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		artifactURL = u.String()
+	}
+	resp, err := httpClient.Get(artifactURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("could not download artifact %s: HTTP status %s", name, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// keyID returns the key ID of an ed25519 public key, as recorded by
+// generic-worker in chain of trust certificates: the first 16 hex characters
+// of the SHA256 of the public key.
+func keyID(publicKey ed25519.PublicKey) string {
+	digest := sha256.Sum256(publicKey)
+	return hex.EncodeToString(digest[:8])
+}
+
+func parsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %q: %v", s, err)
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key %q: expected %d bytes but got %d", s, ed25519.PublicKeySize, len(b))
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// publicKeys returns the public keys given with --public-key and
+// --public-keys-file.
+func publicKeys(flagSet *pflag.FlagSet) ([]ed25519.PublicKey, error) {
+	keys := []ed25519.PublicKey{}
+	values, _ := flagSet.GetStringArray("public-key")
+	if file, _ := flagSet.GetString("public-keys-file"); file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			values = append(values, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, value := range values {
+		key, err := parsePublicKey(value)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one public key must be given with --public-key or --public-keys-file")
+	}
+	return keys, nil
+}
+
+func readAll(source artifactSource, name string) ([]byte, error) {
+	r, err := source.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// verifySignature checks that the certificate has been signed with one of the
+// given keys, either via the detached signature in chain-of-trust.json.sig or
+// one of the signatures in chain-of-trust-signatures.json.
+func verifySignature(source artifactSource, certBytes []byte, keys []ed25519.PublicKey, out io.Writer) error {
+	sig, err := readAll(source, sigName)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %v", sigName, err)
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, certBytes, sig) {
+			fmt.Fprintf(out, "Signature %s verified with key %s\n", sigName, keyID(key))
+			return nil
+		}
+	}
+
+	// The primary signing key may have been rotated, so look for a signature
+	// from any other key the certificate was signed with.
+	sigsBytes, err := readAll(source, signaturesName)
+	if err != nil {
+		return fmt.Errorf("signature %s could not be verified with any of the given public keys", sigName)
+	}
+	var sigs signatures
+	if err := json.Unmarshal(sigsBytes, &sigs); err != nil {
+		return fmt.Errorf("could not interpret %s as json: %v", signaturesName, err)
+	}
+	keysByID := map[string]ed25519.PublicKey{}
+	for _, key := range keys {
+		keysByID[keyID(key)] = key
+	}
+	// Try every signature from a known key, since during a rotation the
+	// signature from one key may be invalid while another is valid.
+	invalid := []string{}
+	for _, s := range sigs.Signatures {
+		key, known := keysByID[s.KeyID]
+		if s.Algorithm != "ed25519" || !known {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(s.Signature)
+		if err == nil && ed25519.Verify(key, certBytes, sig) {
+			fmt.Fprintf(out, "Signature in %s verified with key %s\n", signaturesName, s.KeyID)
+			return nil
+		}
+		invalid = append(invalid, s.KeyID)
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("neither %s nor %s could be verified with any of the given public keys; signatures in %s for keys %s are invalid", sigName, signaturesName, signaturesName, strings.Join(invalid, ", "))
+	}
+	return fmt.Errorf("neither %s nor %s could be verified with any of the given public keys", sigName, signaturesName)
+}
+
+// verifyArtifacts recomputes the SHA256 of every artifact listed in the
+// certificate, and returns an error if any of them do not match.
+func verifyArtifacts(source artifactSource, cert *certificate, out io.Writer) error {
+	names := make([]string, 0, len(cert.Artifacts))
+	for name := range cert.Artifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	failures := 0
+	for _, name := range names {
+		expected := cert.Artifacts[name].SHA256
+		actual, err := artifactSHA256(source, name)
+		switch {
+		case err != nil:
+			fmt.Fprintf(out, "FAIL %s: %v\n", name, err)
+			failures++
+		case actual != expected:
+			fmt.Fprintf(out, "FAIL %s: SHA256 is %s but certificate states %s\n", name, actual, expected)
+			failures++
+		default:
+			fmt.Fprintf(out, "OK   %s\n", name)
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d of %d artifacts could not be verified", failures, len(names))
+	}
+	return nil
+}
+
+func artifactSHA256(source artifactSource, name string) (string, error) {
+	r, err := source.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// verifyChainOfTrust verifies the certificate signature, that the certificate
+// is for the expected task and run, and, unless skipArtifacts is set, the
+// artifact hashes.  An empty taskID or a negative runID is not checked.
+func verifyChainOfTrust(source artifactSource, keys []ed25519.PublicKey, taskID string, runID int, skipArtifacts bool, out io.Writer) error {
+	certBytes, err := readAll(source, certName)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %v", certName, err)
+	}
+	if err := verifySignature(source, certBytes, keys, out); err != nil {
+		return err
+	}
+	var cert certificate
+	if err := json.Unmarshal(certBytes, &cert); err != nil {
+		return fmt.Errorf("could not interpret %s as json: %v", certName, err)
+	}
+	if taskID != "" && cert.TaskID != taskID {
+		return fmt.Errorf("certificate is for task %s, not task %s", cert.TaskID, taskID)
+	}
+	if runID >= 0 && cert.RunID != uint(runID) {
+		return fmt.Errorf("certificate is for run %d, not run %d", cert.RunID, runID)
+	}
+	if !skipArtifacts {
+		if err := verifyArtifacts(source, &cert, out); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(out, "Chain of trust verified for task %s run %d\n", cert.TaskID, cert.RunID)
+	return nil
+}
+
+func verify(cmd *cobra.Command, args []string) error {
+	flagSet := cmd.Flags()
+	keys, err := publicKeys(flagSet)
+	if err != nil {
+		return err
+	}
+	skipArtifacts, _ := flagSet.GetBool("skip-artifacts")
+	runID, _ := flagSet.GetInt("run")
+	var taskID string
+	if len(args) > 0 {
+		taskID = args[0]
+	}
+
+	var source artifactSource
+	if dir, _ := flagSet.GetString("dir"); dir != "" {
+		source = &dirSource{dir: dir}
+	} else {
+		if len(args) < 1 {
+			return fmt.Errorf("%s expects argument <taskId> unless --dir is given", cmd.Name())
+		}
+		var creds *tcclient.Credentials
+		if config.Credentials != nil {
+			creds = config.Credentials.ToClientCredentials()
+		}
+		q := tcqueue.New(creds, config.RootURL())
+		if runID == -1 {
+			s, err := q.Status(taskID)
+			if err != nil {
+				return fmt.Errorf("could not get the status of the task %s: %v", taskID, err)
+			}
+			if len(s.Status.Runs) == 0 {
+				return fmt.Errorf("task %s has no runs", taskID)
+			}
+			runID = len(s.Status.Runs) - 1
+		}
+		log.Debugf("Verifying chain of trust of task %s run %d", taskID, runID)
+		source = &queueSource{
+			queue:  q,
+			taskID: taskID,
+			runID:  strconv.Itoa(runID),
+		}
+	}
+
+	return verifyChainOfTrust(source, keys, taskID, runID, skipArtifacts, cmd.OutOrStdout())
+}
@@ -0,0 +1,222 @@
+package cot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	assert "github.com/stretchr/testify/require"
+	"github.com/taskcluster/taskcluster/v30/clients/client-shell/config"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	fakeTaskID = "ANnmjMocTymeTID0tlNJAw"
+	artifact   = "public/build/target.tar.gz"
+)
+
+// writeFiles writes a signed chain of trust certificate and an artifact to
+// dir, and returns the content of every file written, keyed by artifact name.
+func writeFiles(t *testing.T, dir string, privateKey ed25519.PrivateKey, artifactContent []byte) map[string][]byte {
+	digest := sha256.Sum256(artifactContent)
+	cert, err := json.MarshalIndent(map[string]interface{}{
+		"chainOfTrustVersion": 1,
+		"artifacts": map[string]interface{}{
+			artifact: map[string]string{
+				"sha256": hex.EncodeToString(digest[:]),
+			},
+		},
+		"taskId": fakeTaskID,
+		"runId":  0,
+	}, "", "  ")
+	assert.NoError(t, err)
+	files := map[string][]byte{
+		certName: cert,
+		sigName:  ed25519.Sign(privateKey, cert),
+		artifact: artifactContent,
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		assert.NoError(t, ioutil.WriteFile(file, content, 0644))
+	}
+	return files
+}
+
+func setUpCommand(args ...string) (*bytes.Buffer, *cobra.Command) {
+	buf := &bytes.Buffer{}
+	cmd := &cobra.Command{RunE: verify}
+	addVerifyFlags(cmd.Flags())
+	cmd.SetOutput(buf)
+	cmd.SetArgs(args)
+	return buf, cmd
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "cot-verify")
+	assert.NoError(t, err)
+	return dir, func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestVerifyDir(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	writeFiles(t, dir, privateKey, []byte("artifact content"))
+
+	buf, cmd := setUpCommand("--dir", dir, "--public-key", base64.StdEncoding.EncodeToString(publicKey))
+	assert.NoError(t, cmd.Execute())
+	assert.Contains(t, buf.String(), "OK   "+artifact)
+	assert.Contains(t, buf.String(), "Chain of trust verified for task "+fakeTaskID+" run 0")
+}
+
+func TestVerifyDirWrongKey(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	otherPublicKey, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	writeFiles(t, dir, privateKey, []byte("artifact content"))
+
+	_, cmd := setUpCommand("--dir", dir, "--public-key", base64.StdEncoding.EncodeToString(otherPublicKey))
+	assert.Error(t, cmd.Execute())
+}
+
+func TestVerifyDirModifiedArtifact(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	writeFiles(t, dir, privateKey, []byte("artifact content"))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(artifact)), []byte("tampered content"), 0644))
+
+	buf, cmd := setUpCommand("--dir", dir, "--public-key", base64.StdEncoding.EncodeToString(publicKey))
+	assert.Error(t, cmd.Execute())
+	assert.Contains(t, buf.String(), "FAIL "+artifact)
+}
+
+func TestVerifyDirRotatedKey(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	_, oldPrivateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	newPublicKey, newPrivateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	files := writeFiles(t, dir, oldPrivateKey, []byte("artifact content"))
+
+	// the .sig is from the old key, but the certificate was also signed
+	// with the new key
+	sigs, err := json.Marshal(map[string]interface{}{
+		"signatures": []map[string]string{
+			{
+				"algorithm": "ed25519",
+				"keyId":     keyID(newPublicKey),
+				"signature": base64.StdEncoding.EncodeToString(ed25519.Sign(newPrivateKey, files[certName])),
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "public", "chain-of-trust-signatures.json"), sigs, 0644))
+
+	keysFile := filepath.Join(dir, "keys")
+	assert.NoError(t, ioutil.WriteFile(keysFile, []byte("# new key\n"+base64.StdEncoding.EncodeToString(newPublicKey)+"\n"), 0644))
+
+	buf, cmd := setUpCommand("--dir", dir, "--public-keys-file", keysFile)
+	assert.NoError(t, cmd.Execute())
+	assert.Contains(t, buf.String(), "verified with key "+keyID(newPublicKey))
+}
+
+func TestVerifyDirRotatedKeyInvalidSignature(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	_, oldPrivateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	brokenPublicKey, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	newPublicKey, newPrivateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	files := writeFiles(t, dir, oldPrivateKey, []byte("artifact content"))
+
+	// the signature from the first known key is invalid, but the signature
+	// from the second is valid
+	sigs, err := json.Marshal(map[string]interface{}{
+		"signatures": []map[string]string{
+			{
+				"algorithm": "ed25519",
+				"keyId":     keyID(brokenPublicKey),
+				"signature": base64.StdEncoding.EncodeToString(ed25519.Sign(newPrivateKey, []byte("something else"))),
+			},
+			{
+				"algorithm": "ed25519",
+				"keyId":     keyID(newPublicKey),
+				"signature": base64.StdEncoding.EncodeToString(ed25519.Sign(newPrivateKey, files[certName])),
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "public", "chain-of-trust-signatures.json"), sigs, 0644))
+
+	buf, cmd := setUpCommand("--dir", dir,
+		"--public-key", base64.StdEncoding.EncodeToString(brokenPublicKey),
+		"--public-key", base64.StdEncoding.EncodeToString(newPublicKey))
+	assert.NoError(t, cmd.Execute())
+	assert.Contains(t, buf.String(), "verified with key "+keyID(newPublicKey))
+}
+
+func TestVerifyDirWrongTask(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	writeFiles(t, dir, privateKey, []byte("artifact content"))
+
+	_, cmd := setUpCommand("some-other-task", "--dir", dir, "--public-key", base64.StdEncoding.EncodeToString(publicKey))
+	err = cmd.Execute()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate is for task "+fakeTaskID)
+
+	_, cmd = setUpCommand(fakeTaskID, "--run", "1", "--dir", dir, "--public-key", base64.StdEncoding.EncodeToString(publicKey))
+	err = cmd.Execute()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate is for run 0")
+}
+
+func TestVerifyFromQueue(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	files := writeFiles(t, dir, privateKey, []byte("artifact content"))
+
+	handler := http.NewServeMux()
+	for name, content := range files {
+		content := content
+		handler.HandleFunc("/api/queue/v1/task/"+fakeTaskID+"/runs/0/artifacts/"+name, func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write(content)
+		})
+	}
+	handler.HandleFunc("/api/queue/v1/task/"+fakeTaskID+"/status", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"status": {"runs": [{"runId": 0}]}}`))
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	config.SetRootURL(server.URL)
+	defer config.SetRootURL("")
+
+	buf, cmd := setUpCommand(fakeTaskID, "--public-key", base64.StdEncoding.EncodeToString(publicKey))
+	assert.NoError(t, cmd.Execute())
+	assert.Contains(t, buf.String(), "Chain of trust verified for task "+fakeTaskID+" run 0")
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	stream "github.com/taskcluster/taskcluster/v30/tools/livelog/writer"
+)
+
+// logEvent is the JSON payload of each server-sent event or websocket
+// message.  Offset and Length are byte offsets into the log, so that clients
+// can detect gaps and resume from Offset+Length after reconnecting.  Bytes
+// which livelog did not retain are described by an event with Elided set, and
+// no data.  The final event of a log has End set, and no data.
+type logEvent struct {
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	Data   string `json:"data"`
+	Elided bool   `json:"elided,omitempty"`
+	End    bool   `json:"end,omitempty"`
+}
+
+// eventSender delivers log events to a client.
+type eventSender interface {
+	Send(event *logEvent) error
+	Flush()
+}
+
+// eventWriter is an io.Writer which converts the bytes written to it into log
+// events.  Multi-byte UTF-8 characters which are split across writes are held
+// back until they are complete, so that Data is always valid text (any invalid
+// UTF-8 in the log is replaced with U+FFFD when the event is encoded).
+type eventWriter struct {
+	sender eventSender
+	// offset of the first byte of pending
+	offset  int64
+	pending []byte
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	buf := append(w.pending, p...)
+	complete := len(buf) - incompleteRuneLen(buf)
+	if complete > 0 {
+		err := w.send(buf[:complete])
+		if err != nil {
+			return 0, err
+		}
+	}
+	w.pending = append([]byte{}, buf[complete:]...)
+	return len(p), nil
+}
+
+func (w *eventWriter) send(data []byte) error {
+	err := w.sender.Send(&logEvent{
+		Offset: w.offset,
+		Length: int64(len(data)),
+		Data:   string(data),
+	})
+	w.offset += int64(len(data))
+	return err
+}
+
+// Flush implements http.Flusher, so that StreamHandle.WriteTo flushes events
+// as they arrive.
+func (w *eventWriter) Flush() {
+	w.sender.Flush()
+}
+
+// sendPending sends any bytes still held back.
+func (w *eventWriter) sendPending() error {
+	if len(w.pending) > 0 {
+		err := w.send(w.pending)
+		if err != nil {
+			return err
+		}
+		w.pending = nil
+	}
+	return nil
+}
+
+// WriteElision implements writer.ElisionWriter, sending an event describing
+// the elided bytes.
+func (w *eventWriter) WriteElision(offset, length int64) error {
+	if err := w.sendPending(); err != nil {
+		return err
+	}
+	w.offset = offset + length
+	return w.sender.Send(&logEvent{Offset: offset, Length: length, Elided: true})
+}
+
+// End sends any bytes still held back, followed by the final event.
+func (w *eventWriter) End() error {
+	if err := w.sendPending(); err != nil {
+		return err
+	}
+	return w.sender.Send(&logEvent{Offset: w.offset, End: true})
+}
+
+// incompleteRuneLen returns the number of bytes at the end of b which form the
+// start of a UTF-8 encoded character that is not yet complete.
+func incompleteRuneLen(b []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if utf8.FullRune(b[len(b)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+// sseSender sends log events as Server-Sent Events.  The id of each event is
+// the offset following its data, which browsers send back in the
+// Last-Event-ID header when reconnecting.
+type sseSender struct {
+	writer http.ResponseWriter
+}
+
+func (s *sseSender) Send(event *logEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.End {
+		_, err = fmt.Fprintf(s.writer, "event: end\nid: %d\ndata: %s\n\n", event.Offset, data)
+	} else {
+		_, err = fmt.Fprintf(s.writer, "id: %d\ndata: %s\n\n", event.Offset+event.Length, data)
+	}
+	return err
+}
+
+func (s *sseSender) Flush() {
+	if flusher, canFlush := s.writer.(http.Flusher); canFlush {
+		flusher.Flush()
+	}
+}
+
+// websocketSender sends log events as websocket text messages.
+type websocketSender struct {
+	conn *websocket.Conn
+}
+
+func (s *websocketSender) Send(event *logEvent) error {
+	return s.conn.WriteJSON(event)
+}
+
+func (s *websocketSender) Flush() {
+}
+
+var upgrader = websocket.Upgrader{
+	// Access is controlled by the access token, just as for (cross-origin)
+	// GET requests
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// The offset to start streaming events from, taken from the Last-Event-ID
+// header sent by browsers when an event stream reconnects, or else from the
+// `offset` query parameter.
+func eventsStartOffset(req *http.Request) (int64, error) {
+	value := req.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = req.URL.Query().Get("offset")
+	}
+	if value == "" {
+		return 0, nil
+	}
+	offset, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("Invalid offset %q", value)
+	}
+	return offset, nil
+}
+
+// HTTP logic for serving the contents of a stream as Server-Sent Events, or as
+// websocket messages if the request is a websocket upgrade.
+func getEvents(
+	stream *stream.Stream,
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	writer.Header().Set("Access-Control-Allow-Origin", "*")
+
+	start, err := eventsStartOffset(req)
+	if err != nil {
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, err)
+		return
+	}
+
+	if websocket.IsWebSocketUpgrade(req) {
+		getEventsWebsocket(stream, writer, req, start)
+		return
+	}
+
+	handle := stream.Observe(start, math.MaxInt64)
+	defer stream.Unobserve(handle)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(200)
+
+	events := &eventWriter{sender: &sseSender{writer: writer}, offset: start}
+	_, err = handle.WriteTo(events)
+	if err == nil {
+		err = events.End()
+	}
+	if err != nil {
+		// The client will reconnect with Last-Event-ID and resume from there
+		log.Println("Error during write...", err)
+		abort(writer)
+	}
+}
+
+func getEventsWebsocket(
+	stream *stream.Stream,
+	writer http.ResponseWriter,
+	req *http.Request,
+	start int64,
+) {
+	conn, err := upgrader.Upgrade(writer, req, nil)
+	if err != nil {
+		// Upgrade has already replied with an error
+		log.Printf("websocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// Read (and discard) anything the client sends, so that control messages
+	// are processed
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	handle := stream.Observe(start, math.MaxInt64)
+	defer stream.Unobserve(handle)
+
+	events := &eventWriter{sender: &websocketSender{conn: conn}, offset: start}
+	_, err = handle.WriteTo(events)
+	if err == nil {
+		err = events.End()
+	}
+	if err != nil {
+		log.Println("Error during write...", err)
+		return
+	}
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSender struct {
+	events []logEvent
+}
+
+func (s *recordingSender) Send(event *logEvent) error {
+	s.events = append(s.events, *event)
+	return nil
+}
+
+func (s *recordingSender) Flush() {
+}
+
+func TestEventWriterSplitRune(t *testing.T) {
+	sender := &recordingSender{}
+	w := &eventWriter{sender: sender, offset: 10}
+
+	// "é" is encoded as 0xc3 0xa9
+	_, err := w.Write([]byte("abc\xc3"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("\xa9def"))
+	require.NoError(t, err)
+	require.NoError(t, w.End())
+
+	require.Equal(t, []logEvent{
+		{Offset: 10, Length: 3, Data: "abc"},
+		{Offset: 13, Length: 5, Data: "édef"},
+		{Offset: 18, End: true},
+	}, sender.events)
+}
+
+func putLog(t *testing.T, ts *TestLivelogServer, logContents string) {
+	body := ioutil.NopCloser(strings.NewReader(logContents))
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://127.0.0.1:%d/log", ts.PutPort()), body)
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, 201, res.StatusCode)
+}
+
+func TestServerSentEvents(t *testing.T) {
+	ts := StartServer(t, false)
+	defer ts.Close()
+
+	putLog(t, ts, "line 1\nline 2\n")
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%d/events/7_3HoMEbQau1Qlzwx-JZgg", ts.GetPort()), nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "7")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, 200, res.StatusCode)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	// collect the fields of each event
+	events := []map[string]string{}
+	event := map[string]string{}
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			events = append(events, event)
+			event = map[string]string{}
+			continue
+		}
+		parts := strings.SplitN(line, ": ", 2)
+		require.Len(t, parts, 2)
+		event[parts[0]] = parts[1]
+	}
+	require.NoError(t, scanner.Err())
+
+	require.Equal(t, []map[string]string{
+		{"id": "14", "data": `{"offset":7,"length":7,"data":"line 2\n"}`},
+		{"event": "end", "id": "14", "data": `{"offset":14,"length":0,"data":"","end":true}`},
+	}, events)
+}
+
+func TestWebsocketEvents(t *testing.T) {
+	ts := StartServer(t, false)
+	defer ts.Close()
+
+	putLog(t, ts, "line 1\nline 2\n")
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/events/7_3HoMEbQau1Qlzwx-JZgg", ts.GetPort()), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	events := []logEvent{}
+	for {
+		_, message, err := conn.ReadMessage()
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			break
+		}
+		require.NoError(t, err)
+		var event logEvent
+		require.NoError(t, json.Unmarshal(message, &event))
+		events = append(events, event)
+	}
+
+	require.Equal(t, []logEvent{
+		{Offset: 0, Length: 14, Data: "line 1\nline 2\n"},
+		{Offset: 14, End: true},
+	}, events)
+}
+
+func TestEventsWrongAccessToken(t *testing.T) {
+	ts := StartServer(t, false)
+	defer ts.Close()
+
+	putLog(t, ts, "hi")
+
+	res, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/events/bad-access-token", ts.GetPort()))
+	require.NoError(t, err)
+	require.Equal(t, 401, res.StatusCode)
+}
@@ -47,9 +47,15 @@ type TestLivelogServer struct {
 	getServer *http.Server
 
 	tempdir string
+
+	retention writer.Retention
 }
 
 func StartServer(t *testing.T, tls bool) *TestLivelogServer {
+	return StartServerWithRetention(t, tls, writer.Retention{})
+}
+
+func StartServerWithRetention(t *testing.T, tls bool, retention writer.Retention) *TestLivelogServer {
 	tempdir, err := ioutil.TempDir("", "livelog-tests-")
 	require.NoError(t, err)
 
@@ -65,6 +71,8 @@ func StartServer(t *testing.T, tls bool) *TestLivelogServer {
 		getServer: nil,
 
 		tempdir: tempdir,
+
+		retention: retention,
 	}
 
 	runServer = func(server *http.Server, addr, crtFile, keyFile string) error {
@@ -113,7 +121,7 @@ func StartServer(t *testing.T, tls bool) *TestLivelogServer {
 		os.Unsetenv("SERVER_KEY_FILE")
 	}
 
-	go serve(":putport", ":getport")
+	go serve(":putport", ":getport", ts.retention)
 
 	return ts
 }
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	stream "github.com/taskcluster/taskcluster/v30/tools/livelog/writer"
 )
@@ -36,27 +41,52 @@ func abort(writer http.ResponseWriter) {
 	conn.Close()
 }
 
-func startLogServe(stream *stream.Stream, getAddr string) {
-	// Get access token from environment variable
-	accessToken := os.Getenv("ACCESS_TOKEN")
+// A streamHandler serves the contents of a stream over HTTP.
+type streamHandler func(stream *stream.Stream, writer http.ResponseWriter, req *http.Request)
 
+// A streamLookup returns the stream with the given name, or nil if no such
+// stream has been PUT.  The default stream has the empty name.
+type streamLookup func(name string) *stream.Stream
+
+func startLogServe(lookup streamLookup, getAddr string, tokens *tokenIssuer) {
 	routes := http.NewServeMux()
-	routes.HandleFunc("/log/", func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("output %s %s", r.Method, r.URL.String())
-
-		// Authenticate the request with accessToken, this is good enough because
-		// live logs are short-lived, we do this by slicing away '/log/' from the
-		// URL and comparing the reminder to the accessToken, ensuring a URL pattern
-		// /log/<accessToken>
-		if r.URL.String()[5:] != accessToken {
+	handleWithAccessToken := func(prefix string, handler streamHandler) {
+		routes.HandleFunc(prefix, func(w http.ResponseWriter, r *http.Request) {
+			log.Printf("output %s %s", r.Method, r.URL.String())
+
 			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.WriteHeader(401)
-			fmt.Fprint(w, "Access denied")
-		} else {
-			getLog(stream, w, r)
-		}
-	})
+
+			// Authenticate the request with the access token, this is good enough
+			// because live logs are short-lived, we do this by slicing away the prefix
+			// from the URL path and checking the reminder, ensuring a URL pattern
+			// <prefix><token>[/<stream-name>].  The token is either the static
+			// ACCESS_TOKEN or a viewer token minted by the PUT side, and must not
+			// have expired or been revoked.
+			token, name := r.URL.Path[len(prefix):], ""
+			if slash := strings.Index(token, "/"); slash >= 0 {
+				token, name = token[:slash], token[slash+1:]
+			}
+			claims, err := tokens.Verify(token, time.Now())
+			if err != nil {
+				log.Printf("access denied: %v", err)
+				w.WriteHeader(401)
+				fmt.Fprint(w, "Access denied")
+				return
+			}
+			log.Printf("viewer %s (token %s)", claims.Viewer, claims.ID)
+
+			stream := lookup(name)
+			if stream == nil {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprintf(w, "No stream named %q", name)
+				return
+			}
+			handler(stream, w, r)
+		})
+	}
+	handleWithAccessToken("/log/", getLog)
+	handleWithAccessToken("/events/", getEvents)
 
 	server := http.Server{
 		Handler: routes,
@@ -80,24 +110,55 @@ func startLogServe(stream *stream.Stream, getAddr string) {
 }
 
 // HTTP logic for serving the contents of a stream...
+//
+// A request with a `Range` header is served from the backing file, with a 206
+// Partial Content response, and is limited to the bytes that have already
+// been written.  Otherwise the stream is followed until it ends, beginning at
+// the offset given in the `offset` query parameter (default 0) so that
+// clients which reconnect can resume from where they left off.
 func getLog(
 	stream *stream.Stream,
 	writer http.ResponseWriter,
 	req *http.Request,
 ) {
-	// NOTE: this once attempted to support Range requests, but did so incorrectly:
-	//
-	// (a) returned bytes beginning at offset zero, even if the range did not
-	//   begin there, when and only when those bytes had already been written to
-	//   the backing store
-	// (b) did not respond with 206 Partial Content
-	// (c) did not respond with a Content-Range header
-	// (d) was tested in such a way to to not trigger bug (a) and not check for
-	//   (b) or (c)
-	//
-	// On the concluaion that such requests are not used, support has been
-	// removed.
-	handle := stream.Observe(0, math.MaxInt64)
+	// TODO: Allow the input stream to configure headers rather then assume
+	// intentions...
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writer.Header().Set("Access-Control-Allow-Origin", "*")
+	writer.Header().Set("Access-Control-Expose-Headers", "Transfer-Encoding, Content-Range, Accept-Ranges")
+	writer.Header().Set("Accept-Ranges", "bytes")
+
+	log.Printf("%v", req.Header)
+
+	if rangeHeader := req.Header.Get("Range"); rangeHeader != "" {
+		offset, _ := stream.GetState()
+		start, end, err := parseRange(rangeHeader, offset)
+		switch err {
+		case nil:
+			getLogRange(stream, writer, start, end)
+			return
+		case errRangeNotSatisfiable:
+			writer.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", offset))
+			writer.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		// An invalid or unsupported (e.g. multipart) range is ignored, as
+		// permitted by RFC 7233, and the whole stream is returned
+		log.Printf("ignoring range %q: %v", rangeHeader, err)
+	}
+
+	var start int64
+	if offsetParam := req.URL.Query().Get("offset"); offsetParam != "" {
+		var err error
+		start, err = strconv.ParseInt(offsetParam, 10, 64)
+		if err != nil || start < 0 {
+			writer.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(writer, "Invalid offset %q", offsetParam)
+			return
+		}
+	}
+
+	handle := stream.Observe(start, math.MaxInt64)
 
 	defer func() {
 		// Ensure we close our file handle...
@@ -106,14 +167,6 @@ func getLog(
 		log.Print("send connection close...")
 	}()
 
-	// TODO: Allow the input stream to configure headers rather then assume
-	// intentions...
-	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	writer.Header().Set("Access-Control-Allow-Origin", "*")
-	writer.Header().Set("Access-Control-Expose-Headers", "Transfer-Encoding")
-
-	log.Printf("%v", req.Header)
-
 	// Send headers so its clear what we are trying to do...
 	writer.WriteHeader(200)
 	log.Print("wrote headers...")
@@ -126,6 +179,54 @@ func getLog(
 	}
 }
 
+// Serve the bytes [start, end) of the stream, which must already have been
+// written to the backing file, as a 206 Partial Content response.  The
+// complete length is only known once the stream has ended.
+func getLogRange(
+	stream *stream.Stream,
+	writer http.ResponseWriter,
+	start, end int64,
+) {
+	// Only serve retained bytes; if the range overlaps bytes which have been
+	// elided, the response is limited to the part of the range before them,
+	// or else after them, as described by the Content-Range header.
+	if elidedStart, elidedEnd := stream.Elided(); start < elidedEnd && end > elidedStart {
+		if start >= elidedStart {
+			start = elidedEnd
+		} else {
+			end = elidedStart
+		}
+	}
+	if start >= end {
+		offset, _ := stream.GetState()
+		writer.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", offset))
+		writer.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	reader, err := stream.NewReader()
+	if err != nil {
+		log.Printf("could not open backing file: %v", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	completeLength := "*"
+	if offset, ended := stream.GetState(); ended {
+		completeLength = strconv.FormatInt(offset, 10)
+	}
+	writer.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", start, end-1, completeLength))
+	writer.Header().Set("Content-Length", strconv.FormatInt(end-start, 10))
+	writer.WriteHeader(http.StatusPartialContent)
+
+	_, err = io.Copy(writer, io.NewSectionReader(reader, start, end-start))
+	if err != nil {
+		log.Println("Error during write...", err)
+		abort(writer)
+	}
+}
+
 // Logic here mostly inspired by what docker does...
 func attachProfiler(router *http.ServeMux) {
 	router.HandleFunc("/debug/pprof/", pprof.Index)
@@ -165,20 +266,51 @@ func main() {
 		return
 	}
 
+	// bytesOrExit is a helper function to translate a number of bytes in an
+	// environment variable into an int64, exiting if it is invalid.
+	bytesOrExit := func(envVar string, exitCode int) (n int64) {
+		if value := os.Getenv(envVar); value != "" {
+			var err error
+			n, err = strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				log.Printf("env var %v is not a non-negative number (%v)", envVar, value)
+				os.Exit(exitCode)
+			}
+		}
+		return
+	}
+
 	putAddr := portAddressOrExit("LIVELOG_PUT_PORT", DEFAULT_PUT_PORT, 64, 65)
 	getAddr := portAddressOrExit("LIVELOG_GET_PORT", DEFAULT_GET_PORT, 66, 67)
+	retention := stream.Retention{
+		HeadBytes: bytesOrExit("LIVELOG_HEAD_BYTES", 68),
+		TailBytes: bytesOrExit("LIVELOG_TAIL_BYTES", 69),
+	}
 
 	runServer = func(server *http.Server, addr, crtFile, keyFile string) error {
 		server.Addr = addr
 		return server.ListenAndServe()
 	}
 
-	serve(putAddr, getAddr)
+	serve(putAddr, getAddr, retention)
 }
 
-func serve(putAddr, getAddr string) {
-	handlingPut := false
+// Stream names may only contain these characters
+var streamNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+func serve(putAddr, getAddr string, retention stream.Retention) {
+	// the streams which have been PUT, by name; the default stream (PUT to
+	// /log) has the empty name
+	streams := map[string]*stream.Stream{}
 	mutex := sync.Mutex{}
+	lookup := func(name string) *stream.Stream {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return streams[name]
+	}
+	startLogServeOnce := sync.Once{}
+
+	tokens := newTokenIssuer([]byte(os.Getenv("LIVELOG_TOKEN_SECRET")), os.Getenv("ACCESS_TOKEN"))
 
 	routes := http.NewServeMux()
 
@@ -193,7 +325,9 @@ func serve(putAddr, getAddr string) {
 	// The "main" http server is for the PUT side which should not be exposed
 	// publicly but via links in the docker container... In the future we can
 	// handle something fancier.
-	routes.HandleFunc("/log", func(w http.ResponseWriter, r *http.Request) {
+	//
+	// The default stream is PUT to /log, and named streams to /log/<name>.
+	putLog := func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("input %s %s", r.Method, r.URL.String())
 
 		if r.Method != "PUT" {
@@ -203,43 +337,77 @@ func serve(putAddr, getAddr string) {
 			return
 		}
 
-		// Threadsafe checking of the `handlingPut` flag
+		name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/log"), "/")
+		if name != "" && !streamNameRegexp.MatchString(name) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("Stream names may only contain letters, digits, '_', '.' and '-'"))
+			return
+		}
+
+		// Threadsafe checking that the stream has not already been PUT
 		mutex.Lock()
-		if handlingPut {
-			log.Print("Attempt to put when in progress")
+		if _, exists := streams[name]; exists {
+			log.Printf("Attempt to put stream %q when already put", name)
 			w.WriteHeader(http.StatusBadRequest)
-			_, _ = w.Write([]byte("This endpoint can only process one http PUT at a time"))
+			_, _ = w.Write([]byte("This endpoint can only process one http PUT per stream"))
 			mutex.Unlock() // used instead of defer so we don't block other rejections
 			return
 		}
-		mutex.Unlock() // So we don't block other rejections...
 
-		stream, streamErr := stream.NewStream(r.Body)
+		stream, streamErr := stream.NewStream(r.Body, retention)
 
 		if streamErr != nil {
+			// Allow for retries of the initial put if something goes wrong...
+			mutex.Unlock()
 			log.Printf("input stream open err %v", streamErr)
 			w.WriteHeader(http.StatusInternalServerError)
 			_, _ = w.Write([]byte("Could not open stream for body"))
-
-			// Allow for retries of the initial put if something goes wrong...
-			mutex.Lock()
-			handlingPut = false
-			mutex.Unlock()
+			return
 		}
 
+		streams[name] = stream
+		mutex.Unlock()
+
 		// Signal initial success...
 		w.WriteHeader(http.StatusCreated)
 
-		// Initialize the sub server in another go routine...
-		log.Print("Begin consuming...")
-		go startLogServe(stream, getAddr)
+		// Initialize the sub server in another go routine, once the first
+		// stream has been PUT...
+		log.Printf("Begin consuming %q...", name)
+		startLogServeOnce.Do(func() {
+			go startLogServe(lookup, getAddr, tokens)
+		})
 		consumeErr := stream.Consume()
 		if consumeErr != nil {
 			log.Println("Error finalizing consume of stream", consumeErr)
 			abort(w)
 			return
 		}
-	})
+	}
+	routes.HandleFunc("/log", putLog)
+	routes.HandleFunc("/log/", putLog)
+
+	// Metrics about the streams and their observers are served on the PUT
+	// side, which is not exposed publicly: /metrics for the default stream and
+	// /metrics/<name> for named streams.
+	metrics := func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/metrics"), "/")
+		s := lookup(name)
+		if s == nil {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprintf(w, "No stream named %q has been PUT", name)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = json.NewEncoder(w).Encode(s.Metrics())
+	}
+	routes.HandleFunc("/metrics", metrics)
+	routes.HandleFunc("/metrics/", metrics)
+
+	// Viewer tokens are minted and revoked on the PUT side, which is not
+	// exposed publicly.
+	routes.Handle("/tokens", tokens)
+	routes.Handle("/tokens/", tokens)
 
 	// Listen forever on the PUT side...
 	log.Printf("input server listening... %s", server.Addr)
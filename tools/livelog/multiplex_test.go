@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func putNamedLog(t *testing.T, ts *TestLivelogServer, name, logContents string) *http.Response {
+	body := ioutil.NopCloser(strings.NewReader(logContents))
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://127.0.0.1:%d/log/%s", ts.PutPort(), name), body)
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	return res
+}
+
+func TestNamedStreams(t *testing.T) {
+	ts := StartServer(t, false)
+	defer ts.Close()
+
+	require.Equal(t, 201, putNamedLog(t, ts, "stdout", "out").StatusCode)
+	require.Equal(t, 201, putNamedLog(t, ts, "stderr", "err").StatusCode)
+	putLog(t, ts, "default")
+
+	// each stream can only be PUT once
+	require.Equal(t, 400, putNamedLog(t, ts, "stdout", "again").StatusCode)
+	require.Equal(t, 400, putNamedLog(t, ts, "no%20spaces", "x").StatusCode)
+
+	get := func(path string) (int, string) {
+		res, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d%s", ts.GetPort(), path))
+		require.NoError(t, err)
+		defer res.Body.Close()
+		resBody, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(resBody)
+	}
+
+	for path, expected := range map[string]string{
+		"/log/7_3HoMEbQau1Qlzwx-JZgg":        "default",
+		"/log/7_3HoMEbQau1Qlzwx-JZgg/stdout": "out",
+		"/log/7_3HoMEbQau1Qlzwx-JZgg/stderr": "err",
+	} {
+		status, body := get(path)
+		require.Equal(t, 200, status, path)
+		require.Equal(t, expected, body, path)
+	}
+
+	status, _ := get("/log/7_3HoMEbQau1Qlzwx-JZgg/nosuchstream")
+	require.Equal(t, 404, status)
+	status, _ = get("/log/bad-access-token/stdout")
+	require.Equal(t, 401, status)
+
+	res, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics/stderr", ts.PutPort()))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, 200, res.StatusCode)
+}
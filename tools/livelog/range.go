@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	errRangeInvalid        = errors.New("invalid range")
+	errRangeNotSatisfiable = errors.New("range not satisfiable")
+)
+
+// parseRange parses the value of an HTTP Range header (RFC 7233) containing a
+// single byte range, and returns the offsets [start, end) it refers to in a
+// stream of which size bytes are currently available.  Ranges extending past
+// the available bytes are truncated.
+func parseRange(header string, size int64) (start, end int64, err error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return 0, 0, errRangeInvalid
+	}
+	spec := strings.TrimSpace(header[len(prefix):])
+	if strings.Contains(spec, ",") {
+		return 0, 0, errRangeInvalid
+	}
+	dash := strings.Index(spec, "-")
+	if dash < 0 {
+		return 0, 0, errRangeInvalid
+	}
+	first, last := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+
+	if first == "" {
+		// suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, errRangeInvalid
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errRangeInvalid
+	}
+	end = size
+	if last != "" {
+		lastByte, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || lastByte < start {
+			return 0, 0, errRangeInvalid
+		}
+		if lastByte+1 < end {
+			end = lastByte + 1
+		}
+	}
+	if start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	return start, end, nil
+}
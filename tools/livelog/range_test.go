@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	for _, tc := range []struct {
+		header     string
+		size       int64
+		start, end int64
+		err        error
+	}{
+		{"bytes=0-9", 100, 0, 10, nil},
+		{"bytes=10-", 100, 10, 100, nil},
+		{"bytes=90-200", 100, 90, 100, nil},
+		{"bytes=-10", 100, 90, 100, nil},
+		{"bytes=-200", 100, 0, 100, nil},
+		{"bytes=100-", 100, 0, 0, errRangeNotSatisfiable},
+		{"bytes=-0", 100, 0, 0, errRangeNotSatisfiable},
+		{"bytes=0-", 0, 0, 0, errRangeNotSatisfiable},
+		{"bytes=5-4", 100, 0, 0, errRangeInvalid},
+		{"bytes=0-1,5-6", 100, 0, 0, errRangeInvalid},
+		{"bytes=a-b", 100, 0, 0, errRangeInvalid},
+		{"lines=0-9", 100, 0, 0, errRangeInvalid},
+	} {
+		t.Run(tc.header, func(t *testing.T) {
+			start, end, err := parseRange(tc.header, tc.size)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.start, start)
+			require.Equal(t, tc.end, end)
+		})
+	}
+}
+
+func TestRangeAndOffset(t *testing.T) {
+	ts := StartServer(t, false)
+	defer ts.Close()
+
+	logContents := "0123456789abcdefghij"
+
+	putLog(t, ts, logContents)
+
+	logURL := fmt.Sprintf("http://127.0.0.1:%d/log/7_3HoMEbQau1Qlzwx-JZgg", ts.GetPort())
+	get := func(t *testing.T, url, rangeHeader string) (*http.Response, string) {
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		resBody, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, string(resBody)
+	}
+
+	t.Run("range", func(t *testing.T) {
+		res, resBody := get(t, logURL, "bytes=5-9")
+		require.Equal(t, 206, res.StatusCode)
+		require.Equal(t, "bytes 5-9/20", res.Header.Get("Content-Range"))
+		require.Equal(t, "56789", resBody)
+	})
+
+	t.Run("suffix range", func(t *testing.T) {
+		res, resBody := get(t, logURL, "bytes=-3")
+		require.Equal(t, 206, res.StatusCode)
+		require.Equal(t, "bytes 17-19/20", res.Header.Get("Content-Range"))
+		require.Equal(t, "hij", resBody)
+	})
+
+	t.Run("unsatisfiable range", func(t *testing.T) {
+		res, _ := get(t, logURL, "bytes=20-")
+		require.Equal(t, 416, res.StatusCode)
+		require.Equal(t, "bytes */20", res.Header.Get("Content-Range"))
+	})
+
+	t.Run("offset", func(t *testing.T) {
+		res, resBody := get(t, logURL+"?offset=12", "")
+		require.Equal(t, 200, res.StatusCode)
+		require.Equal(t, "cdefghij", resBody)
+	})
+
+	t.Run("offset past end", func(t *testing.T) {
+		res, resBody := get(t, logURL+"?offset=50", "")
+		require.Equal(t, 200, res.StatusCode)
+		require.Equal(t, "", resBody)
+	})
+
+	t.Run("invalid offset", func(t *testing.T) {
+		res, _ := get(t, logURL+"?offset=-1", "")
+		require.Equal(t, 400, res.StatusCode)
+	})
+}
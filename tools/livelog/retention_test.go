@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/taskcluster/v30/tools/livelog/writer"
+)
+
+func TestRetention(t *testing.T) {
+	ts := StartServerWithRetention(t, false, writer.Retention{HeadBytes: 10, TailBytes: 10})
+	defer ts.Close()
+
+	logContents := "head......" + strings.Repeat("-", 100) + "......tail"
+	putLog(t, ts, logContents)
+
+	logURL := fmt.Sprintf("http://127.0.0.1:%d/log/7_3HoMEbQau1Qlzwx-JZgg", ts.GetPort())
+
+	res, err := http.Get(logURL)
+	require.NoError(t, err)
+	resBody, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, "head......\n[... 100 bytes elided ...]\n......tail", string(resBody))
+
+	// a range starting in the elided bytes is served from the tail
+	req, err := http.NewRequest("GET", logURL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Range", "bytes=50-")
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resBody, err = ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, 206, res.StatusCode)
+	require.Equal(t, "bytes 110-119/120", res.Header.Get("Content-Range"))
+	require.Equal(t, "......tail", string(resBody))
+
+	res, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", ts.PutPort()))
+	require.NoError(t, err)
+	var metrics writer.Metrics
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&metrics))
+	require.Equal(t, int64(120), metrics.BytesReceived)
+	require.Equal(t, int64(20), metrics.BytesRetained)
+	require.Equal(t, int64(100), metrics.BytesElided)
+	require.True(t, metrics.Ended)
+}
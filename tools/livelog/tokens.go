@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/taskcluster/slugid-go/slugid"
+)
+
+var (
+	errTokenInvalid = errors.New("invalid token")
+	errTokenExpired = errors.New("token has expired")
+	errTokenRevoked = errors.New("token has been revoked")
+)
+
+// The ID of the claims of the static ACCESS_TOKEN, which is not a minted token
+const accessTokenID = "ACCESS_TOKEN"
+
+// tokenClaims are the signed contents of a viewer token.
+type tokenClaims struct {
+	ID     string `json:"id"`
+	Viewer string `json:"viewer"`
+	// Time the token was issued, in nanoseconds since the epoch
+	IssuedAt int64 `json:"iat"`
+	// Time the token expires, in seconds since the epoch
+	Expires int64 `json:"exp"`
+}
+
+// tokenIssuer mints and verifies time-limited, per-viewer access tokens of
+// the form <base64url(json claims)>.<base64url(HMAC-SHA256 of claims)>.  It
+// also verifies the static access token, if there is one, which is valid until
+// it is revoked.
+type tokenIssuer struct {
+	secret      []byte
+	accessToken string
+
+	// mutex covers the fields below
+	mutex sync.Mutex
+	// revoked token IDs, mapped to the time the token expires anyway
+	revoked map[string]time.Time
+	// tokens issued before this time have been revoked
+	notBefore time.Time
+	// the static access token has been revoked
+	accessTokenRevoked bool
+}
+
+// newTokenIssuer returns a tokenIssuer signing tokens with the given secret,
+// or with a random secret if none is given, in which case tokens are only
+// valid for the lifetime of the process.  If accessToken is not empty, it is
+// also accepted as a token until revoked.
+func newTokenIssuer(secret []byte, accessToken string) *tokenIssuer {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+	}
+	return &tokenIssuer{
+		secret:      secret,
+		accessToken: accessToken,
+		revoked:     map[string]time.Time{},
+	}
+}
+
+func (ti *tokenIssuer) sign(payload string) string {
+	mac := hmac.New(sha256.New, ti.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Mint returns a new token for the given viewer, valid until expires.
+func (ti *tokenIssuer) Mint(viewer string, expires time.Time) (string, *tokenClaims) {
+	claims := &tokenClaims{
+		ID:       slugid.Nice(),
+		Viewer:   viewer,
+		IssuedAt: time.Now().UnixNano(),
+		Expires:  expires.Unix(),
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		panic(err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + ti.sign(payload), claims
+}
+
+// Verify checks that the token was minted by this issuer, or is the static
+// access token, and has neither expired nor been revoked.
+func (ti *tokenIssuer) Verify(token string, now time.Time) (*tokenClaims, error) {
+	if ti.accessToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(ti.accessToken)) == 1 {
+		claims := &tokenClaims{ID: accessTokenID, Viewer: accessTokenID}
+		ti.mutex.Lock()
+		defer ti.mutex.Unlock()
+		if ti.accessTokenRevoked {
+			return claims, errTokenRevoked
+		}
+		return claims, nil
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(ti.sign(parts[0])), []byte(parts[1])) {
+		return nil, errTokenInvalid
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errTokenInvalid
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, errTokenInvalid
+	}
+	if now.Unix() >= claims.Expires {
+		return &claims, errTokenExpired
+	}
+
+	ti.mutex.Lock()
+	defer ti.mutex.Unlock()
+	if _, revoked := ti.revoked[claims.ID]; revoked || claims.IssuedAt < ti.notBefore.UnixNano() {
+		return &claims, errTokenRevoked
+	}
+	return &claims, nil
+}
+
+// Revoke revokes the token with the given ID.
+func (ti *tokenIssuer) Revoke(id string, expires time.Time) {
+	ti.mutex.Lock()
+	defer ti.mutex.Unlock()
+	if id == accessTokenID {
+		ti.accessTokenRevoked = true
+		return
+	}
+	ti.revoked[id] = expires
+
+	// tokens which have expired anyway need not be remembered
+	now := time.Now()
+	for id, expires := range ti.revoked {
+		if now.After(expires) {
+			delete(ti.revoked, id)
+		}
+	}
+}
+
+// RevokeAll revokes all tokens issued so far, and the static access token.
+func (ti *tokenIssuer) RevokeAll() {
+	ti.mutex.Lock()
+	defer ti.mutex.Unlock()
+	ti.notBefore = time.Now()
+	ti.accessTokenRevoked = true
+	ti.revoked = map[string]time.Time{}
+}
+
+// tokenRequest is the body of a request to mint a token.
+type tokenRequest struct {
+	Viewer  string    `json:"viewer"`
+	Expires time.Time `json:"expires"`
+}
+
+// tokenResponse is the response to a request to mint a token.
+type tokenResponse struct {
+	ID      string    `json:"id"`
+	Viewer  string    `json:"viewer"`
+	Expires time.Time `json:"expires"`
+	Token   string    `json:"token"`
+}
+
+// HTTP logic for minting and revoking tokens:
+//
+//	POST /tokens                   mint a token (body is a tokenRequest)
+//	DELETE /tokens/<token>         revoke a token
+//	DELETE /tokens                 revoke all tokens
+func (ti *tokenIssuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/tokens"), "/")
+	switch {
+	case r.Method == "POST" && token == "":
+		var req tokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid token request: %v", err)
+			return
+		}
+		if req.Viewer == "" || !req.Expires.After(time.Now()) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Token requests require a viewer and an expiry time in the future")
+			return
+		}
+		token, claims := ti.Mint(req.Viewer, req.Expires)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(&tokenResponse{
+			ID:      claims.ID,
+			Viewer:  claims.Viewer,
+			Expires: time.Unix(claims.Expires, 0).UTC(),
+			Token:   token,
+		})
+	case r.Method == "DELETE" && token == "":
+		ti.RevokeAll()
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "DELETE":
+		claims, err := ti.Verify(token, time.Now())
+		switch err {
+		case nil, errTokenRevoked:
+			ti.Revoke(claims.ID, time.Unix(claims.Expires, 0))
+		case errTokenExpired:
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenIssuer(t *testing.T) {
+	ti := newTokenIssuer([]byte("secret"), "static")
+	now := time.Now()
+
+	token, claims := ti.Mint("alice", now.Add(time.Hour))
+	verified, err := ti.Verify(token, now)
+	require.NoError(t, err)
+	require.Equal(t, claims, verified)
+	require.Equal(t, "alice", verified.Viewer)
+
+	_, err = ti.Verify(token, now.Add(2*time.Hour))
+	require.Equal(t, errTokenExpired, err)
+
+	_, err = ti.Verify(token[:len(token)-2], now)
+	require.Equal(t, errTokenInvalid, err)
+
+	_, err = newTokenIssuer([]byte("other secret"), "").Verify(token, now)
+	require.Equal(t, errTokenInvalid, err)
+
+	_, err = ti.Verify("not-a-token", now)
+	require.Equal(t, errTokenInvalid, err)
+
+	other, _ := ti.Mint("bob", now.Add(time.Hour))
+	ti.Revoke(claims.ID, now.Add(time.Hour))
+	_, err = ti.Verify(token, now)
+	require.Equal(t, errTokenRevoked, err)
+	_, err = ti.Verify(other, now)
+	require.NoError(t, err)
+
+	staticClaims, err := ti.Verify("static", now)
+	require.NoError(t, err)
+	require.Equal(t, accessTokenID, staticClaims.ID)
+
+	ti.RevokeAll()
+	_, err = ti.Verify(other, now)
+	require.Equal(t, errTokenRevoked, err)
+	_, err = ti.Verify("static", now)
+	require.Equal(t, errTokenRevoked, err)
+	third, _ := ti.Mint("carol", now.Add(time.Hour))
+	_, err = ti.Verify(third, now)
+	require.NoError(t, err)
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	ti := newTokenIssuer([]byte("secret"), "static")
+	now := time.Now()
+
+	claims, err := ti.Verify("static", now)
+	require.NoError(t, err)
+	ti.Revoke(claims.ID, now)
+	_, err = ti.Verify("static", now)
+	require.Equal(t, errTokenRevoked, err)
+
+	// minted tokens are not affected
+	token, _ := ti.Mint("alice", now.Add(time.Hour))
+	_, err = ti.Verify(token, now)
+	require.NoError(t, err)
+}
+
+func TestViewerTokens(t *testing.T) {
+	ts := StartServer(t, false)
+	defer ts.Close()
+
+	putLog(t, ts, "hello")
+
+	tokensURL := fmt.Sprintf("http://127.0.0.1:%d/tokens", ts.PutPort())
+	mint := func(expires time.Time) (*http.Response, tokenResponse) {
+		body, err := json.Marshal(&tokenRequest{Viewer: "alice", Expires: expires})
+		require.NoError(t, err)
+		res, err := http.Post(tokensURL, "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var tr tokenResponse
+		if res.StatusCode == 201 {
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&tr))
+		}
+		return res, tr
+	}
+	getStatus := func(token string) int {
+		res, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/log/%s", ts.GetPort(), token))
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	revoke := func(url string) {
+		req, err := http.NewRequest("DELETE", url, nil)
+		require.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, 204, res.StatusCode)
+	}
+
+	res, _ := mint(time.Now().Add(-time.Minute))
+	require.Equal(t, 400, res.StatusCode)
+
+	res, tr := mint(time.Now().Add(time.Hour))
+	require.Equal(t, 201, res.StatusCode)
+	require.Equal(t, "alice", tr.Viewer)
+	require.Equal(t, 200, getStatus(tr.Token))
+
+	// the static access token continues to work
+	require.Equal(t, 200, getStatus("7_3HoMEbQau1Qlzwx-JZgg"))
+
+	revoke(tokensURL + "/" + tr.Token)
+	require.Equal(t, 401, getStatus(tr.Token))
+
+	_, tr = mint(time.Now().Add(time.Hour))
+	require.Equal(t, 200, getStatus(tr.Token))
+	revoke(tokensURL)
+	require.Equal(t, 401, getStatus(tr.Token))
+
+	// revoking all tokens also revokes the static access token
+	require.Equal(t, 401, getStatus("7_3HoMEbQau1Qlzwx-JZgg"))
+}
@@ -1,6 +1,7 @@
 package writer
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -30,19 +31,61 @@ var emptyStruct = struct{}{}
 // reliably clean up.
 var TempDir = ""
 
-type Stream struct {
-	Path   string
-	reader *io.Reader
+// Retention limits how much of a stream is kept in its backing files.  The
+// first HeadBytes and the last TailBytes of the stream are kept, and anything
+// in between is elided.  The zero value keeps the whole stream.
+type Retention struct {
+	HeadBytes int64
+	TailBytes int64
+}
+
+func (r Retention) limited() bool {
+	return r.HeadBytes > 0 || r.TailBytes > 0
+}
+
+// ErrElided is returned when reading bytes of a stream which have not been
+// retained.
+var ErrElided = errors.New("bytes have been elided from the stream")
+
+// Metrics describes the state of a stream and of its observers, in particular
+// observers which fail to keep up with the stream.
+type Metrics struct {
+	BytesReceived int64 `json:"bytesReceived"`
+	BytesRetained int64 `json:"bytesRetained"`
+	BytesElided   int64 `json:"bytesElided"`
+	Ended         bool  `json:"ended"`
+	Observers     int   `json:"observers"`
+	// The largest number of events currently waiting to be written to a
+	// single observer, and the largest number seen so far
+	PendingEvents     int `json:"pendingEvents"`
+	PeakPendingEvents int `json:"peakPendingEvents"`
+	// The number of observers removed for failing to keep up
+	DroppedObservers int `json:"droppedObservers"`
+}
 
-	// mutex covers all of the fields below
-	mutex   sync.Mutex
-	file    os.File
-	offset  int64
-	ended   bool
-	handles Handles
+type Stream struct {
+	// Path of the file containing the head of the stream (or the whole stream,
+	// if retention is not limited)
+	Path string
+	// Path of the file containing the tail of the stream, used as a ring
+	// buffer of Retention.TailBytes bytes
+	TailPath  string
+	Retention Retention
+	reader    *io.Reader
+
+	// mutex covers all of the fields below, and reads and writes of the
+	// backing files
+	mutex             sync.Mutex
+	file              os.File
+	tail              *os.File
+	offset            int64
+	ended             bool
+	handles           Handles
+	peakPendingEvents int
+	droppedHandles    int
 }
 
-func NewStream(read io.Reader) (*Stream, error) {
+func NewStream(read io.Reader, retention Retention) (*Stream, error) {
 	dir, err := ioutil.TempDir(TempDir, "livelog")
 	if err != nil {
 		return nil, err
@@ -58,13 +101,27 @@ func NewStream(read io.Reader) (*Stream, error) {
 		return nil, openErr
 	}
 
+	var tail *os.File
+	tailPath := ""
+	if retention.TailBytes > 0 {
+		tailPath = dir + "/tail"
+		tail, openErr = os.OpenFile(tailPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+		if openErr != nil {
+			file.Close()
+			return nil, openErr
+		}
+	}
+
 	return &Stream{
-		Path:   path,
-		mutex:  sync.Mutex{},
-		offset: 0,
-		reader: &read,
-		ended:  false,
-		file:   *file,
+		Path:      path,
+		TailPath:  tailPath,
+		Retention: retention,
+		mutex:     sync.Mutex{},
+		offset:    0,
+		reader:    &read,
+		ended:     false,
+		file:      *file,
+		tail:      tail,
 
 		handles: Handles{},
 	}, nil
@@ -95,6 +152,254 @@ func (self *Stream) GetState() (offset int64, ended bool) {
 	return
 }
 
+// Elided returns the range of offsets [start, end) which have not been
+// retained (so far).  If nothing has been elided, start == end.
+func (self *Stream) Elided() (start, end int64) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.elided()
+}
+
+func (self *Stream) elided() (start, end int64) {
+	if !self.Retention.limited() || self.offset <= self.Retention.HeadBytes+self.Retention.TailBytes {
+		return self.offset, self.offset
+	}
+	return self.Retention.HeadBytes, self.offset - self.Retention.TailBytes
+}
+
+// Metrics returns the current metrics of the stream.
+func (self *Stream) Metrics() Metrics {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	elidedStart, elidedEnd := self.elided()
+	metrics := Metrics{
+		BytesReceived:     self.offset,
+		BytesRetained:     self.offset - (elidedEnd - elidedStart),
+		BytesElided:       elidedEnd - elidedStart,
+		Ended:             self.ended,
+		Observers:         len(self.handles),
+		PeakPendingEvents: self.peakPendingEvents,
+		DroppedObservers:  self.droppedHandles,
+	}
+	for handle := range self.handles {
+		if pending := len(handle.events); pending > metrics.PendingEvents {
+			metrics.PendingEvents = pending
+		}
+	}
+	return metrics
+}
+
+// store writes bytes read from the input at the current offset to the
+// backing files, keeping only what the retention allows.  It must be called
+// with the mutex held.
+func (self *Stream) store(p []byte) error {
+	if !self.Retention.limited() {
+		_, err := self.file.Write(p)
+		return err
+	}
+	offset := self.offset
+	head := self.Retention.HeadBytes
+	if offset < head {
+		n := head - offset
+		if n > int64(len(p)) {
+			n = int64(len(p))
+		}
+		if _, err := self.file.Write(p[:n]); err != nil {
+			return err
+		}
+		offset += n
+		p = p[n:]
+	}
+	if self.tail == nil || len(p) == 0 {
+		return nil
+	}
+	// only the last TailBytes are retained
+	size := self.Retention.TailBytes
+	if int64(len(p)) > size {
+		offset += int64(len(p)) - size
+		p = p[int64(len(p))-size:]
+	}
+	pos := (offset - head) % size
+	first := size - pos
+	if first > int64(len(p)) {
+		first = int64(len(p))
+	}
+	if _, err := self.tail.WriteAt(p[:first], pos); err != nil {
+		return err
+	}
+	if first < int64(len(p)) {
+		if _, err := self.tail.WriteAt(p[first:], 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A StreamReader reads the retained bytes of a stream by offset.
+type StreamReader struct {
+	stream *Stream
+	file   *os.File
+	tail   *os.File
+}
+
+// NewReader opens the backing files of the stream for reading.  The
+// returned reader must be closed when no longer needed.
+func (self *Stream) NewReader() (*StreamReader, error) {
+	file, err := os.Open(self.Path)
+	if err != nil {
+		return nil, err
+	}
+	reader := &StreamReader{stream: self, file: file}
+	if self.TailPath != "" {
+		reader.tail, err = os.Open(self.TailPath)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return reader, nil
+}
+
+func (self *StreamReader) Close() error {
+	if self.tail != nil {
+		self.tail.Close()
+	}
+	return self.file.Close()
+}
+
+// ReadAt implements io.ReaderAt for the bytes of the stream received so far.
+// If some of the bytes requested have been elided, it returns ErrElided along
+// with the number of bytes read before the elided range.
+func (self *StreamReader) ReadAt(p []byte, off int64) (int, error) {
+	s := self.stream
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if off >= s.offset {
+		return 0, io.EOF
+	}
+	var eof error
+	if int64(len(p)) > s.offset-off {
+		p = p[:s.offset-off]
+		eof = io.EOF
+	}
+	if !s.Retention.limited() {
+		n, err := self.file.ReadAt(p, off)
+		if err == nil {
+			err = eof
+		}
+		return n, err
+	}
+
+	elidedStart, elidedEnd := s.elided()
+	read := 0
+	head := s.Retention.HeadBytes
+	if off < head {
+		m := head - off
+		if m > int64(len(p)) {
+			m = int64(len(p))
+		}
+		n, err := self.file.ReadAt(p[:m], off)
+		read += n
+		if err != nil {
+			return read, err
+		}
+		off += m
+		p = p[m:]
+		if len(p) == 0 {
+			return read, eof
+		}
+	}
+	if off >= elidedStart && off < elidedEnd {
+		return read, ErrElided
+	}
+
+	size := s.Retention.TailBytes
+	pos := (off - head) % size
+	first := size - pos
+	if first > int64(len(p)) {
+		first = int64(len(p))
+	}
+	n, err := self.tail.ReadAt(p[:first], pos)
+	read += n
+	if err != nil {
+		return read, err
+	}
+	if first < int64(len(p)) {
+		n, err = self.tail.ReadAt(p[first:], 0)
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, eof
+}
+
+// An ElisionWriter is notified of bytes that have been elided from a stream,
+// rather than being sent an elision marker.
+type ElisionWriter interface {
+	WriteElision(offset, length int64) error
+}
+
+func elisionMarker(length int64) []byte {
+	return []byte(fmt.Sprintf("\n[... %d bytes elided ...]\n", length))
+}
+
+// CopyRange writes the bytes [start, end) of the stream to w, replacing any
+// that have been elided with an elision marker, or calling WriteElision if w
+// is an ElisionWriter.  It returns the offset up to which the stream was
+// written.
+func (self *Stream) CopyRange(w io.Writer, start, end int64) (int64, error) {
+	reader, err := self.NewReader()
+	if err != nil {
+		return start, err
+	}
+	defer reader.Close()
+
+	buf := make([]byte, READ_BUFFER_SIZE*8)
+	offset := start
+	for offset < end {
+		elidedStart, elidedEnd := self.Elided()
+		if offset >= elidedStart && offset < elidedEnd {
+			if elidedEnd > end {
+				elidedEnd = end
+			}
+			if elisionWriter, ok := w.(ElisionWriter); ok {
+				err = elisionWriter.WriteElision(offset, elidedEnd-offset)
+			} else {
+				_, err = w.Write(elisionMarker(elidedEnd - offset))
+			}
+			if err != nil {
+				return offset, err
+			}
+			offset = elidedEnd
+			continue
+		}
+
+		chunk := buf
+		if int64(len(chunk)) > end-offset {
+			chunk = chunk[:end-offset]
+		}
+		n, readErr := reader.ReadAt(chunk, offset)
+		if n > 0 {
+			written, writeErr := w.Write(chunk[:n])
+			offset += int64(written)
+			if writeErr != nil {
+				return offset, writeErr
+			}
+		}
+		switch readErr {
+		case nil, ErrElided:
+			// the elided range has grown since it was checked
+		case io.EOF:
+			return offset, nil
+		default:
+			return offset, readErr
+		}
+	}
+	return offset, nil
+}
+
 func (self *Stream) Consume() error {
 	log.Print("consume")
 
@@ -104,6 +409,9 @@ func (self *Stream) Consume() error {
 		self.mutex.Lock()
 		defer self.mutex.Unlock()
 		self.file.Close()
+		if self.tail != nil {
+			self.tail.Close()
+		}
 
 		// Cleanup all handles after the consumption is complete...
 		log.Printf("removing %d handles", len(self.handles))
@@ -112,7 +420,6 @@ func (self *Stream) Consume() error {
 		}
 	}()
 
-	tee := io.TeeReader(*self.reader, &self.file)
 	eventNumber := 0
 	self.mutex.Lock()
 	defer self.mutex.Unlock()
@@ -120,7 +427,7 @@ func (self *Stream) Consume() error {
 		// read (which may block) without the lock held
 		self.mutex.Unlock()
 		buf := make([]byte, READ_BUFFER_SIZE)
-		bytesRead, readErr := tee.Read(buf)
+		bytesRead, readErr := (*self.reader).Read(buf)
 
 		// remainder of the loop body holds the lock
 		self.mutex.Lock()
@@ -128,6 +435,9 @@ func (self *Stream) Consume() error {
 		startOffset := self.offset
 
 		if bytesRead > 0 {
+			if storeErr := self.store(buf[:bytesRead]); storeErr != nil && readErr == nil {
+				readErr = storeErr
+			}
 			self.offset += int64(bytesRead)
 		}
 
@@ -153,13 +463,18 @@ func (self *Stream) Consume() error {
 		// Emit all the messages...
 		for handle := range self.handles {
 
-			// Don't write anything that starts after we end...
-			if event.Offset > handle.Stop || event.Offset+event.Length <= handle.Start {
+			// Don't write anything that starts after we end, but always
+			// deliver the final event so that the handle knows to stop
+			// waiting...
+			if !event.End && (event.Offset >= handle.Stop || event.Offset+event.Length <= handle.Start) {
 				continue
 			}
 
 			// If this handle is backed up, drop it..
 			pendingWrites := len(handle.events)
+			if pendingWrites > self.peakPendingEvents {
+				self.peakPendingEvents = pendingWrites
+			}
 			if pendingWrites >= EVENT_BUFFER_SIZE-1 {
 				log.Printf("Removing handle that has failed to keep up (losing data)")
 				// Remove the handle from any future event writes.  We can't use
@@ -167,6 +482,7 @@ func (self *Stream) Consume() error {
 				// locked.
 				delete(self.handles, handle)
 				close(handle.events)
+				self.droppedHandles++
 				continue
 			}
 			handle.events <- &event
@@ -4,12 +4,12 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
 )
 
 const EVENT_BUFFER_SIZE = 200
 
 type StreamHandle struct {
+	// The range of stream offsets [Start, Stop) to be written by WriteTo.
 	Start int64
 	Stop  int64
 
@@ -47,7 +47,7 @@ func (self *StreamHandle) writeEvent(event *Event, w io.Writer) (int64, error) {
 	startInEvent := self.Offset - event.Offset
 	var endInEvent int64
 	if eventEndOffset > self.Stop {
-		endInEvent = eventEndOffset - self.Stop
+		endInEvent = self.Stop - event.Offset
 	} else {
 		endInEvent = event.Length
 	}
@@ -71,26 +71,20 @@ func (self *StreamHandle) WriteTo(target io.Writer) (n int64, err error) {
 
 	// Begin by fetching data from the sink first if we can.
 	if streamOffset > self.Start {
-		// Pipe the existing file contents over...
-		file, err := os.Open(self.stream.Path)
-		if err != nil {
-			return 0, err
-		}
-
 		// Determine how much to copy over based on the `Stop` value for this
 		// handle.
-		var offset int64
+		var end int64
 		if self.Stop > streamOffset {
-			offset = streamOffset
+			end = streamOffset
 		} else {
-			offset = self.Stop
+			end = self.Stop
 		}
 
-		// Begin by copying the initial data from the sink...
-		written, copyErr := io.CopyN(target, file, offset)
-		file.Close()
+		// Begin by copying the initial data from the sink, starting at the
+		// `Start` value for this handle...
+		offset, copyErr := self.stream.CopyRange(target, self.Start, end)
 
-		self.Offset += written
+		self.Offset = offset
 		if copyErr != nil {
 			return int64(self.Offset), copyErr
 		}
@@ -0,0 +1,135 @@
+package writer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testData(size int) string {
+	var b strings.Builder
+	for i := 0; b.Len() < size; i++ {
+		fmt.Fprintf(&b, "%d,", i)
+	}
+	return b.String()[:size]
+}
+
+// consume creates a stream of the given data and consumes it, returning the
+// stream and a function to clean up its backing files.
+func consume(t *testing.T, data string, retention Retention) (*Stream, func()) {
+	tempdir, err := ioutil.TempDir("", "livelog-writer-tests-")
+	require.NoError(t, err)
+	TempDir = tempdir
+	cleanup := func() {
+		TempDir = ""
+		os.RemoveAll(tempdir)
+	}
+
+	stream, err := NewStream(iotest.OneByteReader(strings.NewReader(data)), retention)
+	require.NoError(t, err)
+	require.NoError(t, stream.Consume())
+	return stream, cleanup
+}
+
+type elisionRecorder struct {
+	bytes.Buffer
+	elisions [][2]int64
+}
+
+func (r *elisionRecorder) WriteElision(offset, length int64) error {
+	r.elisions = append(r.elisions, [2]int64{offset, length})
+	return nil
+}
+
+func TestUnlimitedRetention(t *testing.T) {
+	data := testData(1000)
+	stream, cleanup := consume(t, data, Retention{})
+	defer cleanup()
+
+	var buf bytes.Buffer
+	offset, err := stream.CopyRange(&buf, 10, 1000)
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), offset)
+	require.Equal(t, data[10:], buf.String())
+
+	require.Equal(t, Metrics{BytesReceived: 1000, BytesRetained: 1000, Ended: true}, stream.Metrics())
+}
+
+func TestHeadAndTailRetention(t *testing.T) {
+	data := testData(1000)
+	stream, cleanup := consume(t, data, Retention{HeadBytes: 50, TailBytes: 70})
+	defer cleanup()
+
+	elidedStart, elidedEnd := stream.Elided()
+	require.Equal(t, int64(50), elidedStart)
+	require.Equal(t, int64(930), elidedEnd)
+
+	var buf bytes.Buffer
+	offset, err := stream.CopyRange(&buf, 0, 1000)
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), offset)
+	require.Equal(t, data[:50]+"\n[... 880 bytes elided ...]\n"+data[930:], buf.String())
+
+	// ranges within the tail wrap around the ring buffer
+	recorder := &elisionRecorder{}
+	offset, err = stream.CopyRange(recorder, 40, 990)
+	require.NoError(t, err)
+	require.Equal(t, int64(990), offset)
+	require.Equal(t, data[40:50]+data[930:990], recorder.String())
+	require.Equal(t, [][2]int64{{50, 880}}, recorder.elisions)
+
+	reader, err := stream.NewReader()
+	require.NoError(t, err)
+	defer reader.Close()
+	p := make([]byte, 20)
+	n, err := reader.ReadAt(p, 40)
+	require.Equal(t, ErrElided, err)
+	require.Equal(t, data[40:50], string(p[:n]))
+	_, err = reader.ReadAt(p, 100)
+	require.Equal(t, ErrElided, err)
+	n, err = reader.ReadAt(p, 990)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, data[990:], string(p[:n]))
+
+	require.Equal(t, Metrics{BytesReceived: 1000, BytesRetained: 120, BytesElided: 880, Ended: true}, stream.Metrics())
+}
+
+func TestHeadOnlyRetention(t *testing.T) {
+	data := testData(1000)
+	stream, cleanup := consume(t, data, Retention{HeadBytes: 100})
+	defer cleanup()
+
+	var buf bytes.Buffer
+	_, err := stream.CopyRange(&buf, 0, 1000)
+	require.NoError(t, err)
+	require.Equal(t, data[:100]+"\n[... 900 bytes elided ...]\n", buf.String())
+}
+
+func TestTailOnlyRetention(t *testing.T) {
+	data := testData(1000)
+	stream, cleanup := consume(t, data, Retention{TailBytes: 100})
+	defer cleanup()
+
+	var buf bytes.Buffer
+	_, err := stream.CopyRange(&buf, 0, 1000)
+	require.NoError(t, err)
+	require.Equal(t, "\n[... 900 bytes elided ...]\n"+data[900:], buf.String())
+}
+
+func TestRetentionNotExceeded(t *testing.T) {
+	data := testData(100)
+	stream, cleanup := consume(t, data, Retention{HeadBytes: 50, TailBytes: 70})
+	defer cleanup()
+
+	var buf bytes.Buffer
+	_, err := stream.CopyRange(&buf, 0, 100)
+	require.NoError(t, err)
+	require.Equal(t, data, buf.String())
+}
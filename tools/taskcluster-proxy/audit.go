@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditLogEntry is a record of a single proxied request, written as a line of
+// json to the audit log.
+type AuditLogEntry struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Service    string    `json:"service"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMS int64     `json:"durationMs"`
+}
+
+// AuditLog records proxied requests to a file.
+type AuditLog struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// NewAuditLog opens the given file for appending audit log entries.
+func NewAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{
+		file:    file,
+		encoder: json.NewEncoder(file),
+	}, nil
+}
+
+// Record writes an entry to the audit log.
+func (auditLog *AuditLog) Record(entry *AuditLogEntry) error {
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+	return auditLog.encoder.Encode(entry)
+}
+
+// targetService returns the name of the Taskcluster service that targetPath
+// belongs to, given the rootURL of the deployment, or else the hostname of
+// targetPath.
+func targetService(rootURL string, targetPath *url.URL) string {
+	if root, err := url.Parse(rootURL); err == nil && root.Host == targetPath.Host {
+		rootPath := strings.TrimSuffix(root.Path, "/")
+		path := strings.TrimPrefix(targetPath.Path, rootPath+"/api/")
+		if path != targetPath.Path {
+			return strings.SplitN(path, "/", 2)[0]
+		}
+	}
+	return targetPath.Host
+}
+
+// statusRecorder is an http.ResponseWriter which records the status code of
+// the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tcclient "github.com/taskcluster/taskcluster/v30/clients/client-go"
+)
+
+func TestTargetService(t *testing.T) {
+	for _, tc := range []struct {
+		rootURL    string
+		targetPath string
+		service    string
+	}{
+		{"https://tc.example.com", "https://tc.example.com/api/queue/v1/task/abc", "queue"},
+		{"https://tc.example.com/", "https://tc.example.com/api/secrets/v1/secret/x", "secrets"},
+		{"https://tc.example.com/prefix", "https://tc.example.com/prefix/api/index/v1/task/x", "index"},
+		{"https://tc.example.com", "https://other.example.com/api/queue/v1/ping", "other.example.com"},
+		{"https://tc.example.com", "https://tc.example.com/foo", "tc.example.com"},
+	} {
+		targetPath, err := url.Parse(tc.targetPath)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if service := targetService(tc.rootURL, targetPath); service != tc.service {
+			t.Errorf("Expected service of %v with rootURL %v to be %q but got %q", tc.targetPath, tc.rootURL, tc.service, service)
+		}
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	server, routes := newFakeServiceRoutes(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/queue/v1/task/abc/status" {
+			w.WriteHeader(200)
+		} else {
+			w.WriteHeader(404)
+		}
+		_, _ = w.Write([]byte(`{"some": "response body"}`))
+	})
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "taskcluster-proxy-audit")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	routes.auditLog, err = NewAuditLog(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, path := range []string{"/api/queue/v1/task/abc/status?x=y", "/api/index/v1/task/missing"} {
+		req, err := http.NewRequest("GET", "http://localhost:60024"+path, nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		routes.APIHandler(httptest.NewRecorder(), req)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	entries := []AuditLogEntry{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var entry AuditLogEntry
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatalf("Could not decode audit log %q: %v", data, err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 audit log entries but got %v:\n%s", len(entries), data)
+	}
+	for i, expected := range []AuditLogEntry{
+		{Method: "GET", Service: "queue", Path: "/api/queue/v1/task/abc/status", Status: 200},
+		{Method: "GET", Service: "index", Path: "/api/index/v1/task/missing", Status: 404},
+	} {
+		actual := entries[i]
+		if actual.Method != expected.Method || actual.Service != expected.Service || actual.Path != expected.Path || actual.Status != expected.Status {
+			t.Errorf("Expected audit log entry %v to be like %#v but got %#v", i, expected, actual)
+		}
+		if actual.Time.IsZero() {
+			t.Errorf("Expected audit log entry %v to have a time", i)
+		}
+	}
+}
+
+func TestScopesEndpoint(t *testing.T) {
+	routes := NewRoutes(
+		tcclient.Client{
+			RootURL: "https://tc.example.com",
+			Credentials: &tcclient.Credentials{
+				ClientID:         "tester",
+				AccessToken:      "no-secret",
+				AuthorizedScopes: []string{"queue:get-artifact:private/*", "secrets:get:abc"},
+			},
+		},
+	)
+	req, err := http.NewRequest("GET", "http://localhost:60024/scopes", nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	res := httptest.NewRecorder()
+	routes.ScopesHandler(res, req)
+	if res.Code != 200 {
+		t.Fatalf("Expected status code 200 but got %v", res.Code)
+	}
+	expected := `{"clientId":"tester","authorizedScopes":["queue:get-artifact:private/*","secrets:get:abc"]}` + "\n"
+	if body := res.Body.String(); body != expected {
+		t.Fatalf("Expected response %q but got %q", expected, body)
+	}
+
+	req, err = http.NewRequest("POST", "http://localhost:60024/scopes", nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	res = httptest.NewRecorder()
+	routes.ScopesHandler(res, req)
+	if res.Code != 405 {
+		t.Fatalf("Expected status code 405 but got %v", res.Code)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maximum number of responses held in a ResponseCache
+	cacheMaxEntries = 1000
+	// responses with larger bodies than this are not cached
+	cacheMaxBodySize = 1 << 20
+)
+
+// Values of the X-Taskcluster-Proxy-Cache response header
+const (
+	cacheHit         = "HIT"
+	cacheRevalidated = "REVALIDATED"
+	cacheMiss        = "MISS"
+)
+
+// ResponseCache is an in-memory cache of successful GET responses from
+// selected Taskcluster services. Cached responses are served without
+// contacting the service until they are older than the TTL, after which they
+// are revalidated using their ETag, if they have one.
+type ResponseCache struct {
+	services map[string]bool
+	ttl      time.Duration
+	mutex    sync.Mutex
+	entries  map[string]*cacheEntry
+	hits     uint64
+	misses   uint64
+}
+
+type cacheEntry struct {
+	status  int
+	header  http.Header
+	body    []byte
+	etag    string
+	expires time.Time
+}
+
+// NewResponseCache creates a ResponseCache for the given services (e.g.
+// "queue", "index") which serves responses for ttl before revalidating them.
+func NewResponseCache(services []string, ttl time.Duration) *ResponseCache {
+	cache := &ResponseCache{
+		services: map[string]bool{},
+		ttl:      ttl,
+		entries:  map[string]*cacheEntry{},
+	}
+	for _, service := range services {
+		cache.services[strings.TrimSpace(service)] = true
+	}
+	return cache
+}
+
+// Caches returns whether responses to req, which is proxied to service, may
+// be cached.
+func (cache *ResponseCache) Caches(service string, req *http.Request) bool {
+	return req.Method == "GET" && req.Header.Get("Range") == "" && cache.services[service]
+}
+
+// Get returns the cached response for key, or nil if there is none. The
+// returned entry may have expired, in which case it should be revalidated.
+func (cache *ResponseCache) Get(key string) *cacheEntry {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.entries[key]
+}
+
+// Put caches a response for key, if it is cacheable, and counts a miss.
+func (cache *ResponseCache) Put(key string, res *http.Response, body []byte) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.misses++
+	if res.StatusCode != 200 || len(body) > cacheMaxBodySize || strings.Contains(res.Header.Get("Cache-Control"), "no-store") {
+		return
+	}
+	now := time.Now()
+	if _, exists := cache.entries[key]; !exists && len(cache.entries) >= cacheMaxEntries {
+		// make room by discarding expired responses that cannot be revalidated
+		for k, entry := range cache.entries {
+			if entry.etag == "" && now.After(entry.expires) {
+				delete(cache.entries, k)
+			}
+		}
+		if len(cache.entries) >= cacheMaxEntries {
+			return
+		}
+	}
+	cache.entries[key] = &cacheEntry{
+		status:  res.StatusCode,
+		header:  res.Header.Clone(),
+		body:    body,
+		etag:    res.Header.Get("ETag"),
+		expires: now.Add(cache.ttl),
+	}
+}
+
+// Revalidated records that the service confirmed that the cached response
+// for key is still current, and returns it.
+func (cache *ResponseCache) Revalidated(key string, entry *cacheEntry) *cacheEntry {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	refreshed := *entry
+	refreshed.expires = time.Now().Add(cache.ttl)
+	cache.entries[key] = &refreshed
+	return &refreshed
+}
+
+// Hit counts a response served from the cache.
+func (cache *ResponseCache) Hit() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.hits++
+}
+
+// setHeaders reports how the response was served, and the cache hit and miss
+// counts, in the response headers.
+func (cache *ResponseCache) setHeaders(res http.ResponseWriter, outcome string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	headers := res.Header()
+	headers.Set("X-Taskcluster-Proxy-Cache", outcome)
+	headers.Set("X-Taskcluster-Proxy-Cache-Hits", strconv.FormatUint(cache.hits, 10))
+	headers.Set("X-Taskcluster-Proxy-Cache-Misses", strconv.FormatUint(cache.misses, 10))
+}
+
+// serve writes a cached response. If the request has an If-None-Match header
+// matching the cached ETag, a 304 response is written instead.
+func (cache *ResponseCache) serve(res http.ResponseWriter, req *http.Request, entry *cacheEntry, outcome string) {
+	cache.Hit()
+	for key, values := range entry.header {
+		res.Header()[key] = values
+	}
+	cache.setHeaders(res, outcome)
+	if entry.etag != "" && etagMatches(req.Header.Get("If-None-Match"), entry.etag) {
+		res.Header().Del("Content-Length")
+		res.WriteHeader(304)
+		return
+	}
+	res.WriteHeader(entry.status)
+	_, _ = res.Write(entry.body)
+}
+
+func (entry *cacheEntry) fresh(now time.Time) bool {
+	return now.Before(entry.expires)
+}
+
+// etagMatches returns whether the value of an If-None-Match header matches
+// etag, using weak comparison.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// cachingService serves a fixed body with an ETag, honouring If-None-Match,
+// and counts the requests it receives
+type cachingService struct {
+	requests    int
+	revalidated int
+}
+
+func (service *cachingService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	service.requests++
+	w.Header().Set("ETag", `"v1"`)
+	if r.Header.Get("If-None-Match") == `"v1"` {
+		service.revalidated++
+		w.WriteHeader(304)
+		return
+	}
+	w.WriteHeader(200)
+	_, _ = w.Write([]byte(`{"status": "running"}`))
+}
+
+func proxyGet(t *testing.T, routes *Routes, path string, header http.Header) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "http://localhost:60024"+path, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	res := httptest.NewRecorder()
+	routes.APIHandler(res, req)
+	return res
+}
+
+func expectCached(t *testing.T, res *httptest.ResponseRecorder, status int, outcome string, hits string, misses string) {
+	t.Helper()
+	if res.Code != status {
+		t.Errorf("Expected status %v but got %v", status, res.Code)
+	}
+	if status == 200 && res.Body.String() != `{"status": "running"}` {
+		t.Errorf("Got unexpected body %q", res.Body.String())
+	}
+	for header, expected := range map[string]string{
+		"X-Taskcluster-Proxy-Cache":        outcome,
+		"X-Taskcluster-Proxy-Cache-Hits":   hits,
+		"X-Taskcluster-Proxy-Cache-Misses": misses,
+	} {
+		if actual := res.Header().Get(header); actual != expected {
+			t.Errorf("Expected header %v to be %q but got %q", header, expected, actual)
+		}
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	service := &cachingService{}
+	server, routes := newFakeServiceRoutes(t, service.ServeHTTP)
+	defer server.Close()
+	routes.cache = NewResponseCache([]string{"queue"}, time.Hour)
+
+	path := "/api/queue/v1/task/abc/status"
+	expectCached(t, proxyGet(t, routes, path, nil), 200, cacheMiss, "0", "1")
+	expectCached(t, proxyGet(t, routes, path, nil), 200, cacheHit, "1", "1")
+	expectCached(t, proxyGet(t, routes, path, http.Header{"If-None-Match": {`"v1"`}}), 304, cacheHit, "2", "1")
+	if service.requests != 1 {
+		t.Errorf("Expected service to receive 1 request but it received %v", service.requests)
+	}
+
+	// responses from other services are not cached
+	for i := 0; i < 2; i++ {
+		res := proxyGet(t, routes, "/api/index/v1/task/abc", nil)
+		if outcome := res.Header().Get("X-Taskcluster-Proxy-Cache"); outcome != "" {
+			t.Errorf("Did not expect index response to be cached, but got cache outcome %q", outcome)
+		}
+	}
+	if service.requests != 3 {
+		t.Errorf("Expected service to receive 3 requests but it received %v", service.requests)
+	}
+}
+
+func TestResponseCacheRevalidation(t *testing.T) {
+	service := &cachingService{}
+	server, routes := newFakeServiceRoutes(t, service.ServeHTTP)
+	defer server.Close()
+	// responses expire immediately, so every request is revalidated
+	routes.cache = NewResponseCache([]string{"queue"}, 0)
+
+	path := "/api/queue/v1/task/abc/status"
+	expectCached(t, proxyGet(t, routes, path, nil), 200, cacheMiss, "0", "1")
+	expectCached(t, proxyGet(t, routes, path, nil), 200, cacheRevalidated, "1", "1")
+	expectCached(t, proxyGet(t, routes, path, nil), 200, cacheRevalidated, "2", "1")
+	if service.requests != 3 || service.revalidated != 2 {
+		t.Errorf("Expected service to receive 3 requests of which 2 revalidated, but got %v and %v", service.requests, service.revalidated)
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	for _, tc := range []struct {
+		ifNoneMatch string
+		etag        string
+		matches     bool
+	}{
+		{"", `"a"`, false},
+		{`"a"`, `"a"`, true},
+		{`"b", "a"`, `"a"`, true},
+		{`W/"a"`, `"a"`, true},
+		{`"a"`, `W/"a"`, true},
+		{`*`, `"a"`, true},
+		{`"b"`, `"a"`, false},
+	} {
+		if matches := etagMatches(tc.ifNoneMatch, tc.etag); matches != tc.matches {
+			t.Errorf("Expected etagMatches(%q, %q) to be %v", tc.ifNoneMatch, tc.etag, tc.matches)
+		}
+	}
+}
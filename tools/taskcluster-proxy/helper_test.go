@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	tcclient "github.com/taskcluster/taskcluster/v30/clients/client-go"
+)
+
+// newFakeServiceRoutes starts an http server which serves all requests with
+// handler, and returns it along with Routes which proxy requests to it as the
+// rootURL of a Taskcluster deployment. The server should be closed when no
+// longer needed.
+func newFakeServiceRoutes(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *Routes) {
+	server := httptest.NewServer(handler)
+	routes := NewRoutes(
+		tcclient.Client{
+			RootURL:      server.URL,
+			Authenticate: true,
+			Credentials: &tcclient.Credentials{
+				ClientID:    "tester",
+				AccessToken: "no-secret",
+			},
+		},
+	)
+	return server, &routes
+}
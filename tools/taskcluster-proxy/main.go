@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	docopt "github.com/docopt/docopt-go"
 	tcclient "github.com/taskcluster/taskcluster/v30/clients/client-go"
@@ -34,11 +36,23 @@ task id.
     -i --ip-address <address>       IPv4 or IPv6 address of network interface to bind listener to.
                                     If not provided, will bind listener to all available network
                                     interfaces [default: ].
+    --unix-socket <path>            Listen on a unix domain socket at this path (with
+                                    permissions 0600) instead of a TCP port. Any existing
+                                    file at this path is removed first.
     -t --task-id <taskId>           Restrict given scopes to those defined in taskId.
     --root-url <rootUrl>            The rootUrl for the TC deployment to access
     --client-id <clientId>          Use a specific auth.taskcluster hawk client id [default: ].
     --access-token <accessToken>    Use a specific auth.taskcluster hawk access token [default: ].
     --certificate <certificate>     Use a specific auth.taskcluster hawk certificate [default: ].
+    --audit-log <file>              Append a line of json describing each proxied request
+                                    (method, service, path, status and duration) to this file.
+    --cache-services <services>     Comma separated list of services (e.g. queue,index) whose
+                                    successful GET responses are cached in memory [default: ].
+    --cache-ttl <seconds>           Number of seconds that cached responses are served for
+                                    before being revalidated with the service [default: 5].
+    --upload-task-id <taskId>       Enable the /upload-artifact/<name> endpoint, uploading
+                                    artifacts to this task.
+    --upload-run-id <runId>         Run of --upload-task-id to upload artifacts to [default: 0].
 `
 )
 
@@ -50,18 +64,53 @@ func main() {
 
 	http.HandleFunc("/bewit", routes.BewitHandler)
 	http.HandleFunc("/credentials", routes.CredentialsHandler)
+	http.HandleFunc("/scopes", routes.ScopesHandler)
+	http.HandleFunc(uploadArtifactPrefix, routes.UploadArtifactHandler)
 	http.HandleFunc("/api/", routes.APIHandler)
 	http.HandleFunc("/", routes.RootHandler)
 
 	// Only listen on loopback interface to reduce attack surface. If we later
 	// wish to make this service available over the network, we could add
 	// configuration settings for this, but for now, let's lock it down.
-	startError := http.ListenAndServe(address, nil)
+	var startError error
+	if strings.HasPrefix(address, unixSocketPrefix) {
+		var listener net.Listener
+		listener, startError = listenUnixSocket(strings.TrimPrefix(address, unixSocketPrefix))
+		if startError == nil {
+			startError = http.Serve(listener, nil)
+		}
+	} else {
+		startError = http.ListenAndServe(address, nil)
+	}
 	if startError != nil {
 		log.Fatal(startError)
 	}
 }
 
+// Addresses returned by ParseCommandArgs with this prefix are the paths of
+// unix domain sockets
+const unixSocketPrefix = "unix:"
+
+// listenUnixSocket listens on a unix domain socket that only the current user
+// may connect to; it is up to the caller to grant access to others.
+func listenUnixSocket(path string) (net.Listener, error) {
+	// remove any socket left over from a previous run
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
 // Fetch a task by TaskID.  This is broken out to allow testing.
 var getTask = func(rootURL string, taskID string) (task *tcqueue.TaskDefinitionResponse, err error) {
 	queue := tcqueue.New(nil, rootURL)
@@ -72,7 +121,8 @@ var getTask = func(rootURL string, taskID string) (task *tcqueue.TaskDefinitionR
 }
 
 // ParseCommandArgs converts command line arguments into a configured Routes
-// and port.
+// and address to listen on. If a unix domain socket is to be used, the
+// address is its path prefixed with "unix:".
 func ParseCommandArgs(argv []string, exit bool) (routes Routes, address string, err error) {
 	fullversion := "Taskcluster proxy " + version
 	if revision != "" {
@@ -106,6 +156,9 @@ func ParseCommandArgs(argv []string, exit bool) (routes Routes, address string,
 		}
 	}
 	address = ipAddress + ":" + portStr
+	if unixSocket, ok := arguments["--unix-socket"].(string); ok && unixSocket != "" {
+		address = unixSocketPrefix + unixSocket
+	}
 	log.Printf("Listening on: %v", address)
 
 	rootURL := arguments["--root-url"]
@@ -193,5 +246,36 @@ func ParseCommandArgs(argv []string, exit bool) (routes Routes, address string,
 			Credentials:  creds,
 		},
 	)
+
+	if auditLogFile, ok := arguments["--audit-log"].(string); ok && auditLogFile != "" {
+		routes.auditLog, err = NewAuditLog(auditLogFile)
+		if err != nil {
+			err = fmt.Errorf("Could not open audit log '%s': %s", auditLogFile, err)
+			return
+		}
+		log.Printf("Audit log: '%v'", auditLogFile)
+	}
+
+	if uploadTaskID, ok := arguments["--upload-task-id"].(string); ok && uploadTaskID != "" {
+		routes.uploadTaskID = uploadTaskID
+		routes.uploadRunID = arguments["--upload-run-id"].(string)
+		if _, err = strconv.ParseUint(routes.uploadRunID, 10, 32); err != nil {
+			err = fmt.Errorf("Invalid --upload-run-id '%v': must be a non-negative integer", routes.uploadRunID)
+			return
+		}
+		log.Printf("Artifacts uploaded to %v<name> will be created on task %v run %v", uploadArtifactPrefix, uploadTaskID, routes.uploadRunID)
+	}
+
+	if cacheServices, ok := arguments["--cache-services"].(string); ok && cacheServices != "" {
+		var ttl int
+		ttl, err = strconv.Atoi(arguments["--cache-ttl"].(string))
+		if err != nil || ttl < 0 {
+			err = fmt.Errorf("Invalid --cache-ttl '%v': must be a non-negative integer number of seconds", arguments["--cache-ttl"])
+			return
+		}
+		services := strings.Split(cacheServices, ",")
+		routes.cache = NewResponseCache(services, time.Duration(ttl)*time.Second)
+		log.Printf("Caching GET responses from services %v for %v seconds", services, ttl)
+	}
 	return
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	tcclient "github.com/taskcluster/taskcluster/v30/clients/client-go"
+	"github.com/taskcluster/taskcluster/v30/internal/scopes"
+)
+
+// A request with this header, containing a json array of scopes, is signed
+// with only those scopes, provided they are a subset of the proxy's scopes.
+// Responses contain the same header, reporting the scopes that were used.
+const authorizedScopesHeader = "X-Taskcluster-Authorized-Scopes"
+
+// narrowedCredentials returns a copy of the proxy's credentials whose
+// authorized scopes are restricted to requestedScopes, the json array from
+// the X-Taskcluster-Authorized-Scopes request header. If the header is
+// invalid, or requests scopes the proxy does not have, an http status code
+// and error are returned. Must be called with routes.lock held.
+func (routes *Routes) narrowedCredentials(requestedScopes string) (*tcclient.Credentials, int, error) {
+	requested := []string{}
+	err := json.Unmarshal([]byte(requestedScopes), &requested)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("Header %v must be a json array of scopes: %v", authorizedScopesHeader, err)
+	}
+
+	// The scopes the proxy may use are its authorized scopes, or if those
+	// are not restricted, the scopes of its temporary credentials. Permanent
+	// credentials without authorized scopes are checked by the service, since
+	// their scopes are not known here.
+	available := routes.Credentials.AuthorizedScopes
+	if available == nil {
+		cert, err := routes.Credentials.Cert()
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if cert != nil {
+			available = cert.Scopes
+		}
+	}
+	if available != nil {
+		satisfied, err := scopes.Given(available).Satisfies(scopes.Required{requested}, routes.scopeExpander)
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("Could not expand scopes %v: %v", available, err)
+		}
+		if !satisfied {
+			return nil, http.StatusForbidden, fmt.Errorf("Requested scopes %v are not a subset of the proxy's scopes %v", requested, available)
+		}
+	}
+
+	narrowed := *routes.Credentials
+	narrowed.AuthorizedScopes = requested
+	return &narrowed, 0, nil
+}
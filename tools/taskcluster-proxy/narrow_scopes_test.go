@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/taskcluster/taskcluster/v30/clients/client-go/tcauth"
+)
+
+// fakeScopeExpander expands assume:role-a to the scopes of role-a
+type fakeScopeExpander struct{}
+
+func (fakeScopeExpander) ExpandScopes(given *tcauth.SetOfScopes) (*tcauth.SetOfScopes, error) {
+	expanded := &tcauth.SetOfScopes{}
+	for _, scope := range given.Scopes {
+		expanded.Scopes = append(expanded.Scopes, scope)
+		if scope == "assume:role-a" {
+			expanded.Scopes = append(expanded.Scopes, "secrets:get:role-a/*")
+		}
+	}
+	return expanded, nil
+}
+
+var hawkExt = regexp.MustCompile(`ext="([^"]*)"`)
+
+// authorizedScopes returns the authorized scopes that a request was signed
+// with, from the ext attribute of its Hawk Authorization header
+func authorizedScopes(t *testing.T, r *http.Request) []string {
+	match := hawkExt.FindStringSubmatch(r.Header.Get("Authorization"))
+	if match == nil {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(match[1])
+	if err != nil {
+		t.Fatalf("Could not decode Hawk ext %q: %v", match[1], err)
+	}
+	ext := struct {
+		AuthorizedScopes []string `json:"authorizedScopes"`
+	}{}
+	if err := json.Unmarshal(data, &ext); err != nil {
+		t.Fatalf("Could not interpret Hawk ext %q: %v", data, err)
+	}
+	return ext.AuthorizedScopes
+}
+
+func TestNarrowedScopes(t *testing.T) {
+	var signedWith []string
+	forwardedHeader := ""
+	server, routes := newFakeServiceRoutes(t, func(w http.ResponseWriter, r *http.Request) {
+		signedWith = authorizedScopes(t, r)
+		forwardedHeader = r.Header.Get(authorizedScopesHeader)
+		w.WriteHeader(200)
+	})
+	defer server.Close()
+	routes.Credentials.AuthorizedScopes = []string{"queue:get-artifact:private/*", "assume:role-a"}
+	routes.scopeExpander = fakeScopeExpander{}
+
+	for _, tc := range []struct {
+		header     string
+		status     int
+		signedWith []string
+	}{
+		{"", 200, []string{"queue:get-artifact:private/*", "assume:role-a"}},
+		{`["queue:get-artifact:private/build/*"]`, 200, []string{"queue:get-artifact:private/build/*"}},
+		{`["secrets:get:role-a/x"]`, 200, []string{"secrets:get:role-a/x"}},
+		{`[]`, 200, []string{}},
+		{`["secrets:get:role-b/x"]`, 403, nil},
+		{`["queue:get-artifact:private/x", "queue:create-task:*"]`, 403, nil},
+		{`queue:get-artifact:private/x`, 400, nil},
+	} {
+		signedWith = nil
+		forwardedHeader = ""
+		req, err := http.NewRequest("GET", "http://localhost:60024/api/queue/v1/task/abc/artifacts/private/x", nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if tc.header != "" {
+			req.Header.Set(authorizedScopesHeader, tc.header)
+		}
+		res := httptest.NewRecorder()
+		routes.APIHandler(res, req)
+		if res.Code != tc.status {
+			t.Errorf("With header %q expected status %v but got %v: %v", tc.header, tc.status, res.Code, res.Body.String())
+			continue
+		}
+		if !reflect.DeepEqual(signedWith, tc.signedWith) {
+			t.Errorf("With header %q expected request to be signed with scopes %#v but got %#v", tc.header, tc.signedWith, signedWith)
+		}
+		if forwardedHeader != "" {
+			t.Errorf("With header %q, did not expect header to be forwarded to the service", tc.header)
+		}
+		if tc.status == 200 {
+			reported := []string{}
+			if err := json.Unmarshal([]byte(res.Header().Get(authorizedScopesHeader)), &reported); err != nil || !reflect.DeepEqual(reported, tc.signedWith) {
+				t.Errorf("With header %q expected response header %v to report %#v but got %q", tc.header, authorizedScopesHeader, tc.signedWith, res.Header().Get(authorizedScopesHeader))
+			}
+		}
+	}
+}
@@ -158,6 +158,24 @@ func TestWithInterface(t *testing.T) {
 	}
 }
 
+func TestWithUnixSocket(t *testing.T) {
+	_, address, err := ParseCommandArgs(
+		[]string{
+			"--root-url", "https://tc-tests.example.com",
+			"--client-id", "abc",
+			"--access-token", "ghi",
+			"--unix-socket", "/tmp/taskcluster-proxy.sock",
+		},
+		false,
+	)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if address != "unix:/tmp/taskcluster-proxy.sock" {
+		t.Fatalf("Was expecting address 'unix:/tmp/taskcluster-proxy.sock', but got address '%v'.", address)
+	}
+}
+
 func TestBadPort(t *testing.T) {
 	_, _, err := ParseCommandArgs(
 		[]string{
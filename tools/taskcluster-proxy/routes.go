@@ -16,6 +16,8 @@ import (
 	"github.com/taskcluster/httpbackoff/v3"
 	tcUrls "github.com/taskcluster/taskcluster-lib-urls"
 	tcclient "github.com/taskcluster/taskcluster/v30/clients/client-go"
+	"github.com/taskcluster/taskcluster/v30/clients/client-go/tcauth"
+	"github.com/taskcluster/taskcluster/v30/internal/scopes"
 	tc "github.com/taskcluster/taskcluster/v30/tools/taskcluster-proxy/taskcluster"
 )
 
@@ -24,6 +26,16 @@ type Routes struct {
 	tcclient.Client
 	services tc.Services
 	lock     sync.RWMutex
+	// if not nil, proxied requests are recorded here
+	auditLog *AuditLog
+	// if not nil, GET responses from selected services are cached here
+	cache *ResponseCache
+	// used to check scopes requested with X-Taskcluster-Authorized-Scopes
+	scopeExpander scopes.ScopeExpander
+	// the task run that /upload-artifact/<name> creates artifacts for, if
+	// uploadTaskID is not empty
+	uploadTaskID string
+	uploadRunID  string
 }
 
 // CredentialsUpdate is the internal representation of the json body which is
@@ -34,13 +46,23 @@ type CredentialsUpdate struct {
 	Certificate string `json:"certificate"`
 }
 
+// ScopesResponse is the json body returned by the /scopes endpoint.
+// AuthorizedScopes is null if the proxy does not restrict the scopes of its
+// credentials, and CertificateScopes is only set for temporary credentials.
+type ScopesResponse struct {
+	ClientID          string   `json:"clientId"`
+	AuthorizedScopes  []string `json:"authorizedScopes"`
+	CertificateScopes []string `json:"certificateScopes,omitempty"`
+}
+
 var httpClient = &http.Client{}
 
 // NewRoutes creates a new Routes instance.
 func NewRoutes(client tcclient.Client) Routes {
 	return Routes{
-		Client:   client,
-		services: tc.NewServices(client.RootURL),
+		Client:        client,
+		services:      tc.NewServices(client.RootURL),
+		scopeExpander: tcauth.New(nil, client.RootURL),
 	}
 }
 
@@ -69,7 +91,7 @@ func (routes *Routes) setHeaders(res http.ResponseWriter) {
 	if authScopes := routes.Credentials.AuthorizedScopes; authScopes != nil {
 		jsonAuthScopes, err := json.Marshal(authScopes)
 		if err == nil {
-			headersToSend.Set("X-Taskcluster-Authorized-Scopes", string(jsonAuthScopes))
+			headersToSend.Set(authorizedScopesHeader, string(jsonAuthScopes))
 		}
 	}
 }
@@ -195,11 +217,61 @@ func (routes *Routes) APIHandler(res http.ResponseWriter, req *http.Request) {
 	routes.commonHandler(res, req, targetPath)
 }
 
+// ScopesHandler is the HTTP Handler for serving the /scopes endpoint, which
+// describes the scopes that proxied requests are made with
+func (routes *Routes) ScopesHandler(res http.ResponseWriter, req *http.Request) {
+	routes.setHeaders(res)
+	if req.Method != "GET" {
+		log.Printf("Invalid method %s\n", req.Method)
+		res.WriteHeader(405)
+		return
+	}
+	routes.lock.RLock()
+	defer routes.lock.RUnlock()
+
+	response := &ScopesResponse{
+		ClientID:         routes.Credentials.ClientID,
+		AuthorizedScopes: routes.Credentials.AuthorizedScopes,
+	}
+	cert, err := routes.Credentials.Cert()
+	if err != nil {
+		// setHeaders has already reported the invalid certificate
+		return
+	}
+	if cert != nil {
+		response.CertificateScopes = cert.Scopes
+	}
+	res.Header().Set("Content-Type", "application/json; charset=utf-8")
+	res.WriteHeader(200)
+	_ = json.NewEncoder(res).Encode(response)
+}
+
 // Common code for RootHandler and APIHandler
 func (routes *Routes) commonHandler(res http.ResponseWriter, req *http.Request, targetPath *url.URL) {
 	res.Header().Set("X-Taskcluster-Endpoint", targetPath.String())
 	log.Printf("Proxying %s | %s | %s", req.URL, req.Method, targetPath)
 
+	if routes.auditLog != nil {
+		rec := &statusRecorder{ResponseWriter: res, status: 200}
+		res = rec
+		start := time.Now()
+		defer func() {
+			err := routes.auditLog.Record(
+				&AuditLogEntry{
+					Time:       start.UTC(),
+					Method:     req.Method,
+					Service:    targetService(routes.RootURL, targetPath),
+					Path:       targetPath.EscapedPath(),
+					Status:     rec.status,
+					DurationMS: time.Since(start).Nanoseconds() / 1e6,
+				},
+			)
+			if err != nil {
+				log.Printf("Could not write to audit log: %v", err)
+			}
+		}()
+	}
+
 	// In theory, req.Body should never be nil when running as a server, but
 	// during testing, with a direct call to the method rather than a real http
 	// request coming in from outside, it could be. For example see:
@@ -224,6 +296,37 @@ func (routes *Routes) commonHandler(res http.ResponseWriter, req *http.Request,
 		}
 	}
 
+	credentials := routes.Credentials
+	if requestedScopes := req.Header.Get(authorizedScopesHeader); requestedScopes != "" {
+		var status int
+		credentials, status, err = routes.narrowedCredentials(requestedScopes)
+		if err != nil {
+			res.WriteHeader(status)
+			fmt.Fprintf(res, "%s", err)
+			return
+		}
+		jsonAuthScopes, _ := json.Marshal(credentials.AuthorizedScopes)
+		res.Header().Set(authorizedScopesHeader, string(jsonAuthScopes))
+	}
+
+	// Serve fresh cached responses without contacting the service; expired
+	// ones are revalidated with If-None-Match, unless the client is
+	// revalidating its own copy.
+	var cached *cacheEntry
+	cacheKey := ""
+	revalidating := false
+	// Responses to requests with narrowed scopes may differ, so these are
+	// not cached.
+	if routes.cache != nil && credentials == routes.Credentials && routes.cache.Caches(targetService(routes.RootURL, targetPath), req) {
+		cacheKey = targetPath.String()
+		cached = routes.cache.Get(cacheKey)
+		if cached != nil && cached.fresh(time.Now()) {
+			routes.cache.serve(res, req, cached, cacheHit)
+			return
+		}
+		revalidating = cached != nil && cached.etag != "" && req.Header.Get("If-None-Match") == ""
+	}
+
 	// function to perform http request - we call this using backoff library to
 	// have exponential backoff in case of intermittent failures (e.g. network
 	// blips or HTTP 5xx errors)
@@ -235,9 +338,13 @@ func (routes *Routes) commonHandler(res http.ResponseWriter, req *http.Request,
 		for k, v := range req.Header {
 			proxyreq.Header[k] = v
 		}
+		proxyreq.Header.Del(authorizedScopesHeader)
+		if revalidating {
+			proxyreq.Header.Set("If-None-Match", cached.etag)
+		}
 
 		// Refresh Authorization header with each call...
-		err = routes.Credentials.SignRequest(proxyreq)
+		err = credentials.SignRequest(proxyreq)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -271,6 +378,15 @@ func (routes *Routes) commonHandler(res http.ResponseWriter, req *http.Request,
 		}
 	}
 
+	if cacheKey != "" {
+		if revalidating && proxyres.StatusCode == 304 {
+			routes.cache.serve(res, req, routes.cache.Revalidated(cacheKey, cached), cacheRevalidated)
+			return
+		}
+		routes.cache.Put(cacheKey, proxyres, resbody)
+		routes.cache.setHeaders(res, cacheMiss)
+	}
+
 	// Map the headers from the proxy back into our proxyResponse
 	for key := range proxyres.Header {
 		res.Header().Set(key, proxyres.Header.Get(key))
@@ -0,0 +1,70 @@
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taskcluster-proxy-socket")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "proxy.sock")
+
+	// a stale file at the socket path should be replaced
+	err = ioutil.WriteFile(path, []byte("stale"), 0644)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	listener, err := listenUnixSocket(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("hello"))
+		}),
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0600 {
+		t.Fatalf("Was expecting a socket with permissions 0600 but got mode %v", fi.Mode())
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+	}
+	res, err := client.Get("http://localhost/")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if string(body) != "hello" {
+		t.Fatalf("Was expecting response 'hello' but got %q", body)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/taskcluster/httpbackoff/v3"
+	tcUrls "github.com/taskcluster/taskcluster-lib-urls"
+	tcclient "github.com/taskcluster/taskcluster/v30/clients/client-go"
+	"github.com/taskcluster/taskcluster/v30/clients/client-go/tcqueue"
+)
+
+const uploadArtifactPrefix = "/upload-artifact/"
+
+// UploadArtifactResponse is the json body returned by the
+// /upload-artifact/<name> endpoint.
+type UploadArtifactResponse struct {
+	Name        string        `json:"name"`
+	URL         string        `json:"url"`
+	ContentType string        `json:"contentType"`
+	Expires     tcclient.Time `json:"expires"`
+}
+
+// UploadArtifactHandler is the HTTP Handler for the /upload-artifact/<name>
+// endpoint, which uploads the request body as an s3 artifact of the task run
+// given by --upload-task-id and --upload-run-id, gzip encoded, with the
+// content type of the request.
+func (routes *Routes) UploadArtifactHandler(res http.ResponseWriter, req *http.Request) {
+	routes.setHeaders(res)
+	if req.Method != "PUT" && req.Method != "POST" {
+		log.Printf("Invalid method %s\n", req.Method)
+		res.WriteHeader(405)
+		return
+	}
+	if routes.uploadTaskID == "" {
+		res.WriteHeader(404)
+		fmt.Fprint(res, "Artifact uploads are not enabled, since taskcluster-proxy was started without --upload-task-id")
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, uploadArtifactPrefix)
+	if name == "" {
+		res.WriteHeader(400)
+		fmt.Fprintf(res, "No artifact name given - expected %s<name>", uploadArtifactPrefix)
+		return
+	}
+	contentType := req.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// don't hold the lock for the duration of the upload
+	routes.lock.RLock()
+	creds := *routes.Credentials
+	routes.lock.RUnlock()
+	queue := tcqueue.New(&creds, routes.RootURL)
+
+	// artifacts expire with the task, unless an expiry is given
+	var expires tcclient.Time
+	if expiresParam := req.URL.Query().Get("expires"); expiresParam != "" {
+		t, err := time.Parse(time.RFC3339, expiresParam)
+		if err != nil {
+			res.WriteHeader(400)
+			fmt.Fprintf(res, "Invalid expires %q - must be an RFC3339 timestamp: %v", expiresParam, err)
+			return
+		}
+		expires = tcclient.Time(t)
+	} else {
+		task, err := queue.Task(routes.uploadTaskID)
+		if err != nil {
+			res.WriteHeader(502)
+			fmt.Fprintf(res, "Could not fetch task %v to determine artifact expiry: %v", routes.uploadTaskID, err)
+			return
+		}
+		expires = task.Expires
+	}
+
+	// the body may be large, so gzip it to a temporary file rather than
+	// holding it in memory
+	gzipped, err := ioutil.TempFile("", "taskcluster-proxy-upload")
+	if err != nil {
+		res.WriteHeader(500)
+		fmt.Fprintf(res, "Could not create temporary file: %s", err)
+		return
+	}
+	defer func() {
+		_ = gzipped.Close()
+		_ = os.Remove(gzipped.Name())
+	}()
+	gzipWriter := gzip.NewWriter(gzipped)
+	_, err = io.Copy(gzipWriter, req.Body)
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if err != nil {
+		res.WriteHeader(500)
+		fmt.Fprintf(res, "Error reading body: %s", err)
+		return
+	}
+	gzippedSize, err := gzipped.Seek(0, io.SeekCurrent)
+	if err != nil {
+		res.WriteHeader(500)
+		fmt.Fprintf(res, "Error reading body: %s", err)
+		return
+	}
+
+	payload, err := json.Marshal(
+		&tcqueue.S3ArtifactRequest{
+			ContentType: contentType,
+			Expires:     expires,
+			StorageType: "s3",
+		},
+	)
+	if err != nil {
+		panic(err)
+	}
+	par := tcqueue.PostArtifactRequest(json.RawMessage(payload))
+	parsp, err := queue.CreateArtifact(routes.uploadTaskID, routes.uploadRunID, name, &par)
+	if err != nil {
+		res.WriteHeader(502)
+		fmt.Fprintf(res, "Could not create artifact %v: %v", name, err)
+		return
+	}
+	var s3Response tcqueue.S3ArtifactResponse
+	err = json.Unmarshal(*parsp, &s3Response)
+	if err != nil {
+		res.WriteHeader(502)
+		fmt.Fprintf(res, "Could not interpret createArtifact response %q: %v", string(*parsp), err)
+		return
+	}
+
+	err = putArtifact(s3Response.PutURL, contentType, gzipped, gzippedSize)
+	if err != nil {
+		res.WriteHeader(502)
+		fmt.Fprintf(res, "Could not upload artifact %v: %v", name, err)
+		return
+	}
+
+	artifactURL := tcUrls.API(routes.RootURL, "queue", "v1", fmt.Sprintf("task/%s/runs/%s/artifacts/%s", routes.uploadTaskID, routes.uploadRunID, name))
+	log.Printf("Uploaded artifact %v (%v bytes gzipped) to %v", name, gzippedSize, artifactURL)
+	res.Header().Set("Content-Type", "application/json; charset=utf-8")
+	res.Header().Set("Location", artifactURL)
+	res.WriteHeader(201)
+	_ = json.NewEncoder(res).Encode(
+		&UploadArtifactResponse{
+			Name:        name,
+			URL:         artifactURL,
+			ContentType: contentType,
+			Expires:     expires,
+		},
+	)
+}
+
+// putArtifact uploads the given number of bytes of gzipped content to the
+// given (signed) url, retrying intermittent failures.
+func putArtifact(putURL string, contentType string, content io.ReaderAt, size int64) error {
+	httpCall := func() (*http.Response, error, error) {
+		putReq, err := http.NewRequest("PUT", putURL, io.NewSectionReader(content, 0, size))
+		if err != nil {
+			return nil, nil, err
+		}
+		putReq.ContentLength = size
+		putReq.Header.Set("Content-Type", contentType)
+		putReq.Header.Set("Content-Encoding", "gzip")
+		putResp, err := httpClient.Do(putReq)
+		if err != nil {
+			return putResp, err, nil
+		}
+		// s3 can return HTTP 400 for connection inactivity, which should
+		// be retried
+		if putResp.StatusCode == 400 {
+			return putResp, fmt.Errorf("S3 returned status code 400 which could be an intermittent issue"), nil
+		}
+		return putResp, nil, nil
+	}
+	putResp, _, err := httpbackoff.Retry(httpCall)
+	if putResp != nil {
+		putResp.Body.Close()
+	}
+	return err
+}
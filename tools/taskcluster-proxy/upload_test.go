@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUploadArtifact(t *testing.T) {
+	var createArtifactRequest map[string]interface{}
+	var putHeader http.Header
+	var putBody []byte
+	var putContentLength int64
+	putAttempts := 0
+	var server *httptest.Server
+	server, routes := newFakeServiceRoutes(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/queue/v1/task/KTBKfEgxR5GdfIIREQIvFQ":
+			_, _ = w.Write([]byte(`{"expires": "2030-01-01T00:00:00.000Z"}`))
+		case r.Method == "POST" && r.URL.Path == "/api/queue/v1/task/KTBKfEgxR5GdfIIREQIvFQ/runs/1/artifacts/public/build/result.json":
+			if err := json.NewDecoder(r.Body).Decode(&createArtifactRequest); err != nil {
+				t.Errorf("Could not decode createArtifact request: %v", err)
+			}
+			_, _ = w.Write([]byte(`{"storageType": "s3", "putUrl": "` + server.URL + `/s3-put", "contentType": "application/json", "expires": "2030-01-01T00:00:00.000Z"}`))
+		case r.Method == "PUT" && r.URL.Path == "/s3-put":
+			// fail the first attempt, so that the body must be sent again
+			putAttempts++
+			if putAttempts == 1 {
+				w.WriteHeader(500)
+				return
+			}
+			putHeader = r.Header
+			putContentLength = r.ContentLength
+			putBody, _ = ioutil.ReadAll(r.Body)
+		default:
+			t.Errorf("Unexpected request %v %v", r.Method, r.URL)
+			w.WriteHeader(404)
+		}
+	})
+	defer server.Close()
+
+	upload := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("PUT", "http://localhost:60024/upload-artifact/public/build/result.json", bytes.NewReader([]byte(`{"result": "ok"}`)))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		routes.UploadArtifactHandler(res, req)
+		return res
+	}
+
+	// not enabled without an upload task
+	if res := upload(); res.Code != 404 {
+		t.Fatalf("Expected status 404 when uploads are not enabled but got %v", res.Code)
+	}
+
+	routes.uploadTaskID = "KTBKfEgxR5GdfIIREQIvFQ"
+	routes.uploadRunID = "1"
+	res := upload()
+	if res.Code != 201 {
+		t.Fatalf("Expected status 201 but got %v: %v", res.Code, res.Body.String())
+	}
+
+	if createArtifactRequest["storageType"] != "s3" || createArtifactRequest["contentType"] != "application/json" || createArtifactRequest["expires"] != "2030-01-01T00:00:00.000Z" {
+		t.Errorf("Unexpected createArtifact request %v", createArtifactRequest)
+	}
+	if putHeader.Get("Content-Encoding") != "gzip" || putHeader.Get("Content-Type") != "application/json" {
+		t.Errorf("Unexpected headers in PUT request: %v", putHeader)
+	}
+	if putAttempts != 2 {
+		t.Errorf("Expected the PUT request to be retried once, but got %v attempts", putAttempts)
+	}
+	if putContentLength != int64(len(putBody)) {
+		t.Errorf("Expected Content-Length %v but got %v", len(putBody), putContentLength)
+	}
+	gzipReader, err := gzip.NewReader(bytes.NewReader(putBody))
+	if err != nil {
+		t.Fatalf("PUT body is not gzipped: %v", err)
+	}
+	content, err := ioutil.ReadAll(gzipReader)
+	if err != nil || string(content) != `{"result": "ok"}` {
+		t.Errorf("Expected PUT body to be gzipped request body but got %q (%v)", content, err)
+	}
+
+	var response UploadArtifactResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Could not decode response %q: %v", res.Body.String(), err)
+	}
+	expectedURL := server.URL + "/api/queue/v1/task/KTBKfEgxR5GdfIIREQIvFQ/runs/1/artifacts/public/build/result.json"
+	if response.URL != expectedURL || res.Header().Get("Location") != expectedURL {
+		t.Errorf("Expected artifact url %v but got %v (Location: %v)", expectedURL, response.URL, res.Header().Get("Location"))
+	}
+	if response.Name != "public/build/result.json" || response.ContentType != "application/json" {
+		t.Errorf("Unexpected response %v", res.Body.String())
+	}
+}
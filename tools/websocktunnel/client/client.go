@@ -33,6 +33,12 @@ type Config struct {
 	// a "fresh" token for each call to the Configurer.
 	Token string
 
+	// If true, the tunnel carries raw TCP connections: the payloads of
+	// viewers' websocket messages are the data of the accepted streams, and
+	// data written to the streams is sent to viewers as binary messages.
+	// Otherwise, the streams carry HTTP requests.
+	TCP bool
+
 	// Configuration for retrying connections to the server
 	Retry RetryConfig
 
@@ -52,6 +58,7 @@ type Client struct {
 	id         string
 	tunnelAddr string
 	token      string
+	tcp        bool
 	url        atomic.Value
 	retry      RetryConfig
 	logger     util.Logger
@@ -60,6 +67,9 @@ type Client struct {
 	state      clientState
 	closed     chan struct{}
 	acceptErr  net.Error
+
+	// token with which the session can be resumed, if the proxy supports it
+	sessionToken string
 }
 
 // New creates a new Client instance.
@@ -72,12 +82,11 @@ func New(configurer Configurer) (*Client, error) {
 	cl := &Client{configurer: configurer}
 	cl.setConfig(config)
 	cl.closed = make(chan struct{}, 1)
-	conn, url, err := cl.connectWithRetry()
+	conn, res, err := cl.connectWithRetry()
 	if err != nil {
 		return nil, err
 	}
-	cl.url.Store(url)
-	cl.session = wsmux.Client(conn, wsmux.Config{})
+	cl.setSession(conn, res)
 	return cl, nil
 }
 
@@ -100,19 +109,32 @@ func (c *Client) Accept() (net.Conn, error) {
 	}
 
 	c.m.Lock()
-	defer c.m.Unlock()
 	if c.state == stateBroken || c.state == stateClosed {
+		defer c.m.Unlock()
 		return nil, c.acceptErr
 	}
+	session := c.session
+	c.m.Unlock()
+
+	// the lock is not held while waiting, so that the session can be resumed
+	// if its connection is lost meanwhile
+	stream, err := session.Accept()
+	if err == nil {
+		return stream, nil
+	}
 
-	stream, err := c.session.Accept()
-	if err != nil {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.session != session {
+		// the session has already been replaced
+		return nil, ErrClientReconnecting
+	}
+	if c.state == stateRunning {
 		c.state = stateBroken
 		c.acceptErr = ErrClientReconnecting
 		go c.reconnect()
-		return nil, c.acceptErr
 	}
-	return stream, nil
+	return nil, c.acceptErr
 }
 
 // Addr returns the net.Addr of the underlying wsmux session
@@ -135,6 +157,7 @@ func (c *Client) Close() error {
 		go func() {
 			c.m.Lock()
 			defer c.m.Unlock()
+			c.state = stateClosed
 			c.acceptErr = ErrClientClosed
 			_ = c.session.Close()
 		}()
@@ -146,6 +169,7 @@ func (c *Client) setConfig(config Config) {
 	c.id = config.ID
 	c.tunnelAddr = util.MakeWsURL(config.TunnelAddr)
 	c.token = config.Token
+	c.tcp = config.TCP
 
 	c.retry = config.Retry.withDefaultValues()
 	c.logger = config.Logger
@@ -154,13 +178,27 @@ func (c *Client) setConfig(config Config) {
 	}
 }
 
-// connectWithRetry returns a websocket connection to the tunnel
-func (c *Client) connectWithRetry() (*websocket.Conn, string, error) {
+// setSession starts a new session over the given connection, which is
+// resumable if the proxy gave a token to resume it with
+func (c *Client) setSession(conn *websocket.Conn, res *http.Response) {
+	c.sessionToken = res.Header.Get("x-websocktunnel-session")
+	c.session = wsmux.Client(conn, wsmux.Config{
+		Resumable: c.sessionToken != "",
+		DisconnectCallback: func() {
+			go c.reconnect()
+		},
+	})
+	c.url.Store(res.Header.Get("x-websocktunnel-client-url"))
+}
+
+// connectWithRetry returns a websocket connection to the tunnel, and the
+// proxy's response
+func (c *Client) connectWithRetry() (*websocket.Conn, *http.Response, error) {
 	// if token is expired or not usable, get a new token from the authorizer
 	if !util.IsTokenUsable(c.token) {
 		config, err := c.configurer()
 		if err != nil {
-			return nil, "", err
+			return nil, nil, err
 		}
 		c.setConfig(config)
 	}
@@ -168,6 +206,14 @@ func (c *Client) connectWithRetry() (*websocket.Conn, string, error) {
 	header := make(http.Header)
 	header.Set("Authorization", "Bearer "+c.token)
 	header.Set("x-websocktunnel-id", c.id)
+	if c.tcp {
+		header.Set("x-websocktunnel-mode", "tcp")
+	}
+	// ask for a resumable session, or to resume the existing one
+	header.Set("x-websocktunnel-resumable", "true")
+	if c.sessionToken != "" {
+		header.Set("x-websocktunnel-session", c.sessionToken)
+	}
 
 	currentDelay := c.retry.InitialDelay
 	maxTimer := time.After(c.retry.MaxElapsedTime)
@@ -178,33 +224,31 @@ func (c *Client) connectWithRetry() (*websocket.Conn, string, error) {
 		conn, res, err := websocket.DefaultDialer.Dial(c.tunnelAddr, header)
 		if err == nil {
 			c.logger.Printf("connected to %s ", c.tunnelAddr)
-			url := res.Header.Get("x-websocktunnel-client-url")
-			return conn, url, err
+			return c.checkConnection(conn, res)
 		}
 
 		if !shouldRetry(res) {
 			c.logger.Printf("connection failed with error:%v, response:%v", err, res)
 			if isAuthError(res) {
-				return nil, "", ErrAuthFailed
+				return nil, nil, ErrAuthFailed
 			}
-			return nil, "", ErrRetryFailed
+			return nil, nil, ErrRetryFailed
 		}
 		c.logger.Printf("connection to %s failed -- retrying.", c.tunnelAddr)
 
 		// wait for the next time to try connecting
 		select {
 		case <-maxTimer:
-			return nil, "", ErrRetryTimedOut
+			return nil, nil, ErrRetryTimedOut
 		case <-backoff:
 			c.logger.Printf("trying to connect to %s", c.tunnelAddr)
 			conn, res, err := websocket.DefaultDialer.Dial(c.tunnelAddr, header)
 			if err == nil {
-				url := res.Header.Get("x-websocktunnel-client-url")
-				return conn, url, nil
+				return c.checkConnection(conn, res)
 			}
 			if !shouldRetry(res) {
 				c.logger.Printf("connection to %s failed. could not connect", c.tunnelAddr)
-				return nil, "", ErrRetryFailed
+				return nil, nil, ErrRetryFailed
 			}
 
 			currentDelay = c.retry.nextDelay(currentDelay)
@@ -213,33 +257,62 @@ func (c *Client) connectWithRetry() (*websocket.Conn, string, error) {
 	}
 }
 
-// reconnect is used to repair broken connections
+// checkConnection returns a new connection to the tunnel and the proxy's
+// response, after checking that the proxy supports the client's mode
+func (c *Client) checkConnection(conn *websocket.Conn, res *http.Response) (*websocket.Conn, *http.Response, error) {
+	if c.tcp && res.Header.Get("x-websocktunnel-mode") != "tcp" {
+		c.logger.Printf("%s does not support tcp tunnels", c.tunnelAddr)
+		_ = conn.Close()
+		return nil, nil, ErrTCPNotSupported
+	}
+	return conn, res, nil
+}
+
+// reconnect is used to repair broken connections.  If the session is
+// resumable and the proxy still has it, it is resumed over the new
+// connection, so that its streams continue; otherwise it is replaced by a new
+// session.
 func (c *Client) reconnect() {
 	c.m.Lock()
 	defer c.m.Unlock()
-	conn, url, err := c.connectWithRetry()
+	if c.state == stateClosed {
+		return
+	}
+	if c.session.IsClosed() {
+		c.sessionToken = ""
+	}
+
+	conn, res, err := c.connectWithRetry()
 	if err != nil {
 		// set error and return
 		c.logger.Printf("unable to reconnect to %s", c.tunnelAddr)
+		c.state = stateBroken
 		c.acceptErr = ErrRetryFailed
+		// a session waiting to be resumed is closed, ending pending Accept calls
+		_ = c.session.Close()
 		return
 	}
 
-	if c.session != nil {
-		_ = c.session.Close()
-		c.session = nil
+	if c.sessionToken != "" && res.Header.Get("x-websocktunnel-resumed") == "true" {
+		err := c.session.Resume(conn)
+		if err == nil {
+			c.logger.Printf("session resumed")
+			return
+		}
+		// the session closed before it could be resumed, so start again with
+		// a new session
+		c.logger.Printf("unable to resume session: %v", err)
+		c.state = stateBroken
+		c.acceptErr = ErrClientReconnecting
+		go c.reconnect()
+		return
 	}
 
-	sessionConfig := wsmux.Config{
-		// Log:              c.logger,
-		StreamBufferSize: 4 * 1024,
-	}
-	c.session = wsmux.Client(conn, sessionConfig)
-	c.url.Store(url)
+	_ = c.session.Close()
+	c.setSession(conn, res)
 	c.state = stateRunning
 	c.logger.Printf("state: running")
 	c.acceptErr = nil
-
 }
 
 // simple utility to check if client should retry connection
@@ -42,4 +42,8 @@ var (
 
 	// ErrAuthFailed is returned when authentication with the proxy fails
 	ErrAuthFailed = Error{errString: "auth failed", auth: true}
+
+	// ErrTCPNotSupported is returned when the client is configured to carry
+	// TCP connections, but the proxy does not support tcp tunnels.
+	ErrTCPNotSupported = Error{errString: "proxy does not support tcp tunnels"}
 )
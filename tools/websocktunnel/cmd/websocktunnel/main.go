@@ -1,11 +1,15 @@
 package main
 
 import (
+	"crypto"
 	"crypto/tls"
 	"encoding/base64"
+	"io"
+	"io/ioutil"
 	"log/syslog"
 	"net/http"
 	"os"
+	"strconv"
 
 	docopt "github.com/docopt/docopt-go"
 	"github.com/gorilla/websocket"
@@ -28,8 +32,30 @@ Environment:
  TLS_KEY                                     corresponding base64-encoded TLS key
  TASKCLUSTER_PROXY_SECRET_A                  JWT secret
  TASKCLUSTER_PROXY_SECRET_B                  alternate JWT secret
+ JWT_PUBLIC_KEY                              base64-encoded PEM RSA or Ed25519 public key, to verify
+                                             RS256 or EdDSA JWTs without a key ID (optional)
+ JWKS_FILE                                   path of a JSON Web Key Set file with public keys to
+                                             verify RS256 or EdDSA JWTs, by key ID (optional)
+ JWKS_URL                                    URL of a JSON Web Key Set, refetched when a JWT has
+                                             an unknown key ID, and at most every minute otherwise
+                                             (optional)
  SYSLOG_ADDR                                 address to which to send syslog output
  AUDIENCE                                    JWT 'audience' claim
+ REDIS_ADDR                                  host:port of a Redis-compatible store in which to record
+                                             the replica each client is connected to, when running
+                                             multiple replicas (optional)
+ REDIS_PASSWORD                              password for REDIS_ADDR (optional)
+ REPLICA_URL                                 URL at which other replicas can reach this one
+                                             (required with REDIS_ADDR)
+ BYTES_PER_SECOND                            bandwidth limit of each client, in bytes per second
+                                             (optional; default unlimited)
+ MAX_CONCURRENT_STREAMS                      limit on concurrent viewer requests to each client
+                                             (optional; default unlimited)
+ ADMIN_TOKEN                                 bearer token for the admin API at /__admin__/, which
+                                             is disabled if not set (optional)
+ METRICS_TOKEN                               bearer token for the usage metrics at /__metrics__,
+                                             which also accept ADMIN_TOKEN, and are disabled if
+                                             neither is set (optional)
 
 Options:
 -h --help       Show help`
@@ -65,6 +91,32 @@ func main() {
 	signingSecretA := os.Getenv("TASKCLUSTER_PROXY_SECRET_A")
 	signingSecretB := os.Getenv("TASKCLUSTER_PROXY_SECRET_B")
 
+	// Load public keys
+	publicKeys := map[string]crypto.PublicKey{}
+	if publicKeyEnc := os.Getenv("JWT_PUBLIC_KEY"); publicKeyEnc != "" {
+		publicKeyPEM, err := base64.StdEncoding.DecodeString(publicKeyEnc)
+		if err != nil {
+			panic("JWT_PUBLIC_KEY must be base64-encoded")
+		}
+		publicKeys[""], err = wsproxy.ParsePublicKeyPEM(publicKeyPEM)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if jwksFile := os.Getenv("JWKS_FILE"); jwksFile != "" {
+		data, err := ioutil.ReadFile(jwksFile)
+		if err != nil {
+			panic(err)
+		}
+		keys, err := wsproxy.ParseJWKS(data)
+		if err != nil {
+			panic(err)
+		}
+		for kid, key := range keys {
+			publicKeys[kid] = key
+		}
+	}
+
 	// Load TLS certificates
 	useTLS := true
 	tlsKeyEnc := os.Getenv("TLS_KEY")
@@ -97,15 +149,56 @@ func main() {
 		},
 	}
 
+	// share the location of clients with other replicas, if configured
+	var directory wsproxy.Directory
+	if redisAddr := os.Getenv("REDIS_ADDR"); redisAddr != "" {
+		directory = wsproxy.NewRedisDirectory(wsproxy.RedisDirectoryConfig{
+			Addr:      redisAddr,
+			Password:  os.Getenv("REDIS_PASSWORD"),
+			KeyPrefix: "websocktunnel:",
+		})
+	}
+
+	// load per-client limits
+	var bytesPerSecond int64
+	if v := os.Getenv("BYTES_PER_SECOND"); v != "" {
+		bytesPerSecond, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			panic("BYTES_PER_SECOND must be an integer")
+		}
+	}
+	var maxConcurrentStreams int
+	if v := os.Getenv("MAX_CONCURRENT_STREAMS"); v != "" {
+		maxConcurrentStreams, err = strconv.Atoi(v)
+		if err != nil {
+			panic("MAX_CONCURRENT_STREAMS must be an integer")
+		}
+	}
+
 	// will panic if secrets are not loaded
-	proxy, _ := wsproxy.New(wsproxy.Config{
+	proxy, err := wsproxy.New(wsproxy.Config{
 		Logger:     logger,
 		Upgrader:   upgrader,
 		JWTSecretA: []byte(signingSecretA),
 		JWTSecretB: []byte(signingSecretB),
+		PublicKeys: publicKeys,
+		JWKSURL:    os.Getenv("JWKS_URL"),
 		URLPrefix:  urlPrefix,
 		Audience:   audience,
+		Directory:  directory,
+		ReplicaURL: os.Getenv("REPLICA_URL"),
+
+		BytesPerSecond:       bytesPerSecond,
+		MaxConcurrentStreams: maxConcurrentStreams,
+		AdminToken:           os.Getenv("ADMIN_TOKEN"),
+		MetricsToken:         os.Getenv("METRICS_TOKEN"),
 	})
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		_ = proxy.(io.Closer).Close()
+	}()
 
 	server := &http.Server{Addr: ":" + port, Handler: proxy}
 	defer func() {
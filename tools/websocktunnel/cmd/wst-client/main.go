@@ -26,7 +26,7 @@ the internet.
 
 Usage:
     wst-client <wstServer> <wstClientID> <targetPort> [--token <jwtToken>] [--out-file=<outFile>]
-	           [--verbose] [--json] 
+	           [--tcp] [--verbose] [--json] 
     wst-client -h | --help
 
 The wstClientID is the ID to register with the websocktunnel server.  The JWT
@@ -38,11 +38,18 @@ subprocess of some other server for which it is managing the tunnel.  That
 server can then "feed" wst-client a new token before the most recent token
 expires.
 
+With --tcp, the tunnel carries raw TCP connections rather than HTTP requests:
+each websocket connection a viewer makes to the client's URL (at any path) is
+connected to <targetPort>, with the payloads of the viewer's binary messages
+sent to the port and data from the port returned as binary messages.  This
+allows exposing services such as SSH or VNC.
+
 Options:
 -h --help               Show help
 --verbose               Verbose logging
 --token                 JWT Token, if not given on stdin (see above)
 --out-file=<outFile>    Dump url to this file
+--tcp                   Tunnel raw TCP connections instead of HTTP requests
 --json                  Output logs in JSON format`
 
 const closeWait = 2 * time.Second
@@ -87,7 +94,9 @@ func main() {
 	// accept new streams from this channel
 	strChan := make(chan net.Conn, 1)
 
-	client, err := client.New(makeConfigurer(wstServer, wstClientID, jwtToken))
+	tcp := arguments["--tcp"].(bool)
+
+	client, err := client.New(makeConfigurer(wstServer, wstClientID, jwtToken, tcp))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -216,13 +225,14 @@ func (f *forwarder) kill() {
 	}
 }
 
-func makeConfigurer(wstServer, wstClientID, jwtToken string) func() (client.Config, error) {
+func makeConfigurer(wstServer, wstClientID, jwtToken string, tcp bool) func() (client.Config, error) {
 	if jwtToken != "" {
 		configurer := func() (client.Config, error) {
 			return client.Config{
 				ID:         wstClientID,
 				Token:      jwtToken,
 				TunnelAddr: wstServer,
+				TCP:        tcp,
 			}, nil
 		}
 		return configurer
@@ -266,6 +276,7 @@ func makeConfigurer(wstServer, wstClientID, jwtToken string) func() (client.Conf
 			ID:         wstClientID,
 			Token:      token,
 			TunnelAddr: wstServer,
+			TCP:        tcp,
 		}, nil
 	}
 	return configurer
@@ -5,32 +5,39 @@ import (
 	"crypto/sha256"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/taskcluster/taskcluster/v30/tools/websocktunnel/util"
 )
 
+// Run with `go test -run XXX -bench . ./wsmux`.  The *FixedWindow variants use
+// the fixed 4KiB stream buffers that websocktunnel used before buffers could
+// grow, for comparison; the *SamePriority variant shows the latency of an
+// interactive stream which is not prioritized over bulk transfers.
+
 // utils
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  64 * 1024,
 	WriteBufferSize: 64 * 1024,
 }
 
-func echoFunc(b *testing.B, server *Session, wg *sync.WaitGroup) {
-	if wg != nil {
-		defer wg.Done()
-	}
+// size of each transfer
+const transferSize = 4 * 1024 * 1024
+
+const maxTransferStreams = 100
+
+var fixedWindowConfig = Config{StreamBufferSize: 4 * 1024, MaxStreamBufferSize: 4 * 1024}
+
+func echoFunc(str io.ReadWriteCloser) {
 	hash := sha256.New()
-	str, err := server.Accept()
-	if err != nil {
-		panic(err)
-	}
 	// copy does not report EOF as error
-	_, err = io.Copy(hash, str)
+	_, err := io.Copy(hash, str)
 	if err != nil {
 		panic(err)
 	}
@@ -44,7 +51,7 @@ func echoFunc(b *testing.B, server *Session, wg *sync.WaitGroup) {
 	}
 }
 
-func echoClientFunc(b *testing.B, client *Session, wg *sync.WaitGroup) {
+func echoClientFunc(client *Session, buf []byte, wg *sync.WaitGroup) {
 	if wg != nil {
 		defer wg.Done()
 	}
@@ -54,15 +61,7 @@ func echoClientFunc(b *testing.B, client *Session, wg *sync.WaitGroup) {
 		panic(err)
 	}
 
-	// write 4MB of data
-	size := 4 * 1024 * 1024
-	buf := make([]byte, size)
-	for i := 0; i < size; i++ {
-		buf[i] = byte(i % 127)
-	}
-
 	_, _ = hash.Write(buf)
-	b.ResetTimer()
 	_, err = str.Write(buf)
 	if err != nil {
 		panic(err)
@@ -83,27 +82,17 @@ func echoClientFunc(b *testing.B, client *Session, wg *sync.WaitGroup) {
 	}
 }
 
-func genTransferHandler(b *testing.B) http.Handler {
-	tr := func(w http.ResponseWriter, r *http.Request) {
-		if !websocket.IsWebSocketUpgrade(r) {
-			http.NotFound(w, r)
-			return
-		}
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			b.Fatal(err)
-		}
-
-		server := Server(conn, Config{StreamBufferSize: 4 * 1024})
-		echoFunc(b, server, nil)
-
+func transferData() []byte {
+	buf := make([]byte, transferSize)
+	for i := 0; i < transferSize; i++ {
+		buf[i] = byte(i % 127)
 	}
-	return http.HandlerFunc(tr)
+	return buf
 }
 
-const maxTransferStreams = 100
-
-func genMultiTransferHandler(b *testing.B) http.Handler {
+// genSessionHandler returns a handler which creates a server session with the
+// given config, and handles each of its streams with handleStream
+func genSessionHandler(b *testing.B, conf Config, handleStream func(PriorityConn)) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !websocket.IsWebSocketUpgrade(r) {
 			http.NotFound(w, r)
@@ -114,44 +103,150 @@ func genMultiTransferHandler(b *testing.B) http.Handler {
 			b.Fatal(err)
 		}
 
-		server := Server(conn, Config{StreamBufferSize: 4 * 1024})
+		server := Server(conn, conf)
+		for {
+			str, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go handleStream(str.(PriorityConn))
+		}
+	})
+}
 
+func dialSession(b *testing.B, server *httptest.Server, conf Config) *Session {
+	conn, _, err := websocket.DefaultDialer.Dial(util.MakeWsURL(server.URL), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return Client(conn, conf)
+}
+
+// benchmarkTransfer transfers transferSize bytes over each of the given
+// number of concurrent streams, b.N times
+func benchmarkTransfer(b *testing.B, conf Config, streams int) {
+	server := httptest.NewServer(genSessionHandler(b, conf, func(str PriorityConn) {
+		echoFunc(str)
+	}))
+	defer server.Close()
+	client := dialSession(b, server, conf)
+	defer client.Close()
+
+	buf := transferData()
+	b.SetBytes(int64(streams * transferSize))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
 		wg := new(sync.WaitGroup)
-		for i := 0; i < maxTransferStreams; i++ {
+		for i := 0; i < streams; i++ {
 			wg.Add(1)
-			go echoFunc(b, server, wg)
+			go echoClientFunc(client, buf, wg)
 		}
 		wg.Wait()
-	})
+	}
 }
 
 // test large transfer
 func BenchmarkTransfer(b *testing.B) {
-	server := httptest.NewServer(genTransferHandler(b))
-	url := server.URL
-	defer server.Close()
-	conn, _, err := websocket.DefaultDialer.Dial(util.MakeWsURL(url), nil)
-	if err != nil {
-		b.Fatal(err)
-	}
-	client := Client(conn, Config{StreamBufferSize: 4 * 1024})
-	echoClientFunc(b, client, nil)
+	benchmarkTransfer(b, Config{}, 1)
+}
+
+func BenchmarkTransferFixedWindow(b *testing.B) {
+	benchmarkTransfer(b, fixedWindowConfig, 1)
 }
 
 // test transfer over multiple streams
 func BenchmarkMultiTransfer(b *testing.B) {
-	server := httptest.NewServer(genMultiTransferHandler(b))
-	url := server.URL
+	benchmarkTransfer(b, Config{}, maxTransferStreams)
+}
+
+func BenchmarkMultiTransferFixedWindow(b *testing.B) {
+	benchmarkTransfer(b, fixedWindowConfig, maxTransferStreams)
+}
+
+// throttledListener simulates a network link of limited bandwidth, so that
+// data queues in the session rather than in socket buffers
+type throttledListener struct {
+	net.Listener
+	bytesPerSecond int
+}
+
+func (l throttledListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	return throttledConn{Conn: conn, bytesPerSecond: l.bytesPerSecond}, err
+}
+
+type throttledConn struct {
+	net.Conn
+	bytesPerSecond int
+}
+
+func (c throttledConn) Write(p []byte) (int, error) {
+	time.Sleep(time.Duration(len(p)) * time.Second / time.Duration(c.bytesPerSecond))
+	return c.Conn.Write(p)
+}
+
+// benchmarkInteractive measures the round trip time of small messages on a
+// stream with the given priority, while low-priority streams transfer bulk
+// data in the same direction as the responses, over a 50MB/s link
+func benchmarkInteractive(b *testing.B, priority Priority) {
+	bulk := transferData()
+	server := httptest.NewUnstartedServer(genSessionHandler(b, Config{}, func(str PriorityConn) {
+		// streams opened at PriorityLow receive bulk data until they are
+		// closed; others are echoed, with the priority being measured
+		if str.Priority() != PriorityLow {
+			str.SetPriority(priority)
+			_, _ = io.Copy(str, str)
+			_ = str.Close()
+			return
+		}
+		for {
+			if _, err := str.Write(bulk); err != nil {
+				return
+			}
+		}
+	}))
+	server.Listener = throttledListener{Listener: server.Listener, bytesPerSecond: 50 * 1024 * 1024}
+	server.Start()
 	defer server.Close()
-	conn, _, err := websocket.DefaultDialer.Dial(util.MakeWsURL(url), nil)
+	client := dialSession(b, server, Config{})
+	defer client.Close()
+
+	const bulkStreams = 32
+	for i := 0; i < bulkStreams; i++ {
+		str, err := client.OpenWithPriority(PriorityLow)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer str.Close()
+		go func() {
+			_, _ = io.Copy(ioutil.Discard, str)
+		}()
+	}
+
+	str, err := client.OpenWithPriority(PriorityHigh)
 	if err != nil {
 		b.Fatal(err)
 	}
-	client := Client(conn, Config{StreamBufferSize: 4 * 1024})
-	wg := new(sync.WaitGroup)
-	for i := 0; i < maxTransferStreams; i++ {
-		wg.Add(1)
-		go echoClientFunc(b, client, wg)
+	str.(PriorityConn).SetPriority(priority)
+	defer str.Close()
+
+	msg := make([]byte, 100)
+	reply := make([]byte, len(msg))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := str.Write(msg); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(str, reply); err != nil {
+			b.Fatal(err)
+		}
 	}
-	wg.Wait()
+}
+
+func BenchmarkInteractive(b *testing.B) {
+	benchmarkInteractive(b, PriorityHigh)
+}
+
+func BenchmarkInteractiveSamePriority(b *testing.B) {
+	benchmarkInteractive(b, PriorityLow)
 }
@@ -75,6 +75,20 @@ func (b *buffer) Write(buf []byte) (int, error) {
 	return len(buf), nil
 }
 
+// grow increases the capacity of the buffer, retaining its contents
+func (b *buffer) grow(capacity int) {
+	if capacity <= b.cap {
+		return
+	}
+	buf := make([]byte, capacity)
+	n, _ := b.Read(buf)
+	b.buf = buf
+	b.start = 0
+	b.end = n
+	b.cap = capacity
+	b.empty = n == 0
+}
+
 // utility
 func (b *buffer) spare() int {
 	return b.cap - b.Len()
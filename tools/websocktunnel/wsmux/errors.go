@@ -39,4 +39,16 @@ var (
 
 	// ErrTooManySyns indicates too many un-accepted new incoming streams
 	ErrTooManySyns = errors.New("too many un-accepted new incoming streams")
+
+	// ErrNotResumable is returned when resuming a session that was not created
+	// with Config.Resumable
+	ErrNotResumable = errors.New("session is not resumable")
+
+	// ErrResumeTimeout is returned when a session is not resumed within the
+	// resume timeout after its connection is lost
+	ErrResumeTimeout = errors.New("session was not resumed in time")
+
+	// ErrResumeFailed indicates that the remote end acknowledged frames which
+	// this end cannot account for, so the session cannot continue
+	ErrResumeFailed = errors.New("could not resume session")
 )
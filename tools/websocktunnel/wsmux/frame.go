@@ -15,9 +15,11 @@ const (
 	msgACK byte = 2
 	// Used to close a stream
 	msgFIN byte = 3
+	// Acknowledges frames received on a resumable session
+	msgRCV byte = 4
 
 	// last message type
-	msgMax byte = msgFIN
+	msgMax byte = msgRCV
 )
 
 // header contains a frame header.  It contains an 8-bit message type (`msg`,
@@ -53,10 +55,22 @@ func newHeader(msg byte, id uint32) header {
 // type:
 //
 // * msgDAT: the payload is the binary data
-// * msgSYN: no payload
+// * msgSYN: optionally, a u8 priority followed by a little-endian u32 giving the
+//   initial capacity of the opening end's read buffer.  Without these, the
+//   priority is PriorityNormal and the capacity is legacyStreamWindow.
 // * msgACK: payload is a little-endian u32 indicating the number of bytes handled
-//   on the remote end and thus no longer "in flight".
+//   on the remote end and thus no longer "in flight", plus any additional
+//   capacity of the remote end's read buffer.
+//   The msgACK accepting a stream gives the capacity of the accepting end's
+//   read buffer, followed by a u8 1 if the msgSYN payload was understood.
+//   Earlier versions ignore the msgSYN payload, and instead assume that the
+//   opening end's read buffer is the same size as their own.
 // * msgFIN: no payload
+// * msgRCV: only used on resumable sessions, with stream ID 0.  The payload is
+//   a little-endian u64 giving the number of frames received from the remote
+//   end, not counting msgRCV frames.  It is also the first frame sent on a
+//   resumed connection, after which the remote end sends again any frames
+//   that were not received.
 type frame struct {
 	id      uint32
 	msg     byte
@@ -102,11 +116,17 @@ func (f frame) String() string {
 		str += "DAT " + bytes.NewBuffer(f.payload).String()
 	case msgSYN:
 		str += "SYN"
+		if priority, cap := f.synParams(); len(f.payload) > 0 {
+			str += " " + strconv.Itoa(int(priority)) + " " + strconv.Itoa(int(cap))
+		}
 	case msgACK:
 		str += "ACK "
 		str += strconv.Itoa(int(binary.LittleEndian.Uint32(f.payload)))
 	case msgFIN:
 		str += "FIN"
+	case msgRCV:
+		str += "RCV "
+		str += strconv.FormatUint(binary.LittleEndian.Uint64(f.payload), 10)
 	}
 	return str
 }
@@ -119,12 +139,25 @@ func newDataFrame(id uint32, buf []byte) frame {
 	return frame
 }
 
-// newSynFrame creates a new msgSYN frame.
-func newSynFrame(id uint32) frame {
-	frame := frame{id: id, msg: msgSYN, payload: nil}
+// newSynFrame creates a new msgSYN frame, giving the priority of the stream
+// and the capacity of its read buffer.
+func newSynFrame(id uint32, priority Priority, cap uint32) frame {
+	frame := frame{id: id, msg: msgSYN}
+	frame.payload = make([]byte, 5)
+	frame.payload[0] = byte(priority)
+	binary.LittleEndian.PutUint32(frame.payload[1:], cap)
 	return frame
 }
 
+// synParams returns the priority and buffer capacity given in a msgSYN frame,
+// using defaults if the remote end did not send them.
+func (f frame) synParams() (Priority, uint32) {
+	if len(f.payload) < 5 || Priority(f.payload[0]) > PriorityHigh {
+		return PriorityNormal, legacyStreamWindow
+	}
+	return Priority(f.payload[0]), binary.LittleEndian.Uint32(f.payload[1:])
+}
+
 // newSynFrame creates a new msgACK frame containing the given capacity.
 func newAckFrame(id uint32, cap uint32) frame {
 	frame := frame{id: id, msg: msgACK}
@@ -133,7 +166,30 @@ func newAckFrame(id uint32, cap uint32) frame {
 	return frame
 }
 
+// newAcceptFrame creates the msgACK frame accepting a stream, containing the
+// capacity of the read buffer and indicating that the msgSYN payload was
+// understood.
+func newAcceptFrame(id uint32, cap uint32) frame {
+	frame := newAckFrame(id, cap)
+	frame.payload = append(frame.payload, 1)
+	return frame
+}
+
+// synParamsUnderstood returns true if a msgACK frame accepting a stream
+// indicates that the remote end understood the msgSYN payload.
+func (f frame) synParamsUnderstood() bool {
+	return len(f.payload) > 4 && f.payload[4] == 1
+}
+
 // newSynFrame creates a new msgFIN frame.
 func newFinFrame(id uint32) frame {
 	return frame{id: id, msg: msgFIN, payload: nil}
 }
+
+// newRcvFrame creates a new msgRCV frame giving the number of frames received.
+func newRcvFrame(received uint64) frame {
+	frame := frame{id: 0, msg: msgRCV}
+	frame.payload = make([]byte, 8)
+	binary.LittleEndian.PutUint64(frame.payload, received)
+	return frame
+}
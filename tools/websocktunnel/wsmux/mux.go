@@ -31,9 +31,31 @@ type Config struct {
 	// Log must implement util.Logger. This defaults to NilLogger.
 	Log util.Logger
 
-	// StreamBufferSize sets the maximum buffer size of streams created by the session.
-	// Default: 1024 bytes
+	// StreamBufferSize sets the initial buffer size of streams created by the session,
+	// which is the amount of data the remote end may send before it is read.
+	// Default: 16KiB
 	StreamBufferSize int
+
+	// MaxStreamBufferSize is the size to which stream buffers may grow.  The buffer of
+	// a stream is doubled whenever its reader consumes a full buffer within two round
+	// trips, as the buffer size is then limiting the stream's throughput.  Set this to
+	// StreamBufferSize to disable growth.  Default: 1MiB
+	MaxStreamBufferSize int
+
+	// Resumable makes the session survive the loss of its websocket connection.
+	// Frames sent by a resumable session are kept until the remote end
+	// acknowledges them, and the session can be continued over a new connection
+	// with `session.Resume(..)`, which sends again any frames that were lost.  Both
+	// ends of a session must be resumable, as this changes the protocol.
+	Resumable bool
+
+	// ResumeTimeout is the time for which a resumable session waits to be
+	// resumed after its connection is lost, before closing.  Default: 30 seconds
+	ResumeTimeout time.Duration
+
+	// DisconnectCallback is invoked when a resumable session loses its
+	// connection, and should arrange for the session to be resumed.
+	DisconnectCallback func()
 }
 
 // Server instantiates a new server session over a websocket connection.
@@ -0,0 +1,109 @@
+package wsmux
+
+import (
+	"net"
+	"sync"
+)
+
+// Priority determines the order in which frames of different streams are
+// sent over the websocket connection.  When several streams are waiting to
+// send, frames of higher-priority streams are sent first, while streams of
+// the same priority take turns.  Lower-priority streams are still given an
+// occasional turn, so that they are not starved.
+type Priority uint8
+
+const (
+	// PriorityLow is for bulk transfers, which should not delay other streams.
+	PriorityLow Priority = iota
+
+	// PriorityNormal is the priority of streams opened with Session.Open.
+	PriorityNormal
+
+	// PriorityHigh is for interactive streams, which should be scheduled
+	// ahead of bulk transfers.
+	PriorityHigh
+
+	// priorityControl is used for frames other than msgDAT, which are small
+	// and are needed to keep streams flowing.
+	priorityControl
+
+	numPriorities = int(priorityControl) + 1
+)
+
+// PriorityConn is implemented by the net.Conn values returned from
+// Session.Open and Session.Accept, allowing their priority to be adjusted.
+type PriorityConn interface {
+	net.Conn
+
+	// Priority returns the priority with which this end sends data.
+	Priority() Priority
+
+	// SetPriority sets the priority with which this end sends data.  The
+	// priority of a stream is sent to the remote end when it is opened, but
+	// later changes only apply locally.
+	SetPriority(Priority)
+}
+
+// starvationLimit is the number of times waiting senders of a priority may be
+// passed over in favour of higher priorities before one of them is served
+// anyway, so that lower-priority streams make progress under sustained load.
+const starvationLimit = 16
+
+// sendScheduler serializes sending on the websocket connection.  Unlike a
+// mutex, it hands the connection to waiting senders in order of priority, and
+// in order of arrival within each priority.
+type sendScheduler struct {
+	m       sync.Mutex
+	busy    bool
+	waiters [numPriorities][]chan struct{}
+
+	// number of consecutive times the waiters of each priority have been
+	// passed over
+	skipped [numPriorities]int
+}
+
+// acquire waits until the caller may send.  It must be followed by a call to
+// release.
+func (q *sendScheduler) acquire(p Priority) {
+	q.m.Lock()
+	if !q.busy {
+		q.busy = true
+		q.m.Unlock()
+		return
+	}
+	ch := make(chan struct{})
+	q.waiters[p] = append(q.waiters[p], ch)
+	q.m.Unlock()
+	<-ch
+}
+
+// release hands the connection to the next waiting sender, if any.
+func (q *sendScheduler) release() {
+	q.m.Lock()
+	defer q.m.Unlock()
+	next := -1
+	for p := numPriorities - 1; p >= 0; p-- {
+		if len(q.waiters[p]) == 0 {
+			q.skipped[p] = 0
+		} else if next == -1 {
+			next = p
+		} else {
+			q.skipped[p]++
+		}
+	}
+	if next == -1 {
+		q.busy = false
+		return
+	}
+	for p := 0; p < next; p++ {
+		if q.skipped[p] > starvationLimit {
+			next = p
+			break
+		}
+	}
+	q.skipped[next] = 0
+	ch := q.waiters[next][0]
+	q.waiters[next] = q.waiters[next][1:]
+	// busy remains true, as the connection passes to the waiter
+	close(ch)
+}
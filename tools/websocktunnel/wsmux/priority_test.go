@@ -0,0 +1,91 @@
+package wsmux
+
+import (
+	"testing"
+	"time"
+)
+
+// startWaiter starts a goroutine that acquires q with priority p, returning a
+// channel that is closed once it has.  It returns once the goroutine is
+// waiting.
+func startWaiter(q *sendScheduler, p Priority) chan struct{} {
+	acquired := make(chan struct{})
+	q.m.Lock()
+	waiting := len(q.waiters[p])
+	q.m.Unlock()
+	go func() {
+		q.acquire(p)
+		close(acquired)
+	}()
+	for {
+		q.m.Lock()
+		n := len(q.waiters[p])
+		q.m.Unlock()
+		if n > waiting {
+			return acquired
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// expectAcquired releases q and checks that the waiter with the given channel
+// is the one that acquires it.
+func expectAcquired(t *testing.T, q *sendScheduler, acquired chan struct{}, others ...chan struct{}) {
+	q.release()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected waiter did not acquire the scheduler")
+	}
+	for _, other := range others {
+		select {
+		case <-other:
+			t.Fatal("unexpected waiter acquired the scheduler")
+		default:
+		}
+	}
+}
+
+func TestSendSchedulerOrder(t *testing.T) {
+	q := &sendScheduler{}
+	q.acquire(PriorityNormal)
+
+	low := startWaiter(q, PriorityLow)
+	normal1 := startWaiter(q, PriorityNormal)
+	high := startWaiter(q, PriorityHigh)
+	normal2 := startWaiter(q, PriorityNormal)
+	control := startWaiter(q, priorityControl)
+
+	// higher priorities first, and in order of arrival within a priority
+	expectAcquired(t, q, control, low, normal1, high, normal2)
+	expectAcquired(t, q, high, low, normal1, normal2)
+	expectAcquired(t, q, normal1, low, normal2)
+	expectAcquired(t, q, normal2, low)
+	expectAcquired(t, q, low)
+
+	q.release()
+	if q.busy {
+		t.Fatal("scheduler should not be busy with no waiters")
+	}
+
+	// with no waiters, acquire does not wait
+	q.acquire(PriorityLow)
+	q.release()
+}
+
+func TestSendSchedulerStarvation(t *testing.T) {
+	q := &sendScheduler{}
+	q.acquire(PriorityHigh)
+	low := startWaiter(q, PriorityLow)
+
+	// a low-priority waiter is passed over while there are high-priority
+	// waiters, but only up to starvationLimit times
+	for i := 0; i < starvationLimit; i++ {
+		high := startWaiter(q, PriorityHigh)
+		expectAcquired(t, q, high, low)
+	}
+	high := startWaiter(q, PriorityHigh)
+	expectAcquired(t, q, low, high)
+	expectAcquired(t, q, high)
+	q.release()
+}
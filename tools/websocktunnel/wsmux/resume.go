@@ -0,0 +1,247 @@
+package wsmux
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// a msgRCV frame is sent after receiving this many frames, limiting the
+// number of frames the remote end must keep
+const receiptInterval = 32
+
+type connState int
+
+const (
+	// frames are sent as they are written
+	connConnected connState = iota
+
+	// the connection is lost, and the session waits to be resumed; frames
+	// are only kept
+	connLost
+
+	// the session has been resumed on a new connection, and frames are kept
+	// until the remote end says which it has received
+	connResuming
+)
+
+// replayBuffer holds the frames sent on a resumable session which the remote
+// end has not yet acknowledged receiving.  Frames are added while holding the
+// session's sendQueue, but acknowledged by the receiving goroutine, which must
+// not wait for sendQueue.
+type replayBuffer struct {
+	m sync.Mutex
+
+	// frames, in the order they were sent
+	frames [][]byte
+
+	// number of frames sent before frames[0], all of which the remote end
+	// has received
+	acked uint64
+}
+
+// push adds a frame that is being sent.
+func (b *replayBuffer) push(data []byte) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.frames = append(b.frames, data)
+}
+
+// unacked returns the frames which the remote end has not acknowledged.
+func (b *replayBuffer) unacked() [][]byte {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return append([][]byte(nil), b.frames...)
+}
+
+// ack drops the frames which the remote end has received, given the total
+// number of frames it has received.  It returns false if that is not
+// consistent with the frames that were sent.
+func (b *replayBuffer) ack(received uint64) bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if received < b.acked || received > b.acked+uint64(len(b.frames)) {
+		return false
+	}
+	n := received - b.acked
+	for i := uint64(0); i < n; i++ {
+		b.frames[i] = nil
+	}
+	b.frames = b.frames[n:]
+	b.acked = received
+	return true
+}
+
+// Resume continues a resumable session over a new websocket connection, for
+// example after its previous connection was lost.  The remote end must resume
+// its session over the same connection.  The ends then exchange the number of
+// frames each has received, and send again any frames that were lost, so that
+// streams continue as if the connection had not been interrupted.
+//
+// The previous connection is closed, if it has not already been found to be
+// broken.  This function takes ownership of `conn`.
+func (s *Session) Resume(conn *websocket.Conn) error {
+	if !s.resumable {
+		_ = conn.Close()
+		return ErrNotResumable
+	}
+
+	s.mu.Lock()
+	old, recvDone := s.conn, s.recvDone
+	s.mu.Unlock()
+
+	// wait until nothing more is received from the previous connection, so
+	// that the number of frames received is final
+	s.lostConnection(old)
+	<-recvDone
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.IsClosed() {
+		_ = conn.Close()
+		return ErrSessionClosed
+	}
+	s.logger.Printf("resuming session")
+
+	s.sendQueue.acquire(priorityControl)
+	if s.resumeTimer != nil {
+		s.resumeTimer.Stop()
+	}
+	s.conn = conn
+	s.connState = connResuming
+	err := conn.WriteMessage(websocket.BinaryMessage, newRcvFrame(atomic.LoadUint64(&s.received)).serialize())
+	s.sendQueue.release()
+	if err != nil {
+		// the receiving goroutine will find the connection failed
+		_ = conn.Close()
+	}
+
+	s.pongSeen = true
+	s.recvDone = make(chan struct{})
+	conn.SetCloseHandler(s.closeHandler)
+	conn.SetPongHandler(s.pongHandler)
+	go s.recvLoop(conn, s.recvDone, true)
+	return nil
+}
+
+// connectionFailed handles an error on the given connection.  Sessions which
+// are not resumable are aborted, while resumable sessions wait to be resumed.
+func (s *Session) connectionFailed(conn *websocket.Conn, err error) {
+	if !s.resumable {
+		s.abort(err)
+		return
+	}
+	if s.IsClosed() {
+		return
+	}
+	if s.lostConnection(conn) {
+		s.logger.Printf("session lost connection: %v", err)
+		if s.disconnectCallback != nil {
+			s.disconnectCallback()
+		}
+	}
+}
+
+// lostConnection closes the given connection of a resumable session and, if it
+// is the current connection, waits for the session to be resumed.  It returns
+// true if the loss of the connection was not already known.
+func (s *Session) lostConnection(conn *websocket.Conn) bool {
+	// closing the connection interrupts any write in progress
+	_ = conn.Close()
+
+	s.sendQueue.acquire(priorityControl)
+	defer s.sendQueue.release()
+	if s.conn != conn || s.connState == connLost {
+		return false
+	}
+	s.connState = connLost
+	s.resumeTimer = time.AfterFunc(s.resumeTimeout, func() {
+		s.sendQueue.acquire(priorityControl)
+		expired := s.conn == conn && s.connState == connLost
+		s.sendQueue.release()
+		if expired {
+			s.abort(ErrResumeTimeout)
+		}
+	})
+	return true
+}
+
+// handleReceipt handles a msgRCV frame received on the given connection.  The
+// first such frame on a resumed connection completes the resumption, by
+// sending the frames that the remote end has not received.
+func (s *Session) handleReceipt(conn *websocket.Conn, fr frame, resuming bool) {
+	if len(fr.payload) < 8 {
+		s.logger.Printf("malformed RCV frame")
+		return
+	}
+	if !s.replay.ack(binary.LittleEndian.Uint64(fr.payload)) {
+		s.abort(ErrResumeFailed)
+		return
+	}
+	if resuming {
+		// sending may block until the remote end receives, which it may not
+		// do until this end receives, so this is done separately
+		go s.sendAgain(conn)
+	}
+}
+
+// sendAgain sends the frames which the remote end has not acknowledged over
+// a resumed connection, after which frames are sent as they are written.
+func (s *Session) sendAgain(conn *websocket.Conn) {
+	s.sendQueue.acquire(priorityControl)
+	if s.conn != conn || s.connState != connResuming {
+		s.sendQueue.release()
+		return
+	}
+	frames := s.replay.unacked()
+	var err error
+	for _, data := range frames {
+		if err = conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		s.logger.Printf("session resumed; %d frames sent again", len(frames))
+		s.connState = connConnected
+	}
+	s.sendQueue.release()
+	if err != nil {
+		s.connectionFailed(conn, err)
+	}
+}
+
+// requestReceipt arranges for a msgRCV frame to be sent, acknowledging the
+// frames received so far.
+func (s *Session) requestReceipt() {
+	select {
+	case s.receiptCh <- struct{}{}:
+	default:
+	}
+}
+
+// sendReceipts sends msgRCV frames when requested, until the session closes.
+// This is not done by the receiving goroutine, as it must not block on
+// sending.
+func (s *Session) sendReceipts() {
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-s.receiptCh:
+		}
+
+		s.sendQueue.acquire(priorityControl)
+		conn := s.conn
+		var err error
+		if s.connState == connConnected {
+			err = conn.WriteMessage(websocket.BinaryMessage, newRcvFrame(atomic.LoadUint64(&s.received)).serialize())
+		}
+		s.sendQueue.release()
+		if err != nil {
+			s.connectionFailed(conn, err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package wsmux
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/taskcluster/taskcluster/v30/tools/websocktunnel/util"
+)
+
+// connPairs creates pairs of connected websockets
+type connPairs struct {
+	server *httptest.Server
+	conns  chan *websocket.Conn
+}
+
+func newConnPairs(t *testing.T) *connPairs {
+	p := &connPairs{conns: make(chan *websocket.Conn, 1)}
+	p.server = httptest.NewServer(genWebSocketHandler(t, func(t *testing.T, conn *websocket.Conn) {
+		p.conns <- conn
+	}))
+	return p
+}
+
+// connect returns the server and client ends of a new websocket connection
+func (p *connPairs) connect(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	conn, _, err := websocket.DefaultDialer.Dial(util.MakeWsURL(p.server.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return <-p.conns, conn
+}
+
+// resumablePair returns the server and client ends of a resumable session,
+// and a channel which receives a value whenever the client loses its
+// connection
+func resumablePair(t *testing.T, p *connPairs, conf Config) (*Session, *Session, chan struct{}) {
+	disconnected := make(chan struct{}, 1)
+	conf.Resumable = true
+	serverConn, clientConn := p.connect(t)
+	server := Server(serverConn, conf)
+	conf.DisconnectCallback = func() {
+		disconnected <- struct{}{}
+	}
+	client := Client(clientConn, conf)
+	return server, client, disconnected
+}
+
+// breakAndResume drops the client's connection, as a network failure would,
+// and resumes both ends over a new connection
+func breakAndResume(t *testing.T, p *connPairs, server, client *Session, disconnected chan struct{}) {
+	client.mu.Lock()
+	_ = client.conn.UnderlyingConn().Close()
+	client.mu.Unlock()
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not notice the connection was lost")
+	}
+
+	serverConn, clientConn := p.connect(t)
+	if err := server.Resume(serverConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Resume(clientConn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func echoStreams(session *Session) {
+	for {
+		str, err := session.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, _ = io.Copy(str, str)
+			_ = str.Close()
+		}()
+	}
+}
+
+// Test that a stream continues across a resumed session, including data
+// written while disconnected
+func TestResumeStream(t *testing.T) {
+	p := newConnPairs(t)
+	defer p.server.Close()
+	server, client, disconnected := resumablePair(t, p, Config{Log: genLogger()})
+	defer server.Close()
+	defer client.Close()
+	go echoStreams(server)
+
+	str, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 6)
+	if _, err := str.Write([]byte("before")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(str, buf); err != nil || string(buf) != "before" {
+		t.Fatalf("unexpected echo %q, %v", buf, err)
+	}
+
+	client.mu.Lock()
+	_ = client.conn.UnderlyingConn().Close()
+	client.mu.Unlock()
+	<-disconnected
+	if _, err := str.Write([]byte("during")); err != nil {
+		t.Fatal(err)
+	}
+
+	serverConn, clientConn := p.connect(t)
+	if err := server.Resume(serverConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Resume(clientConn); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.ReadFull(str, buf); err != nil || string(buf) != "during" {
+		t.Fatalf("unexpected echo %q, %v", buf, err)
+	}
+	if _, err := str.Write([]byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	if err := str.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ioutil.ReadAll(str)
+	if err != nil || string(rest) != "after" {
+		t.Fatalf("unexpected echo %q, %v", rest, err)
+	}
+}
+
+// Test that a large transfer is not corrupted by repeatedly resuming the
+// session
+func TestResumeTransfer(t *testing.T) {
+	p := newConnPairs(t)
+	defer p.server.Close()
+	server, client, disconnected := resumablePair(t, p, Config{})
+	defer server.Close()
+	defer client.Close()
+	go echoStreams(server)
+
+	str, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 4*1024*1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	go func() {
+		_, _ = str.Write(data)
+		_ = str.Close()
+	}()
+
+	// the reader pauses after each chunk while the connection is broken, so
+	// that frames are in flight at the time
+	const chunk = 256 * 1024
+	paused, resumed := make(chan struct{}), make(chan struct{})
+	received := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, len(data))
+		for i := 0; i < len(data); i += chunk {
+			if _, err := io.ReadFull(str, buf[i:i+chunk]); err != nil {
+				break
+			}
+			if i < 5*chunk {
+				paused <- struct{}{}
+				<-resumed
+			}
+		}
+		received <- buf
+	}()
+
+	for i := 0; i < 5; i++ {
+		<-paused
+		breakAndResume(t, p, server, client, disconnected)
+		resumed <- struct{}{}
+	}
+
+	select {
+	case buf := <-received:
+		if !bytes.Equal(buf, data) {
+			t.Fatalf("received %d bytes, which differ from the %d bytes sent", len(buf), len(data))
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("transfer did not complete")
+	}
+}
+
+// Test that a session closes if it is not resumed in time
+func TestResumeTimeout(t *testing.T) {
+	p := newConnPairs(t)
+	defer p.server.Close()
+	server, client, disconnected := resumablePair(t, p, Config{ResumeTimeout: 100 * time.Millisecond})
+	defer server.Close()
+
+	client.mu.Lock()
+	_ = client.conn.UnderlyingConn().Close()
+	client.mu.Unlock()
+	<-disconnected
+
+	if _, err := client.Accept(); err != ErrSessionClosed {
+		t.Fatalf("expected ErrSessionClosed, got %v", err)
+	}
+	serverConn, clientConn := p.connect(t)
+	_ = serverConn.Close()
+	if err := client.Resume(clientConn); err != ErrSessionClosed {
+		t.Fatalf("expected ErrSessionClosed, got %v", err)
+	}
+}
+
+// Test that closing a resumable session closes the remote end, rather than
+// leaving it waiting to be resumed
+func TestResumableSessionClose(t *testing.T) {
+	p := newConnPairs(t)
+	defer p.server.Close()
+	server, client, _ := resumablePair(t, p, Config{})
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := server.Accept()
+		accepted <- err
+	}()
+	_ = client.Close()
+
+	select {
+	case err := <-accepted:
+		if err != ErrSessionClosed {
+			t.Fatalf("expected ErrSessionClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server session was not closed")
+	}
+}
+
+// Test that sessions which are not resumable cannot be resumed
+func TestResumeNotResumable(t *testing.T) {
+	p := newConnPairs(t)
+	defer p.server.Close()
+	serverConn, clientConn := p.connect(t)
+	defer serverConn.Close()
+	session := Client(clientConn, Config{})
+	defer session.Close()
+
+	serverConn2, clientConn2 := p.connect(t)
+	defer serverConn2.Close()
+	if err := session.Resume(clientConn2); err != ErrNotResumable {
+		t.Fatalf("expected ErrNotResumable, got %v", err)
+	}
+}
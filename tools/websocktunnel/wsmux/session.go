@@ -3,6 +3,7 @@ package wsmux
 import (
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -10,10 +11,12 @@ import (
 )
 
 const (
-	defaultStreamQueueSize      = 200              // size of the accept stream
-	defaultKeepAliveInterval    = 20 * time.Second // keep alive interval
-	defaultStreamAcceptDeadline = 30 * time.Second // If stream is not accepted within this deadline then timeout
-	deadCheckDuration           = 2 * time.Second  // check for dead streams every 2 seconds
+	defaultStreamQueueSize      = 200                    // size of the accept stream
+	defaultKeepAliveInterval    = 20 * time.Second       // keep alive interval
+	defaultStreamAcceptDeadline = 30 * time.Second       // If stream is not accepted within this deadline then timeout
+	deadCheckDuration           = 2 * time.Second        // check for dead streams every 2 seconds
+	defaultRTT                  = 100 * time.Millisecond // round trip time assumed until one is measured
+	defaultResumeTimeout        = 30 * time.Second       // time for which a resumable session waits to be resumed
 )
 
 // Session allows creating and accepting wsmux streams over a websocket connection.
@@ -33,14 +36,18 @@ type Session struct {
 	// this channel.
 	streamCh chan *stream
 
-	// the underlying websocket connection
+	// the underlying websocket connection.  For resumable sessions, this is
+	// replaced while holding both mu and sendQueue.
 	conn *websocket.Conn
 
+	// closed when the goroutine receiving frames from conn exits
+	recvDone chan struct{}
+
 	// error to be returned by any outstanding Accept calls
 	acceptErr error
 
-	// lock for sending data on the connection
-	sendLock sync.Mutex
+	// schedules sending data on the connection, in order of priority
+	sendQueue sendScheduler
 
 	// Open calls must complete in this duration
 	streamAcceptDeadline time.Duration
@@ -61,15 +68,52 @@ type Session struct {
 	// Callback when remote session is closed. default: nil
 	closeCallback func()
 
-	// Buffer size of each stream.  This is used to apply backpressure
+	// Initial buffer size of each stream.  This is used to apply backpressure
 	// to the remote end, avoiding buffering too much data.
 	streamBufferSize int
 
+	// Size to which stream buffers may grow
+	maxStreamBufferSize int
+
 	// Keep alives are sent at this period
 	keepAliveInterval time.Duration
 
 	// Set by the pong handler
 	pongSeen bool
+
+	// time the last keepalive ping was sent
+	pingSent time.Time
+
+	// minimum round trip time, in nanoseconds, measured by keepalives.  The
+	// minimum excludes time spent queued behind other data, so that queueing
+	// does not cause stream buffers to grow and lengthen the queue further.
+	// This is accessed atomically, as streams read it while holding their
+	// own locks.
+	rtt int64
+
+	// The following fields are only used by resumable sessions
+
+	resumable          bool
+	resumeTimeout      time.Duration
+	disconnectCallback func()
+
+	// state of conn, modified while holding sendQueue
+	connState connState
+
+	// frames which the remote end has not acknowledged receiving, to be sent
+	// again when the session is resumed
+	replay replayBuffer
+
+	// closes the session if it is not resumed in time; accessed while
+	// holding sendQueue
+	resumeTimer *time.Timer
+
+	// number of frames received, not counting msgRCV frames.  This is
+	// accessed atomically.
+	received uint64
+
+	// signals that a msgRCV frame should be sent
+	receiptCh chan struct{}
 }
 
 // newSession creates a new session based on the given configuration, applying
@@ -86,7 +130,13 @@ func newSession(conn *websocket.Conn, server bool, conf Config) *Session {
 		streamAcceptDeadline: defaultStreamAcceptDeadline,
 		logger:               &util.NilLogger{},
 		streamBufferSize:     DefaultCapacity,
+		maxStreamBufferSize:  DefaultMaxCapacity,
 		closeCallback:        conf.CloseCallback,
+		recvDone:             make(chan struct{}),
+		resumable:            conf.Resumable,
+		resumeTimeout:        defaultResumeTimeout,
+		disconnectCallback:   conf.DisconnectCallback,
+		connState:            connConnected,
 	}
 
 	// streams opened by server are even numbered
@@ -108,13 +158,26 @@ func newSession(conn *websocket.Conn, server bool, conf Config) *Session {
 	if conf.StreamBufferSize != 0 {
 		s.streamBufferSize = conf.StreamBufferSize
 	}
+	if conf.MaxStreamBufferSize != 0 {
+		s.maxStreamBufferSize = conf.MaxStreamBufferSize
+	}
+	if s.maxStreamBufferSize < s.streamBufferSize {
+		s.maxStreamBufferSize = s.streamBufferSize
+	}
+	if conf.ResumeTimeout != 0 {
+		s.resumeTimeout = conf.ResumeTimeout
+	}
 
 	s.conn.SetCloseHandler(s.closeHandler)
 	s.conn.SetPongHandler(s.pongHandler)
 
-	go s.recvLoop()
+	go s.recvLoop(s.conn, s.recvDone, false)
 	go s.removeDeadStreams()
 	go s.sendKeepAlives()
+	if s.resumable {
+		s.receiptCh = make(chan struct{}, 1)
+		go s.sendReceipts()
+	}
 	return s
 }
 
@@ -130,11 +193,12 @@ func (s *Session) Accept() (net.Conn, error) {
 			return nil, ErrSessionClosed
 		}
 
-		// "accept" the stream locally, putting it into a state where it can read and write
-		str.acceptStream(uint32(s.streamBufferSize))
+		// "accept" the stream locally, putting it into a state where it can read and write,
+		// with as much data as the remote end can buffer
+		str.acceptStream(str.remoteWindow, uint32(s.streamBufferSize))
 
 		// and inform the other side that this stream has been accepted
-		if err := s.send(newAckFrame(str.id, uint32(s.streamBufferSize))); err != nil {
+		if err := s.send(newAcceptFrame(str.id, uint32(s.streamBufferSize))); err != nil {
 			s.abort(err)
 			return nil, err
 		}
@@ -149,7 +213,15 @@ func (s *Session) Accept() (net.Conn, error) {
 // Opening a connection creates a fresh new stream ID and sends a msgSYN
 // frame containing that ID to the remote side.  The stream is considered
 // accepted when a msgACK frame arrives with the same stream ID.
+//
+// The stream has PriorityNormal.
 func (s *Session) Open() (net.Conn, error) {
+	return s.OpenWithPriority(PriorityNormal)
+}
+
+// OpenWithPriority opens a new stream as for Open, with the given priority
+// for both ends of the stream.
+func (s *Session) OpenWithPriority(priority Priority) (net.Conn, error) {
 	select {
 	case <-s.closed:
 		return nil, ErrSessionClosed
@@ -172,10 +244,10 @@ func (s *Session) Open() (net.Conn, error) {
 	id := s.nextID
 	s.nextID += 2
 
-	str := newStream(id, s)
+	str := newStream(id, s, priority)
 	s.streams[id] = str
 
-	if err := s.send(newSynFrame(id)); err != nil {
+	if err := s.send(newSynFrame(id, priority, uint32(s.streamBufferSize))); err != nil {
 		return nil, err
 	}
 
@@ -217,6 +289,12 @@ func (s *Session) Close() error {
 	// Check if channel has been closed
 	var err error
 	if s.closeConn {
+		if s.resumable {
+			// tell the remote end not to wait for the session to be resumed
+			_ = s.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, "session closed"),
+				time.Now().Add(time.Second))
+		}
 		err = s.conn.Close()
 	}
 
@@ -241,9 +319,18 @@ func (s *Session) Close() error {
 // Addr returns the address of this listener.  This is required for
 // implementing net.Listener, but its return value here is not very useful.
 func (s *Session) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.conn.LocalAddr()
 }
 
+// remoteAddr returns the remote address of the underlying connection.
+func (s *Session) remoteAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.RemoteAddr()
+}
+
 // IsClosed returns true if the session is closed.
 func (s *Session) IsClosed() bool {
 	select {
@@ -254,30 +341,54 @@ func (s *Session) IsClosed() bool {
 	return false
 }
 
-// pongHandler indicates that a pong message has been seen
+// pongHandler indicates that a pong message has been seen, and measures the
+// round trip time
 func (s *Session) pongHandler(data string) error {
 	s.mu.Lock()
 	s.pongSeen = true
+	if !s.pingSent.IsZero() {
+		if rtt := int64(time.Since(s.pingSent)); s.rtt == 0 || rtt < s.rtt {
+			atomic.StoreInt64(&s.rtt, rtt)
+		}
+	}
 	s.mu.Unlock()
 	return nil
 }
 
+// roundTripTime returns the minimum measured round trip time of the connection
+func (s *Session) roundTripTime() time.Duration {
+	if rtt := atomic.LoadInt64(&s.rtt); rtt > 0 {
+		return time.Duration(rtt)
+	}
+	return defaultRTT
+}
+
 // sendKeepAlives sends a ping message every keepAliveInterval, until the
 // connection closes.  If there is an error sending the ping, or no pong is
-// received during the interval, the connection is aborted.
+// received during the interval, the connection is considered failed.  No
+// pings are sent while a resumable session waits to be resumed.
 func (s *Session) sendKeepAlives() {
 	ticker := time.NewTicker(s.keepAliveInterval)
 	for {
-		s.sendLock.Lock()
-		err := s.conn.WriteControl(
-			websocket.PingMessage, nil,
-			// use a deadline of half the keepAliveInterval, to ensure the message
-			// is sent in a reasonable amount of time
-			time.Now().Add(s.keepAliveInterval/2))
-		s.sendLock.Unlock()
+		s.mu.Lock()
+		s.pingSent = time.Now()
+		s.mu.Unlock()
+		s.sendQueue.acquire(priorityControl)
+		conn, lost := s.conn, s.connState == connLost
+		var err error
+		if !lost {
+			err = conn.WriteControl(
+				websocket.PingMessage, nil,
+				// use a deadline of half the keepAliveInterval, to ensure the message
+				// is sent in a reasonable amount of time
+				time.Now().Add(s.keepAliveInterval/2))
+		}
+		s.sendQueue.release()
 		if err != nil {
-			s.abort(err)
-			return
+			s.connectionFailed(conn, err)
+			if !s.resumable {
+				return
+			}
 		}
 
 		select {
@@ -292,29 +403,61 @@ func (s *Session) sendKeepAlives() {
 		pongSeen := s.pongSeen
 		s.pongSeen = false
 		s.mu.Unlock()
-		if !pongSeen {
-			s.logger.Printf("No pong message seen; aborting session")
-			s.abort(ErrKeepAliveExpired)
+		if !pongSeen && !lost {
+			s.logger.Printf("No pong message seen; connection failed")
+			s.connectionFailed(conn, ErrKeepAliveExpired)
+		}
+
+		// acknowledge any frames received since the last msgRCV
+		if s.resumable {
+			s.requestReceipt()
 		}
 	}
 }
 
-// send transmits a frame over the websocket connection.
+// send transmits a control frame over the websocket connection.
 func (s *Session) send(f frame) error {
+	return s.sendWithPriority(f, priorityControl)
+}
+
+// sendWithPriority transmits a frame over the websocket connection, after any
+// waiting frames of higher priority.
+func (s *Session) sendWithPriority(f frame, priority Priority) error {
 	select {
 	case <-s.closed:
 		return ErrSessionClosed
 	default:
 	}
-	s.sendLock.Lock()
-	defer s.sendLock.Unlock()
-	err := s.conn.WriteMessage(websocket.BinaryMessage, f.serialize())
-	return err
+	s.sendQueue.acquire(priority)
+	if !s.resumable {
+		defer s.sendQueue.release()
+		return s.conn.WriteMessage(websocket.BinaryMessage, f.serialize())
+	}
+
+	// frames of resumable sessions are kept until the remote end has
+	// received them, and are only written while connected
+	data := f.serialize()
+	s.replay.push(data)
+	conn := s.conn
+	var err error
+	if s.connState == connConnected {
+		err = conn.WriteMessage(websocket.BinaryMessage, data)
+	}
+	s.sendQueue.release()
+	if err != nil {
+		// the frame will be sent again when the session is resumed
+		s.connectionFailed(conn, err)
+	}
+	return nil
 }
 
 // called when websocket connection is closed
 func (s *Session) closeHandler(code int, text string) error {
 	s.logger.Printf("wsmux connection closed: code %d : %s", code, text)
+	if s.resumable && code != websocket.CloseNormalClosure {
+		// the connection was lost, rather than the session being closed
+		return nil
+	}
 	s.mu.Lock()
 	// indicate that `s.Close()` need not close the websocket connection,
 	// as it is already closed.
@@ -324,8 +467,11 @@ func (s *Session) closeHandler(code int, text string) error {
 }
 
 // recvLoop sits in a groutine and receives frames over the websocket
-// connection, calling various `handle` methods as appropriate.
-func (s *Session) recvLoop() {
+// connection, calling various `handle` methods as appropriate.  It closes done
+// when it exits.  If resuming, the first frame received completes the
+// resumption of the session.
+func (s *Session) recvLoop(conn *websocket.Conn, done chan struct{}, resuming bool) {
+	defer close(done)
 	for {
 		select {
 		case <-s.closed:
@@ -333,10 +479,10 @@ func (s *Session) recvLoop() {
 		default:
 		}
 
-		t, msg, err := s.conn.ReadMessage()
+		t, msg, err := conn.ReadMessage()
 		if err != nil {
 			s.logger.Printf("error while reading from WS: %v", err)
-			s.abort(err)
+			s.connectionFailed(conn, err)
 			break
 		}
 		if t != websocket.BinaryMessage {
@@ -350,8 +496,19 @@ func (s *Session) recvLoop() {
 			continue
 		}
 
+		if fr.msg == msgRCV {
+			if s.resumable {
+				s.handleReceipt(conn, *fr, resuming)
+			}
+			resuming = false
+			continue
+		}
+		if s.resumable && atomic.AddUint64(&s.received, 1)%receiptInterval == 0 {
+			s.requestReceipt()
+		}
+
 		if fr.msg == msgSYN {
-			go s.handleSyn(fr.id)
+			go s.handleSyn(*fr)
 		} else {
 			s.mu.Lock()
 			str := s.streams[fr.id]
@@ -367,7 +524,8 @@ func (s *Session) recvLoop() {
 // handleSyn creates a new stream and adds it to s.streamCh so that it can be returned
 // from Accept.  As part of the two-way stream setup handshake, it responds with a
 // msgACK frame indicating that the request has been received.
-func (s *Session) handleSyn(id uint32) {
+func (s *Session) handleSyn(fr frame) {
+	id := fr.id
 	s.mu.Lock()
 
 	// check if stream exists
@@ -378,7 +536,9 @@ func (s *Session) handleSyn(id uint32) {
 		return
 	}
 
-	str := newStream(id, s)
+	priority, remoteWindow := fr.synParams()
+	str := newStream(id, s, priority)
+	str.remoteWindow = remoteWindow
 	s.streams[id] = str
 
 	defer s.mu.Unlock()
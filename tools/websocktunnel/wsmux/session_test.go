@@ -2,8 +2,11 @@ package wsmux
 
 import (
 	"bytes"
+	"encoding/binary"
 	"io"
+	"net"
 	"testing"
+	"time"
 
 	"net/http/httptest"
 
@@ -80,3 +83,139 @@ func TestEchoLarge(t *testing.T) {
 		t.Fatal("message not consistent")
 	}
 }
+
+// legacyPeer returns a client session, and the websocket connection at the
+// remote end of it, over which a test can speak the protocol as an earlier
+// version would.
+func legacyPeer(t *testing.T) (*Session, *websocket.Conn, func()) {
+	connCh := make(chan *websocket.Conn)
+	done := make(chan struct{})
+	server := httptest.NewServer(genWebSocketHandler(t, func(t *testing.T, conn *websocket.Conn) {
+		connCh <- conn
+		<-done
+	}))
+	conn, _, err := websocket.DefaultDialer.Dial(util.MakeWsURL(server.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := Client(conn, Config{Log: genLogger()})
+	peer := <-connCh
+	// fail rather than hang if an expected frame is never sent
+	_ = peer.SetReadDeadline(time.Now().Add(10 * time.Second))
+	return session, peer, func() {
+		_ = session.Close()
+		_ = peer.Close()
+		close(done)
+		server.Close()
+	}
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn) frame {
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr, err := deserializeFrame(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *fr
+}
+
+func writeFrame(t *testing.T, conn *websocket.Conn, fr frame) {
+	if err := conn.WriteMessage(websocket.BinaryMessage, fr.serialize()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLegacySyn(t *testing.T) {
+	session, peer, cleanup := legacyPeer(t)
+	defer cleanup()
+
+	// earlier versions send a msgSYN without a payload
+	writeFrame(t, peer, frame{id: 2, msg: msgSYN})
+	str, err := session.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priority := str.(PriorityConn).Priority(); priority != PriorityNormal {
+		t.Fatalf("expected PriorityNormal, got %d", priority)
+	}
+	ack := readFrame(t, peer)
+	if ack.msg != msgACK || binary.LittleEndian.Uint32(ack.payload) != DefaultCapacity {
+		t.Fatalf("expected msgACK with the read buffer capacity, got %s", ack)
+	}
+
+	// data is sent only within the window of the remote end, assumed to be
+	// legacyStreamWindow
+	data := make([]byte, 4*legacyStreamWindow)
+	go func() {
+		_, _ = str.Write(data)
+	}()
+	received, allowed := 0, legacyStreamWindow
+	for received < len(data) {
+		fr := readFrame(t, peer)
+		if fr.msg != msgDAT {
+			t.Fatalf("expected msgDAT, got %s", fr)
+		}
+		received += len(fr.payload)
+		if received > allowed {
+			t.Fatalf("received %d bytes with a window of %d", received, allowed)
+		}
+		if received == allowed {
+			writeFrame(t, peer, newAckFrame(2, legacyStreamWindow))
+			allowed += legacyStreamWindow
+		}
+	}
+}
+
+func TestLegacyAccept(t *testing.T) {
+	session, peer, cleanup := legacyPeer(t)
+	defer cleanup()
+
+	opened := make(chan net.Conn, 1)
+	go func() {
+		str, err := session.Open()
+		if err != nil {
+			close(opened)
+			return
+		}
+		opened <- str
+	}()
+	syn := readFrame(t, peer)
+	if syn.msg != msgSYN {
+		t.Fatalf("expected msgSYN, got %s", syn)
+	}
+
+	// earlier versions ignore the msgSYN payload, and assume the opening
+	// end's read buffer is the same size as their own
+	writeFrame(t, peer, newAckFrame(syn.id, legacyStreamWindow))
+	str, ok := <-opened
+	if !ok {
+		t.Fatal("could not open stream")
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 2*legacyStreamWindow)
+		_, err := io.ReadFull(str, buf)
+		readErr <- err
+	}()
+
+	// the remote end sends a full window, and must receive acknowledgements
+	// for it before sending more
+	for i := 0; i < 2; i++ {
+		writeFrame(t, peer, newDataFrame(syn.id, make([]byte, legacyStreamWindow)))
+		acked := 0
+		for acked < legacyStreamWindow {
+			fr := readFrame(t, peer)
+			if fr.msg != msgACK {
+				t.Fatalf("expected msgACK, got %s", fr)
+			}
+			acked += int(binary.LittleEndian.Uint32(fr.payload))
+		}
+	}
+	if err := <-readErr; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -12,7 +12,19 @@ import (
 
 const (
 	// DefaultCapacity of read buffer.
-	DefaultCapacity = 1024
+	DefaultCapacity = 16 * 1024
+
+	// DefaultMaxCapacity is the size to which read buffers may grow.
+	DefaultMaxCapacity = 1024 * 1024
+
+	// legacyStreamWindow is assumed to be the read buffer capacity of the
+	// remote end if it does not give one when opening a stream, as was
+	// the case in earlier versions.
+	legacyStreamWindow = 1024
+
+	// maxDataFrameSize limits the size of msgDAT frames, so that frames of
+	// other streams can be interleaved with large writes
+	maxDataFrameSize = 16 * 1024
 )
 
 type streamState int
@@ -59,6 +71,36 @@ type stream struct {
 	// cannot buffer data as quickly as we send it.
 	unblocked uint32
 
+	// number of bytes read but not yet acknowledged to the remote end.
+	// Acknowledgements are batched, and sent once a quarter of the read
+	// buffer has been read.
+	unacked uint32
+
+	// the start of the current window-tuning epoch, and the number of bytes
+	// read since then.  If a full read buffer is read within two round trips,
+	// the buffer is too small for the stream's throughput, and is grown.
+	epochStart time.Time
+	epochRead  int
+
+	// read buffer capacity of the remote end, used as the initial value of
+	// unblocked for remotely initiated streams
+	remoteWindow uint32
+
+	// read buffer capacity that the remote end assumes this end has, and thus
+	// how much it may send before waiting for a msgACK
+	localWindow uint32
+
+	// priority with which data frames are sent
+	priority Priority
+
+	// held for the duration of a Write call, so that concurrent writes are
+	// not interleaved
+	writeLock sync.Mutex
+
+	// true while a data frame is being sent without holding m, so that Close
+	// does not send msgFIN ahead of it
+	sending bool
+
 	// error causes stream to close
 	endErr error
 
@@ -82,7 +124,7 @@ type stream struct {
 
 // newStream creates a new stream with the given id.  No frames are sent.  This
 // is used both for locally initiated streams and remotely initiated streams.
-func newStream(id uint32, session *Session) *stream {
+func newStream(id uint32, session *Session, priority Priority) *stream {
 	if session == nil {
 		panic("session must not be nil")
 	}
@@ -93,6 +135,10 @@ func newStream(id uint32, session *Session) *stream {
 		state:     streamCreated,
 		accepted:  make(chan struct{}),
 
+		epochStart:   time.Now(),
+		remoteWindow: legacyStreamWindow,
+		priority:     priority,
+
 		endErr: nil,
 
 		readTimer:             nil,
@@ -118,8 +164,14 @@ func (s *stream) handleFrame(fr frame) {
 			// stream is already accepted, so broadcast the increased capacity
 			s.unblockAndBroadcast(cap)
 		default:
-			// stream is not yet accepted, so mark it accepted
-			s.acceptStream(cap)
+			// stream is not yet accepted, so mark it accepted.  A remote end
+			// that ignored the msgSYN payload assumes this end's read buffer
+			// is the same size as its own.
+			window := uint32(s.session.streamBufferSize)
+			if !fr.synParamsUnderstood() {
+				window = cap
+			}
+			s.acceptStream(cap, window)
 		}
 
 	case msgDAT:
@@ -130,6 +182,24 @@ func (s *stream) handleFrame(fr frame) {
 	}
 }
 
+// Priority returns the priority with which this end sends data.
+//
+// This is part of the PriorityConn interface.
+func (s *stream) Priority() Priority {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.priority
+}
+
+// SetPriority sets the priority with which this end sends data.
+//
+// This is part of the PriorityConn interface.
+func (s *stream) SetPriority(priority Priority) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.priority = priority
+}
+
 // onExpired is an internal helper method which sets val = true and broadcasts
 func (s *stream) onExpired(val *bool) func() {
 	return func() {
@@ -221,14 +291,17 @@ func (s *stream) pushAndBroadcast(buf []byte) {
 }
 
 // acceptStream accepts the current stream, moving it to the streamAccepted
-// state.  For remotely-initiated streams, this is called directly from
-// Session.Open; for locally-initiated streams, it is called when the msgACK
-// frame for the new stream is received.
-func (s *stream) acceptStream(read uint32) {
+// state, with read bytes unblocked for sending and window as the read buffer
+// capacity that the remote end assumes this end has.  For remotely-initiated
+// streams, this is called directly from Session.Open; for locally-initiated
+// streams, it is called when the msgACK frame for the new stream is received.
+func (s *stream) acceptStream(read uint32, window uint32) {
 	s.m.Lock()
 	defer s.m.Unlock()
 	defer s.c.Broadcast()
 	s.unblocked += read
+	s.localWindow = window
+	s.b.grow(int(window))
 	s.state = streamAccepted
 	close(s.accepted)
 
@@ -262,7 +335,7 @@ func (s *stream) setRemoteClosed() {
 // This is part of the net.Conn interface.  Its value in this context is not
 // particularly useful.
 func (s *stream) LocalAddr() net.Addr {
-	return s.session.conn.LocalAddr()
+	return s.session.Addr()
 }
 
 // RemoteAddr returns the remote address of the underlying connection
@@ -270,7 +343,7 @@ func (s *stream) LocalAddr() net.Addr {
 // This is part of the net.Conn interface.  Its value in this context is not
 // particularly useful.
 func (s *stream) RemoteAddr() net.Addr {
-	return s.session.conn.RemoteAddr()
+	return s.session.remoteAddr()
 }
 
 // Close closes the stream, sending a msgFin frame unless one has already been
@@ -281,6 +354,11 @@ func (s *stream) Close() error {
 	defer s.m.Unlock()
 	defer s.c.Broadcast()
 
+	// wait for any data frame being sent, so that msgFIN follows it
+	for s.sending {
+		s.c.Wait()
+	}
+
 	switch s.state {
 	// return nil if already streamClosed
 	case streamDead:
@@ -326,21 +404,54 @@ func (s *stream) Read(buf []byte) (int, error) {
 	}
 
 	n, _ := s.b.Read(buf)
+	s.unacked += uint32(n)
+	grown := s.tuneWindow(n)
 
 	// send a msgACK to indicate we received n bytes.  Note that this is not sent when we receive the
 	// msgDAT frame, but when we are about to return it to the caller; this conveys information about how
 	// quickly this process is actually consuming the data, rather than just how quickly the local TCP
-	// stack can receive it.
-	if err := s.session.send(newAckFrame(s.id, uint32(n))); err != nil {
-		return n, err
+	// stack can receive it.  It is only sent once a quarter of the remote end's window has been
+	// read, as the remote end cannot be blocked before then.
+	s.localWindow += uint32(grown)
+	if grown > 0 || s.unacked >= s.localWindow/4 {
+		if err := s.session.send(newAckFrame(s.id, s.unacked+uint32(grown))); err != nil {
+			return n, err
+		}
+		s.unacked = 0
 	}
 
 	return n, nil
 }
 
+// tuneWindow accounts for n bytes having been read, and grows the read buffer
+// if the remote end is sending faster than it allows, returning the number of
+// bytes by which it grew.  This is similar to the receive window auto-tuning
+// of HTTP/2 and QUIC implementations: a buffer that is read in full within
+// two round trips limits throughput, as the remote end spends time waiting
+// for acknowledgements.
+func (s *stream) tuneWindow(n int) int {
+	s.epochRead += n
+	if s.epochRead < s.b.cap {
+		return 0
+	}
+	now := time.Now()
+	grown := 0
+	if s.b.cap < s.session.maxStreamBufferSize && now.Sub(s.epochStart) < 2*s.session.roundTripTime() {
+		old := s.b.cap
+		s.b.grow(util.Min(2*old, s.session.maxStreamBufferSize))
+		grown = s.b.cap - old
+		s.session.logger.Printf("stream %d: read buffer grown to %d bytes", s.id, s.b.cap)
+	}
+	s.epochStart = now
+	s.epochRead = 0
+	return grown
+}
+
 // Write writes bytes to the stream.  This will block until the bytes have been
 // written, but not until they have been acknowledged.
 func (s *stream) Write(buf []byte) (int, error) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
 	s.m.Lock()
 	defer s.m.Unlock()
 	defer s.c.Broadcast()
@@ -368,13 +479,24 @@ func (s *stream) Write(buf []byte) (int, error) {
 		}
 
 		// send as much data as unblocked allows; we will wait for msgACKs
-		// before sending any additional bytes.
-		cap := util.Min(len(buf), int(s.unblocked))
-		if err := s.session.send(newDataFrame(s.id, buf[:cap])); err != nil {
+		// before sending any additional bytes.  Frames are limited in size
+		// so that other streams can take turns sending.
+		cap := util.Min(util.Min(len(buf), int(s.unblocked)), maxDataFrameSize)
+		s.unblocked -= uint32(cap)
+
+		// frames for this stream must still be handled while waiting for
+		// higher-priority streams to send, so release the lock
+		s.sending = true
+		priority := s.priority
+		s.m.Unlock()
+		err := s.session.sendWithPriority(newDataFrame(s.id, buf[:cap]), priority)
+		s.m.Lock()
+		s.sending = false
+		s.c.Broadcast()
+		if err != nil {
 			return w, err
 		}
 		buf = buf[cap:]
-		s.unblocked -= uint32(cap)
 		w += cap
 	}
 
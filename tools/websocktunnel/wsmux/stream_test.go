@@ -304,3 +304,42 @@ func TestConcurrentReadAndWrite(t *testing.T) {
 	}
 
 }
+
+func TestTuneWindow(t *testing.T) {
+	session := &Session{
+		streamBufferSize:    1024,
+		maxStreamBufferSize: 4096,
+		logger:              genLogger(),
+	}
+	str := newStream(1, session, PriorityNormal)
+
+	// a buffer that is read in full within two round trips is doubled, up to
+	// the maximum size
+	if grown := str.tuneWindow(512); grown != 0 {
+		t.Fatalf("buffer grew by %d before a full buffer was read", grown)
+	}
+	if grown := str.tuneWindow(512); grown != 1024 || str.b.cap != 2048 {
+		t.Fatalf("expected buffer to grow by 1024 to 2048, grew by %d to %d", grown, str.b.cap)
+	}
+	if grown := str.tuneWindow(2048); grown != 2048 || str.b.cap != 4096 {
+		t.Fatalf("expected buffer to grow by 2048 to 4096, grew by %d to %d", grown, str.b.cap)
+	}
+	if grown := str.tuneWindow(4096); grown != 0 || str.b.cap != 4096 {
+		t.Fatalf("expected buffer to remain at the maximum, grew by %d to %d", grown, str.b.cap)
+	}
+}
+
+func TestTuneWindowSlowReader(t *testing.T) {
+	session := &Session{
+		streamBufferSize:    1024,
+		maxStreamBufferSize: 4096,
+		logger:              genLogger(),
+	}
+	str := newStream(1, session, PriorityNormal)
+
+	// a buffer that takes longer than two round trips to read is not grown
+	str.epochStart = time.Now().Add(-2 * defaultRTT)
+	if grown := str.tuneWindow(1024); grown != 0 || str.b.cap != 1024 {
+		t.Fatalf("expected buffer not to grow, grew by %d to %d", grown, str.b.cap)
+	}
+}
@@ -0,0 +1,130 @@
+package wsproxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	adminPathPrefix  = "/__admin__/"
+	adminTunnelsPath = adminPathPrefix + "tunnels"
+)
+
+// TunnelInfo describes a tunnel connected to this proxy, as returned by the
+// admin API.
+type TunnelInfo struct {
+	ID               string    `json:"id"`
+	RemoteAddr       string    `json:"remoteAddr"`
+	Connected        time.Time `json:"connected"`
+	TCP              bool      `json:"tcp"`
+	ActiveStreams    int64     `json:"activeStreams"`
+	Requests         int64     `json:"requests"`
+	RejectedRequests int64     `json:"rejectedRequests"`
+	BytesToClient    int64     `json:"bytesToClient"`
+	BytesFromClient  int64     `json:"bytesFromClient"`
+}
+
+// ListTunnelsResponse is the json body served at /__admin__/tunnels.
+type ListTunnelsResponse struct {
+	Tunnels []TunnelInfo `json:"tunnels"`
+}
+
+// bearerTokenMatches returns true if the request has an `Authorization:
+// Bearer <token>` header with one of the given tokens, ignoring empty tokens
+func bearerTokenMatches(r *http.Request, tokens ...string) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	given := []byte(strings.TrimPrefix(header, "Bearer "))
+	for _, token := range tokens {
+		if token != "" && subtle.ConstantTimeCompare(given, []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// serveAdmin serves the admin API, which lists the tunnels connected to this
+// proxy (GET /__admin__/tunnels) and disconnects them (DELETE
+// /__admin__/tunnels/<id>). It is only available if an admin token is
+// configured.
+func (p *proxy) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	if p.adminToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !bearerTokenMatches(r, p.adminToken) {
+		p.logerrorf("", r.RemoteAddr, "unauthorized admin request: %s %s", r.Method, r.URL.Path)
+		http.Error(w, http.StatusText(401), 401)
+		return
+	}
+
+	switch {
+	case r.URL.Path == adminTunnelsPath:
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(405), 405)
+			return
+		}
+		p.listTunnels(w, r)
+	case strings.HasPrefix(r.URL.Path, adminTunnelsPath+"/"):
+		if r.Method != http.MethodDelete {
+			http.Error(w, http.StatusText(405), 405)
+			return
+		}
+		p.closeTunnel(w, r, strings.TrimPrefix(r.URL.Path, adminTunnelsPath+"/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// listTunnels serves the tunnels connected to this proxy, sorted by id
+func (p *proxy) listTunnels(w http.ResponseWriter, r *http.Request) {
+	response := ListTunnelsResponse{Tunnels: []TunnelInfo{}}
+	p.m.RLock()
+	for id, info := range p.tunnels {
+		response.Tunnels = append(response.Tunnels, TunnelInfo{
+			ID:         id,
+			RemoteAddr: info.remoteAddr,
+			Connected:  info.connected,
+			TCP:        info.tcp,
+		})
+	}
+	p.m.RUnlock()
+
+	// usage is locked separately, so is not read with p.m held
+	for i := range response.Tunnels {
+		tunnel := &response.Tunnels[i]
+		metrics := p.getUsage(tunnel.ID).metrics(true)
+		tunnel.ActiveStreams = metrics.ActiveStreams
+		tunnel.Requests = metrics.Requests
+		tunnel.RejectedRequests = metrics.RejectedRequests
+		tunnel.BytesToClient = metrics.BytesToClient
+		tunnel.BytesFromClient = metrics.BytesFromClient
+	}
+	sort.Slice(response.Tunnels, func(i, j int) bool {
+		return response.Tunnels[i].ID < response.Tunnels[j].ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&response)
+}
+
+// closeTunnel disconnects the tunnel with the given id. Requests for tunnels
+// connected to other replicas are forwarded to them.
+func (p *proxy) closeTunnel(w http.ResponseWriter, r *http.Request, id string) {
+	session, ok := p.getWorkerSession(id)
+	if !ok {
+		if !p.forwardRequest(w, r, id) {
+			http.Error(w, "No client is connected with that id", 404)
+		}
+		return
+	}
+	p.logEvent("tunnel-closed-by-admin", id, r.RemoteAddr, nil)
+	_ = session.Close()
+	w.WriteHeader(204)
+}
@@ -0,0 +1,110 @@
+package wsproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logTest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func adminRequest(t *testing.T, method, url, token string) *http.Response {
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func listTunnels(t *testing.T, url string) ListTunnelsResponse {
+	resp := adminRequest(t, "GET", url+"/__admin__/tunnels", "admin-token")
+	defer resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+	var list ListTunnelsResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	return list
+}
+
+func TestAdminListAndCloseTunnels(t *testing.T) {
+	logger, hook := logTest.NewNullLogger()
+	server, cleanup := startTunnel(t, Config{AdminToken: "admin-token", Logger: logger}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer cleanup()
+
+	resp, err := http.Get(server.URL + "/workerid/")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	list := listTunnels(t, server.URL)
+	require.Equal(t, 1, len(list.Tunnels))
+	tunnel := list.Tunnels[0]
+	require.Equal(t, "workerid", tunnel.ID)
+	require.NotEqual(t, "", tunnel.RemoteAddr)
+	require.WithinDuration(t, time.Now(), tunnel.Connected, time.Minute)
+	require.False(t, tunnel.TCP)
+	require.Equal(t, int64(1), tunnel.Requests)
+	require.Equal(t, int64(0), tunnel.ActiveStreams)
+	require.Equal(t, int64(5), tunnel.BytesFromClient)
+
+	// unknown tunnels cannot be closed
+	resp = adminRequest(t, "DELETE", server.URL+"/__admin__/tunnels/nosuch", "admin-token")
+	resp.Body.Close()
+	require.Equal(t, 404, resp.StatusCode)
+
+	resp = adminRequest(t, "DELETE", server.URL+"/__admin__/tunnels/workerid", "admin-token")
+	resp.Body.Close()
+	require.Equal(t, 204, resp.StatusCode)
+
+	require.Equal(t, 0, len(listTunnels(t, server.URL).Tunnels))
+	resp, err = http.Get(server.URL + "/workerid/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, 504, resp.StatusCode)
+
+	events := []string{}
+	for _, entry := range hook.AllEntries() {
+		if event, ok := entry.Data["event"]; ok {
+			require.Equal(t, "workerid", entry.Data["tunnel-id"])
+			require.Equal(t, logrus.InfoLevel, entry.Level)
+			events = append(events, event.(string))
+			if event == "tunnel-disconnected" {
+				require.Equal(t, int64(5), entry.Data["bytesFromClient"])
+			}
+		}
+	}
+	require.Equal(t, []string{"tunnel-connected", "tunnel-closed-by-admin", "tunnel-disconnected"}, events)
+}
+
+func TestAdminAuth(t *testing.T) {
+	server, cleanup := startTunnel(t, Config{AdminToken: "admin-token"}, http.NotFoundHandler())
+	defer cleanup()
+
+	for _, token := range []string{"", "wrong-token"} {
+		resp := adminRequest(t, "GET", server.URL+"/__admin__/tunnels", token)
+		resp.Body.Close()
+		require.Equal(t, 401, resp.StatusCode)
+
+		resp = adminRequest(t, "DELETE", server.URL+"/__admin__/tunnels/workerid", token)
+		resp.Body.Close()
+		require.Equal(t, 401, resp.StatusCode)
+	}
+
+	// the tunnel is still connected
+	require.Equal(t, 1, len(listTunnels(t, server.URL).Tunnels))
+}
+
+func TestAdminDisabled(t *testing.T) {
+	server, cleanup := startTunnel(t, Config{}, http.NotFoundHandler())
+	defer cleanup()
+
+	resp := adminRequest(t, "GET", server.URL+"/__admin__/tunnels", "")
+	resp.Body.Close()
+	require.Equal(t, 404, resp.StatusCode)
+}
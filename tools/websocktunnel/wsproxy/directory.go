@@ -0,0 +1,177 @@
+package wsproxy
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// forwardedHeader is set on viewer requests forwarded from one replica to
+// another, so that they are not forwarded again
+const forwardedHeader = "x-websocktunnel-forwarded-by"
+
+// directoryRefreshInterval is how often a proxy re-registers its tunnels with
+// its Directory, so that entries of backends with a TTL do not expire
+var directoryRefreshInterval = 30 * time.Second
+
+// Directory records which proxy replica each tunnel is connected to, so that
+// a viewer request arriving at any replica can be forwarded to the replica
+// holding the tunnel. Replicas are identified by the URL at which other
+// replicas can reach them.
+type Directory interface {
+	// Register records that the tunnel with the given id is connected to
+	// replica.
+	Register(id string, replica string) error
+
+	// Unregister removes the record for the tunnel with the given id, if it
+	// is connected to replica.
+	Unregister(id string, replica string) error
+
+	// Lookup returns the replica that the tunnel with the given id is
+	// connected to, or "" if it is not connected.
+	Lookup(id string) (string, error)
+}
+
+// memoryDirectory is a Directory held in memory, which can only be shared by
+// proxies in the same process.
+type memoryDirectory struct {
+	m        sync.RWMutex
+	replicas map[string]string
+}
+
+// NewMemoryDirectory returns a Directory held in memory.
+func NewMemoryDirectory() Directory {
+	return &memoryDirectory{
+		replicas: make(map[string]string),
+	}
+}
+
+func (d *memoryDirectory) Register(id string, replica string) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+	d.replicas[id] = replica
+	return nil
+}
+
+func (d *memoryDirectory) Unregister(id string, replica string) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.replicas[id] == replica {
+		delete(d.replicas, id)
+	}
+	return nil
+}
+
+func (d *memoryDirectory) Lookup(id string) (string, error) {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	return d.replicas[id], nil
+}
+
+// markDirectoryStale records that the directory entries of the tunnels with
+// the given ids may not reflect whether they are connected to this replica,
+// and wakes syncDirectory to update them.  This does not block, so can be
+// called with p.m held.
+func (p *proxy) markDirectoryStale(ids ...string) {
+	p.directoryM.Lock()
+	for _, id := range ids {
+		p.directoryStale[id] = true
+	}
+	p.directoryM.Unlock()
+	select {
+	case p.directoryWake <- struct{}{}:
+	default:
+	}
+}
+
+// nextStaleDirectoryEntry removes and returns the id of a tunnel whose
+// directory entry is stale, if there is one
+func (p *proxy) nextStaleDirectoryEntry() (string, bool) {
+	p.directoryM.Lock()
+	defer p.directoryM.Unlock()
+	for id := range p.directoryStale {
+		delete(p.directoryStale, id)
+		return id, true
+	}
+	return "", false
+}
+
+// syncDirectory updates the directory entries of tunnels as they connect and
+// disconnect, and periodically re-registers the tunnels connected to this
+// replica at the given interval, until the proxy is closed.
+//
+// The directory may be slow, so it is never called with p.m held.  Instead,
+// this reads whether each tunnel is connected just before updating its entry.
+// As this is the only goroutine updating the directory, an update for a
+// tunnel that has since reconnected or disconnected cannot overtake the
+// update reflecting that change.
+func (p *proxy) syncDirectory(refreshInterval time.Duration) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+			p.m.RLock()
+			ids := make([]string, 0, len(p.pool))
+			for id := range p.pool {
+				ids = append(ids, id)
+			}
+			p.m.RUnlock()
+			p.markDirectoryStale(ids...)
+		case <-p.directoryWake:
+		}
+
+		for {
+			id, ok := p.nextStaleDirectoryEntry()
+			if !ok {
+				break
+			}
+			_, connected := p.getWorkerSession(id)
+			if connected {
+				if err := p.directory.Register(id, p.replicaURL); err != nil {
+					p.logerrorf(id, "", "could not add tunnel to directory: %v", err)
+				}
+			} else {
+				if err := p.directory.Unregister(id, p.replicaURL); err != nil {
+					p.logerrorf(id, "", "could not remove tunnel from directory: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// forwardRequest forwards a viewer request to the replica that the tunnel
+// with the given id is connected to, and returns true if it did so. Requests
+// are not forwarded more than once.
+func (p *proxy) forwardRequest(w http.ResponseWriter, r *http.Request, id string) bool {
+	if p.directory == nil || r.Header.Get(forwardedHeader) != "" {
+		return false
+	}
+	replica, err := p.directory.Lookup(id)
+	if err != nil {
+		p.logerrorf(id, r.RemoteAddr, "could not look up tunnel in directory: %v", err)
+		return false
+	}
+	if replica == "" || replica == p.replicaURL {
+		return false
+	}
+	target, err := url.Parse(replica)
+	if err != nil {
+		p.logerrorf(id, r.RemoteAddr, "invalid replica URL %q in directory: %v", replica, err)
+		return false
+	}
+	p.logf(id, r.RemoteAddr, "forwarding request to replica %s", replica)
+	forwarder := httputil.NewSingleHostReverseProxy(target)
+	forwarder.FlushInterval = 100 * time.Millisecond
+	forwarder.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		p.logerrorf(id, r.RemoteAddr, "could not forward request to replica %s: %v", replica, err)
+		http.Error(w, "Could not reach the replica that the client is connected to", 502)
+	}
+	r.Header.Set(forwardedHeader, p.replicaURL)
+	forwarder.ServeHTTP(w, r)
+	return true
+}
@@ -0,0 +1,182 @@
+package wsproxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// unregisterScript deletes a key only if it still has the given value, so that
+// a replica does not remove a tunnel that has since connected elsewhere
+const unregisterScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+var errRedisNil = errors.New("redis: nil reply")
+
+// RedisDirectoryConfig contains the parameters of a Directory stored in Redis
+// (or a Redis-compatible store).
+type RedisDirectoryConfig struct {
+	// Addr is the host:port of the Redis server.
+	Addr string
+
+	// Password, if not empty, is sent with AUTH when connecting.
+	Password string
+
+	// KeyPrefix is prepended to tunnel ids to form Redis keys.
+	KeyPrefix string
+
+	// TTL is how long entries survive without being refreshed, so that
+	// tunnels of replicas that die are eventually forgotten. It must be
+	// longer than the interval at which proxies refresh their entries.
+	TTL time.Duration
+
+	// Timeout applies to connecting to, and each command sent to, Redis.
+	Timeout time.Duration
+}
+
+// redisDirectory is a Directory stored in Redis, using a single connection
+// which is re-established when it fails.
+type redisDirectory struct {
+	conf RedisDirectoryConfig
+	m    sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// NewRedisDirectory returns a Directory stored in Redis. Connections are made
+// lazily, so this does not fail if Redis is not (yet) reachable.
+func NewRedisDirectory(conf RedisDirectoryConfig) Directory {
+	if conf.TTL == 0 {
+		conf.TTL = 3 * directoryRefreshInterval
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 5 * time.Second
+	}
+	return &redisDirectory{conf: conf}
+}
+
+func (d *redisDirectory) Register(id string, replica string) error {
+	_, err := d.do("SET", d.conf.KeyPrefix+id, replica, "PX", strconv.FormatInt(int64(d.conf.TTL/time.Millisecond), 10))
+	return err
+}
+
+func (d *redisDirectory) Unregister(id string, replica string) error {
+	_, err := d.do("EVAL", unregisterScript, "1", d.conf.KeyPrefix+id, replica)
+	return err
+}
+
+func (d *redisDirectory) Lookup(id string) (string, error) {
+	reply, err := d.do("GET", d.conf.KeyPrefix+id)
+	if err == errRedisNil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	replica, ok := reply.(string)
+	if !ok {
+		return "", fmt.Errorf("redis: unexpected reply %v to GET", reply)
+	}
+	return replica, nil
+}
+
+// do sends a command and returns its reply, which is a string, an int64 or
+// nil. Error replies are returned as errors.
+func (d *redisDirectory) do(args ...string) (interface{}, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.conn == nil {
+		if err := d.connect(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := d.roundTrip(args)
+	if _, isRedisError := err.(redisError); err != nil && err != errRedisNil && !isRedisError {
+		// the connection is in an unknown state, so start afresh next time
+		_ = d.conn.Close()
+		d.conn = nil
+	}
+	return reply, err
+}
+
+func (d *redisDirectory) connect() error {
+	conn, err := net.DialTimeout("tcp", d.conf.Addr, d.conf.Timeout)
+	if err != nil {
+		return err
+	}
+	d.conn = conn
+	d.rd = bufio.NewReader(conn)
+	if d.conf.Password != "" {
+		if _, err := d.roundTrip([]string{"AUTH", d.conf.Password}); err != nil {
+			_ = conn.Close()
+			d.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *redisDirectory) roundTrip(args []string) (interface{}, error) {
+	if err := d.conn.SetDeadline(time.Now().Add(d.conf.Timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := d.conn.Write(encodeRESPCommand(args)); err != nil {
+		return nil, err
+	}
+	return readRESPReply(d.rd)
+}
+
+// redisError is an error reply from Redis
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// encodeRESPCommand encodes a command as a RESP array of bulk strings.
+func encodeRESPCommand(args []string) []byte {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"+arg+"\r\n"...)
+	}
+	return buf
+}
+
+// readRESPReply reads a simple string, error, integer or bulk string reply.
+func readRESPReply(rd *bufio.Reader) (interface{}, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk string length %q", payload)
+		}
+		if size < 0 {
+			return nil, errRedisNil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	default:
+		return nil, fmt.Errorf("redis: unsupported reply %q", line)
+	}
+}
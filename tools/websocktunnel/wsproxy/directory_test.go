@@ -0,0 +1,337 @@
+package wsproxy
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/taskcluster/v30/tools/websocktunnel/util"
+	"github.com/taskcluster/taskcluster/v30/tools/websocktunnel/wsmux"
+)
+
+// replica is a proxy replica served by an httptest.Server
+type replica struct {
+	*httptest.Server
+	proxy *proxy
+}
+
+func (r *replica) Close() {
+	r.Server.Close()
+	_ = r.proxy.Close()
+}
+
+// startReplica starts a proxy replica using the given directory
+func startReplica(t *testing.T, directory Directory) *replica {
+	server := httptest.NewUnstartedServer(nil)
+	proxy, err := newProxy(Config{
+		Upgrader:   upgrader,
+		Logger:     genLogger(),
+		JWTSecretA: []byte("test-secret"),
+		JWTSecretB: []byte("another-secret"),
+		URLPrefix:  "http://localhost",
+		Directory:  directory,
+		ReplicaURL: "http://" + server.Listener.Addr().String(),
+	})
+	require.NoError(t, err)
+	server.Config.Handler = proxy
+	server.Start()
+	return &replica{server, proxy}
+}
+
+// waitForDirectory waits until the directory records the tunnel with the
+// given id as connected to the given replica ("" for not connected)
+func waitForDirectory(t *testing.T, directory Directory, id, expected string) {
+	for i := 0; ; i++ {
+		replica, err := directory.Lookup(id)
+		require.NoError(t, err)
+		if replica == expected {
+			return
+		}
+		if i == 100 {
+			t.Fatalf("directory has %q for tunnel %s, not %q", replica, id, expected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProxyDirectoryForwarding(t *testing.T) {
+	directory := NewMemoryDirectory()
+	replicaA := startReplica(t, directory)
+	defer replicaA.Close()
+	replicaB := startReplica(t, directory)
+	defer replicaB.Close()
+
+	// connect the client to replica A
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer "+workeridjwt)
+	header.Set("x-websocktunnel-id", "workerid")
+	clientWs, _, err := websocket.DefaultDialer.Dial(util.MakeWsURL(replicaA.URL), header)
+	require.NoError(t, err)
+
+	clientHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			mt, buf, err := conn.ReadMessage()
+			if err == nil {
+				_ = conn.WriteMessage(mt, buf)
+			}
+			return
+		}
+		_, _ = w.Write([]byte("served " + r.URL.Path))
+	})
+	clientServer := &http.Server{Handler: clientHandler}
+	go func() {
+		_ = clientServer.Serve(wsmux.Client(clientWs, wsmux.Config{}))
+	}()
+	defer func() {
+		_ = clientServer.Close()
+	}()
+
+	waitForDirectory(t, directory, "workerid", replicaA.URL)
+
+	// a viewer request to replica B is forwarded to replica A
+	resp, err := http.Get(replicaB.URL + "/workerid/some/path")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, "served /some/path", string(body))
+
+	// and so are websocket connections
+	conn, _, err := websocket.DefaultDialer.Dial(util.MakeWsURL(replicaB.URL)+"/workerid/ws", nil)
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+	_, buf, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf))
+	_ = conn.Close()
+
+	// unknown tunnels are still not found
+	resp, err = http.Get(replicaB.URL + "/notWorkerID/")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, 504, resp.StatusCode)
+
+	// once the client disconnects, it is removed from the directory
+	_ = clientWs.Close()
+	waitForDirectory(t, directory, "workerid", "")
+}
+
+// blockingDirectory is a Directory whose updates block until unblocked
+type blockingDirectory struct {
+	Directory
+	unblock chan struct{}
+}
+
+func (d *blockingDirectory) Register(id string, replica string) error {
+	<-d.unblock
+	return d.Directory.Register(id, replica)
+}
+
+func (d *blockingDirectory) Unregister(id string, replica string) error {
+	<-d.unblock
+	return d.Directory.Unregister(id, replica)
+}
+
+func TestProxyDirectorySlow(t *testing.T) {
+	directory := &blockingDirectory{NewMemoryDirectory(), make(chan struct{})}
+	replicaA := startReplica(t, directory)
+	defer replicaA.Close()
+
+	dial := func() *websocket.Conn {
+		header := make(http.Header)
+		header.Set("Authorization", "Bearer "+workeridjwt)
+		header.Set("x-websocktunnel-id", "workerid")
+		conn, _, err := websocket.DefaultDialer.Dial(util.MakeWsURL(replicaA.URL), header)
+		require.NoError(t, err)
+		return conn
+	}
+
+	// while the directory is blocked, clients can still connect, reconnect
+	// and be found
+	clientWs := dial()
+	_, ok := replicaA.proxy.getWorkerSession("workerid")
+	require.True(t, ok)
+	_ = clientWs.Close()
+	clientWs = dial()
+	defer clientWs.Close()
+	_, ok = replicaA.proxy.getWorkerSession("workerid")
+	require.True(t, ok)
+
+	// once unblocked, the directory reflects the latest registration, even
+	// though the first session's removal happened after its registration
+	close(directory.unblock)
+	waitForDirectory(t, directory, "workerid", replicaA.URL)
+}
+
+func TestProxyDirectoryRefresh(t *testing.T) {
+	defer func(interval time.Duration) {
+		directoryRefreshInterval = interval
+	}(directoryRefreshInterval)
+	directoryRefreshInterval = 10 * time.Millisecond
+
+	directory := NewMemoryDirectory()
+	replicaA := startReplica(t, directory)
+	defer replicaA.Close()
+
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer "+workeridjwt)
+	header.Set("x-websocktunnel-id", "workerid")
+	clientWs, _, err := websocket.DefaultDialer.Dial(util.MakeWsURL(replicaA.URL), header)
+	require.NoError(t, err)
+	defer clientWs.Close()
+	waitForDirectory(t, directory, "workerid", replicaA.URL)
+
+	// an entry that has been lost, such as by expiring, is restored
+	require.NoError(t, directory.Unregister("workerid", replicaA.URL))
+	waitForDirectory(t, directory, "workerid", replicaA.URL)
+
+	// but not once the proxy is closed
+	require.NoError(t, replicaA.proxy.Close())
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, directory.Unregister("workerid", replicaA.URL))
+	time.Sleep(50 * time.Millisecond)
+	replica, err := directory.Lookup("workerid")
+	require.NoError(t, err)
+	require.Equal(t, "", replica)
+}
+
+func TestProxyDirectoryRequiresReplicaURL(t *testing.T) {
+	_, err := New(Config{
+		JWTSecretA: []byte("test-secret"),
+		JWTSecretB: []byte("another-secret"),
+		Directory:  NewMemoryDirectory(),
+	})
+	require.Equal(t, ErrMissingReplicaURL, err)
+}
+
+// fakeRedis implements the subset of Redis used by redisDirectory
+type fakeRedis struct {
+	m        sync.Mutex
+	listener net.Listener
+	values   map[string]string
+	password string
+}
+
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	r := &fakeRedis{listener: listener, values: map[string]string{}, password: password}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	authenticated := r.password == ""
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			if _, err := rd.ReadString('\n'); err != nil {
+				return
+			}
+			arg, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			args[i] = strings.TrimSuffix(arg, "\r\n")
+		}
+		r.m.Lock()
+		reply := ""
+		switch {
+		case args[0] == "AUTH":
+			authenticated = args[1] == r.password
+			reply = "+OK\r\n"
+			if !authenticated {
+				reply = "-ERR invalid password\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "SET":
+			r.values[args[1]] = args[2]
+			reply = "+OK\r\n"
+		case args[0] == "GET":
+			if value, ok := r.values[args[1]]; ok {
+				reply = "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+			} else {
+				reply = "$-1\r\n"
+			}
+		case args[0] == "EVAL" && args[1] == unregisterScript:
+			reply = ":0\r\n"
+			if r.values[args[3]] == args[4] {
+				delete(r.values, args[3])
+				reply = ":1\r\n"
+			}
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		r.m.Unlock()
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func TestRedisDirectory(t *testing.T) {
+	redis := startFakeRedis(t, "sekrit")
+	defer redis.listener.Close()
+
+	directory := NewRedisDirectory(RedisDirectoryConfig{
+		Addr:      redis.listener.Addr().String(),
+		Password:  "sekrit",
+		KeyPrefix: "wst:",
+	})
+
+	replica, err := directory.Lookup("workerid")
+	require.NoError(t, err)
+	require.Equal(t, "", replica)
+
+	require.NoError(t, directory.Register("workerid", "http://replica-a:8080"))
+	require.Equal(t, "http://replica-a:8080", redis.values["wst:workerid"])
+	replica, err = directory.Lookup("workerid")
+	require.NoError(t, err)
+	require.Equal(t, "http://replica-a:8080", replica)
+
+	// another replica cannot remove the tunnel
+	require.NoError(t, directory.Unregister("workerid", "http://replica-b:8080"))
+	replica, err = directory.Lookup("workerid")
+	require.NoError(t, err)
+	require.Equal(t, "http://replica-a:8080", replica)
+
+	require.NoError(t, directory.Unregister("workerid", "http://replica-a:8080"))
+	replica, err = directory.Lookup("workerid")
+	require.NoError(t, err)
+	require.Equal(t, "", replica)
+
+	// wrong password
+	directory = NewRedisDirectory(RedisDirectoryConfig{Addr: redis.listener.Addr().String(), Password: "wrong"})
+	_, err = directory.Lookup("workerid")
+	require.Error(t, err)
+}
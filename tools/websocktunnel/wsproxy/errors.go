@@ -16,4 +16,7 @@ var (
 
 	// ErrMissingSecret is returned when the proxy does not load both required secrets.
 	ErrMissingSecret = errors.New("both secrets must be loaded")
+
+	// ErrMissingReplicaURL is returned when the proxy has a Directory but no ReplicaURL.
+	ErrMissingReplicaURL = errors.New("a replica URL is required to use a directory")
 )
@@ -0,0 +1,249 @@
+package wsproxy
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/ed25519"
+)
+
+// jwksRefetchInterval is the minimum time between fetches of the JWKS URL
+var jwksRefetchInterval = time.Minute
+
+var (
+	// ErrUnknownKeyID is returned when a JWT is signed with a key that is not
+	// configured.
+	ErrUnknownKeyID = errors.New("unknown key id")
+)
+
+func init() {
+	jwt.RegisterSigningMethod(signingMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return signingMethodEdDSA
+	})
+}
+
+// edDSASigningMethod implements the EdDSA (Ed25519) JWT signing method of
+// RFC 8037, which jwt-go does not support.
+type edDSASigningMethod struct{}
+
+var signingMethodEdDSA = &edDSASigningMethod{}
+
+func (m *edDSASigningMethod) Alg() string {
+	return "EdDSA"
+}
+
+func (m *edDSASigningMethod) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (m *edDSASigningMethod) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// ParsePublicKeyPEM parses a PEM encoded PKIX public key, which must be an RSA
+// or Ed25519 key.
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// jwk is a JSON Web Key, see RFC 7517; only RSA and Ed25519 public keys are
+// supported.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+}
+
+// ParseJWKS parses a JSON Web Key Set, returning its public keys by key ID.
+// Keys of unsupported types are ignored.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		switch {
+		case k.Kty == "RSA":
+			n, err := jwt.DecodeSegment(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid modulus: %v", k.Kid, err)
+			}
+			e, err := jwt.DecodeSegment(k.E)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid exponent: %v", k.Kid, err)
+			}
+			keys[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case k.Kty == "OKP" && k.Crv == "Ed25519":
+			x, err := jwt.DecodeSegment(k.X)
+			if err != nil || len(x) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("key %q: invalid Ed25519 public key", k.Kid)
+			}
+			keys[k.Kid] = ed25519.PublicKey(x)
+		}
+	}
+	return keys, nil
+}
+
+// keyStore holds the public keys used to verify asymmetrically signed JWTs,
+// fetching them from a JWKS URL if configured.  The fetched keys replace those
+// from the previous fetch, so that keys removed from the JWKS are no longer
+// trusted.
+type keyStore struct {
+	m         sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetched   map[string]crypto.PublicKey
+	jwksURL   string
+	lastFetch time.Time
+
+	// fetches are numbered, so that an earlier fetch which completes late
+	// does not replace the keys from a later one
+	fetches   int
+	keysFetch int
+}
+
+func newKeyStore(keys map[string]crypto.PublicKey, jwksURL string) *keyStore {
+	store := &keyStore{
+		keys:    make(map[string]crypto.PublicKey),
+		fetched: make(map[string]crypto.PublicKey),
+		jwksURL: jwksURL,
+	}
+	for kid, key := range keys {
+		store.keys[kid] = key
+	}
+	return store
+}
+
+// empty returns true if no keys can be used
+func (s *keyStore) empty() bool {
+	return len(s.keys) == 0 && s.jwksURL == ""
+}
+
+// get returns the key with the given ID, preferring fetched keys over
+// configured ones.  The caller must hold s.m.
+func (s *keyStore) get(kid string) (crypto.PublicKey, bool) {
+	if key, ok := s.fetched[kid]; ok {
+		return key, true
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// lookup returns the key with the given ID; a key without an ID is only used
+// for tokens without one.  If the key is not known, the JWKS URL is refetched
+// before looking again.  If it is known, but the JWKS has not been fetched for
+// jwksRefetchInterval, it is refetched in the background, so that keys removed
+// from the JWKS stop being trusted.  Fetches happen without holding s.m, and
+// at most once per jwksRefetchInterval; lookups during a fetch do not wait
+// for it.
+func (s *keyStore) lookup(kid string) (crypto.PublicKey, error) {
+	s.m.Lock()
+	key, ok := s.get(kid)
+	fetch := 0
+	if s.jwksURL != "" && time.Since(s.lastFetch) >= jwksRefetchInterval {
+		s.lastFetch = time.Now()
+		s.fetches++
+		fetch = s.fetches
+	}
+	s.m.Unlock()
+
+	if ok {
+		if fetch != 0 {
+			go func() {
+				_ = s.refetch(fetch)
+			}()
+		}
+		return key, nil
+	}
+	if fetch != 0 {
+		if err := s.refetch(fetch); err != nil {
+			return nil, err
+		}
+		s.m.Lock()
+		key, ok = s.get(kid)
+		s.m.Unlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, ErrUnknownKeyID
+}
+
+// refetch performs the given fetch of the JWKS URL, replacing the keys from
+// any earlier fetch.  If the fetch fails, the previously fetched keys remain.
+func (s *keyStore) refetch(fetch int) error {
+	keys, err := fetchJWKS(s.jwksURL)
+	if err != nil {
+		return fmt.Errorf("could not fetch JWKS from %s: %v", s.jwksURL, err)
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if fetch > s.keysFetch {
+		s.fetched = keys
+		s.keysFetch = fetch
+	}
+	return nil
+}
+
+func fetchJWKS(url string) (map[string]crypto.PublicKey, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("HTTP status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
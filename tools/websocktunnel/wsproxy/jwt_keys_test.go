@@ -0,0 +1,281 @@
+package wsproxy
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/taskcluster/v30/tools/websocktunnel/util"
+	"golang.org/x/crypto/ed25519"
+)
+
+// signedToken returns a tunnel token for id signed with the given method and
+// key, with the given key ID (if not empty)
+func signedToken(t *testing.T, id string, method jwt.SigningMethod, key interface{}, kid string) string {
+	now := time.Now()
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"iat": now.Unix(),
+		"nbf": now.Unix() - 300,
+		"iss": "taskcluster-auth",
+		"exp": now.Add(24 * time.Hour).Unix(),
+		"tid": id,
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	tokString, err := token.SignedString(key)
+	require.NoError(t, err)
+	return tokString
+}
+
+// registerStatus attempts to register a client with the given token, and
+// returns the http status of the response
+func registerStatus(t *testing.T, server *httptest.Server, id string, token string) int {
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer "+token)
+	header.Set("x-websocktunnel-id", id)
+	conn, res, err := websocket.DefaultDialer.Dial(util.MakeWsURL(server.URL), header)
+	if err == nil {
+		_ = conn.Close()
+	}
+	require.NotNil(t, res)
+	return res.StatusCode
+}
+
+func jwkFor(t *testing.T, kid string, key crypto.PublicKey) map[string]string {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"n":   jwt.EncodeSegment(k.N.Bytes()),
+			"e":   jwt.EncodeSegment(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"kid": kid,
+			"x":   jwt.EncodeSegment(k),
+		}
+	}
+	t.Fatalf("unexpected key type %T", key)
+	return nil
+}
+
+func TestProxyAsymmetricJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, otherEdPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	// serve the keys as a JWKS
+	jwksRequests := 0
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwksRequests++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				jwkFor(t, "rsa-1", &rsaKey.PublicKey),
+				jwkFor(t, "ed-1", edPublic),
+			},
+		})
+	}))
+	defer jwks.Close()
+
+	// no HMAC secrets are configured, so HS256 tokens must not be accepted
+	proxy, err := New(Config{
+		Upgrader:  upgrader,
+		Logger:    genLogger(),
+		URLPrefix: "http://localhost",
+		JWKSURL:   jwks.URL,
+	})
+	require.NoError(t, err)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	require.Equal(t, 101, registerStatus(t, server, "workerid", signedToken(t, "workerid", jwt.SigningMethodRS256, rsaKey, "rsa-1")))
+	require.Equal(t, 101, registerStatus(t, server, "workerid", signedToken(t, "workerid", signingMethodEdDSA, edPrivate, "ed-1")))
+	require.Equal(t, 401, registerStatus(t, server, "otherid", signedToken(t, "workerid", signingMethodEdDSA, edPrivate, "ed-1")))
+	require.Equal(t, 401, registerStatus(t, server, "workerid", signedToken(t, "workerid", signingMethodEdDSA, otherEdPrivate, "ed-1")))
+	require.Equal(t, 401, registerStatus(t, server, "workerid", signedToken(t, "workerid", jwt.SigningMethodRS256, rsaKey, "ed-1")))
+	require.Equal(t, 401, registerStatus(t, server, "workerid", workeridjwt))
+	require.Equal(t, 401, registerStatus(t, server, "workerid", signedToken(t, "workerid", jwt.SigningMethodHS256, []byte(""), "")))
+
+	// the JWKS is fetched again for unknown key IDs, but not more than once
+	// a minute
+	require.Equal(t, 401, registerStatus(t, server, "workerid", signedToken(t, "workerid", signingMethodEdDSA, otherEdPrivate, "ed-2")))
+	require.Equal(t, 1, jwksRequests)
+}
+
+func TestProxyPublicKeyAndSecrets(t *testing.T) {
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(edPublic)
+	require.NoError(t, err)
+	publicKey, err := ParsePublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	require.NoError(t, err)
+
+	proxy, err := New(Config{
+		Upgrader:   upgrader,
+		Logger:     genLogger(),
+		URLPrefix:  "http://localhost",
+		JWTSecretA: []byte("test-secret"),
+		JWTSecretB: []byte("another-secret"),
+		PublicKeys: map[string]crypto.PublicKey{"": publicKey},
+	})
+	require.NoError(t, err)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	// a key without an ID is used only for tokens without a key ID
+	require.Equal(t, 101, registerStatus(t, server, "workerid", signedToken(t, "workerid", signingMethodEdDSA, edPrivate, "")))
+	require.Equal(t, 401, registerStatus(t, server, "workerid", signedToken(t, "workerid", signingMethodEdDSA, edPrivate, "some-kid")))
+	require.Equal(t, 101, registerStatus(t, server, "workerid", workeridjwt))
+}
+
+func TestParseJWKS(t *testing.T) {
+	keys, err := ParseJWKS([]byte(`{"keys": [
+		{"kty": "OKP", "crv": "Ed25519", "kid": "ed", "x": "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+		{"kty": "EC", "crv": "P-256", "kid": "ec", "x": "", "y": ""},
+		{"kty": "RSA", "kid": "rsa", "n": "sXchDaQebHnPiGvyDOAT4saGEUetSyo9MKLOoWFsueri23bOdgWp4Dy1WlUzewbgBHod5pcM9H95GQRV3JDXboIRROSBigeC5yjU1hGzHHyXss8UDprecbAYxknTcQkhslANGRUZmdTOQ5qTRsLAt6BTYuyvVRdhS8exSZEy_c4gs_7svlJJQ4H9_NxsiIoLwAEk7-Q3UXERGYw_75IDrGA84-lA_-Ct4eTlXHBIY2EaV7t7LjJaynVJCpkv4LKjTTAumiGUIuQhrNhZLuF_RJLqHpM2kgWFLU7-VTdL1VbC2tejvcI2BlMkEpk1BzBZI0KQB0GaDWFLN-aEAw3vRw", "e": "AQAB"}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.IsType(t, ed25519.PublicKey{}, keys["ed"])
+	require.IsType(t, &rsa.PublicKey{}, keys["rsa"])
+	require.Equal(t, 65537, keys["rsa"].(*rsa.PublicKey).E)
+
+	_, err = ParseJWKS([]byte(`{"keys": [{"kty": "OKP", "crv": "Ed25519", "kid": "ed", "x": "AAAA"}]}`))
+	require.Error(t, err)
+}
+
+func TestKeyStoreRotation(t *testing.T) {
+	defer func(interval time.Duration) {
+		jwksRefetchInterval = interval
+	}(jwksRefetchInterval)
+	jwksRefetchInterval = 0
+
+	oldPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	newPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	served := jwkFor(t, "old", oldPublic)
+	var m sync.Mutex
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{served},
+		})
+	}))
+	defer jwks.Close()
+
+	store := newKeyStore(nil, jwks.URL)
+	key, err := store.lookup("old")
+	require.NoError(t, err)
+	require.Equal(t, oldPublic, key)
+
+	// once the old key is removed from the JWKS, it is no longer trusted
+	m.Lock()
+	served = jwkFor(t, "new", newPublic)
+	m.Unlock()
+	key, err = store.lookup("new")
+	require.NoError(t, err)
+	require.Equal(t, newPublic, key)
+	_, err = store.lookup("old")
+	require.Equal(t, ErrUnknownKeyID, err)
+}
+
+func TestKeyStoreRemovedKey(t *testing.T) {
+	defer func(interval time.Duration) {
+		jwksRefetchInterval = interval
+	}(jwksRefetchInterval)
+	jwksRefetchInterval = 50 * time.Millisecond
+
+	oldPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	newPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	served := []map[string]string{jwkFor(t, "old", oldPublic), jwkFor(t, "new", newPublic)}
+	var m sync.Mutex
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": served})
+	}))
+	defer jwks.Close()
+
+	store := newKeyStore(nil, jwks.URL)
+	_, err = store.lookup("old")
+	require.NoError(t, err)
+
+	// the old key is removed from the JWKS, but is still trusted until the
+	// refetch interval has passed
+	m.Lock()
+	served = served[1:]
+	m.Unlock()
+	_, err = store.lookup("old")
+	require.NoError(t, err)
+
+	// after that, it is refetched and the old key is no longer trusted, even
+	// though no token with an unknown key ID has been seen
+	time.Sleep(jwksRefetchInterval)
+	require.Eventually(t, func() bool {
+		_, err := store.lookup("old")
+		return err == ErrUnknownKeyID
+	}, 5*time.Second, 10*time.Millisecond)
+	key, err := store.lookup("new")
+	require.NoError(t, err)
+	require.Equal(t, newPublic, key)
+}
+
+func TestKeyStoreSlowFetch(t *testing.T) {
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-release
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{}})
+	}))
+	defer jwks.Close()
+	defer close(release)
+
+	store := newKeyStore(map[string]crypto.PublicKey{"static": edPublic}, jwks.URL)
+	go func() {
+		_, _ = store.lookup("unknown")
+	}()
+	<-requested
+
+	// while the JWKS is being fetched, known keys can still be looked up, and
+	// unknown keys do not trigger another fetch
+	key, err := store.lookup("static")
+	require.NoError(t, err)
+	require.Equal(t, edPublic, key)
+	_, err = store.lookup("other")
+	require.Equal(t, ErrUnknownKeyID, err)
+}
+
+func TestProxyRequiresKeys(t *testing.T) {
+	require.Panics(t, func() {
+		_, _ = New(Config{JWTSecretA: []byte("test-secret")})
+	})
+}
@@ -2,7 +2,9 @@ package wsproxy
 
 import (
 	"bufio"
+	"crypto"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -39,16 +41,65 @@ type Config struct {
 	// Logger is used to log proxy events. Refer util.Logger.
 	Logger *logrus.Logger
 
-	// JWTSecretA and JWTSecretB are used by the proxy to verify JWTs from Clients.
+	// JWTSecretA and JWTSecretB are used by the proxy to verify HS256 JWTs
+	// from Clients. They are required unless PublicKeys or JWKSURL is set.
 	JWTSecretA []byte
 	JWTSecretB []byte
 
+	// PublicKeys are used to verify RS256 and EdDSA JWTs from Clients, by
+	// key ID (the kid header of the JWT). A key with ID "" is used for JWTs
+	// whose key ID is not found. Keys must be *rsa.PublicKey or
+	// ed25519.PublicKey values.
+	PublicKeys map[string]crypto.PublicKey
+
+	// JWKSURL, if set, is the URL of a JSON Web Key Set with further public
+	// keys, which is fetched (at most once a minute) when a JWT has a key ID
+	// that is not yet known.
+	JWKSURL string
+
 	// the prefix for publicly accessible URLs (used to generate the URLs sent
 	// to clients)
 	URLPrefix string
 
 	// Audience value for aud claim
 	Audience string
+
+	// Directory, if not nil, records which replica each tunnel is connected
+	// to, so that viewer requests for tunnels connected to other replicas
+	// can be forwarded to them.
+	Directory Directory
+
+	// ReplicaURL is the URL at which other replicas can reach this one.
+	// It is required if Directory is set.
+	ReplicaURL string
+	// BytesPerSecond, if not 0, limits the combined bandwidth of all
+	// requests to each tunnel, in both directions.
+	BytesPerSecond int64
+
+	// MaxConcurrentStreams, if not 0, limits the number of concurrent
+	// viewer requests (including websocket connections) to each tunnel.
+	// Further requests are rejected with 429 Too Many Requests.
+	MaxConcurrentStreams int
+
+	// AdminToken, if set, enables the admin API at /__admin__/, which
+	// requires an `Authorization: Bearer <AdminToken>` header.
+	AdminToken string
+
+	// MetricsToken, if set, enables the usage metrics at /__metrics__, which
+	// require an `Authorization: Bearer <MetricsToken>` header.  The metrics
+	// are also available with AdminToken, if that is set.
+	MetricsToken string
+}
+
+// tunnelInfo describes a connected tunnel.  It is replaced, rather than
+// modified, when the tunnel's session is resumed.
+type tunnelInfo struct {
+	tcp        bool
+	remoteAddr string
+	connected  time.Time
+
+	// token with which the client can resume its session, if resumable
+	sessionToken string
 }
 
 // proxy is used to send http and ws requests to a registered client.
@@ -56,20 +107,53 @@ type Config struct {
 type proxy struct {
 	m               sync.RWMutex
 	pool            map[string]*wsmux.Session
+	tunnels         map[string]*tunnelInfo
 	upgrader        websocket.Upgrader
 	logger          *logrus.Logger
 	onSessionRemove func(string)
 	jwtSecretA      []byte
 	jwtSecretB      []byte
+	publicKeys      *keyStore
 	urlPrefix       string
 	audience        string
+	directory       Directory
+	replicaURL      string
+	adminToken      string
+	metricsToken    string
+
+	// tunnels whose directory entries must be updated by syncDirectory, which
+	// is woken by directoryWake; directoryM covers directoryStale
+	directoryM     sync.Mutex
+	directoryStale map[string]bool
+	directoryWake  chan struct{}
+
+	// closed when the proxy is closed, to stop background goroutines
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	bytesPerSecond       int64
+	maxConcurrentStreams int
+	usageM               sync.Mutex
+	usage                map[string]*tunnelUsage
+	usagePruned          time.Time
 }
 
-// New creates a new proxy instance and wraps it as an http.Handler.
+// New creates a new proxy instance and wraps it as an http.Handler.  The
+// handler also implements io.Closer; closing it stops the proxy's background
+// work, such as refreshing its Directory entries.
 func New(conf Config) (http.Handler, error) {
 	return newProxy(conf)
 }
 
+// Close stops the proxy's background work.  It does not close the sessions of
+// connected clients.
+func (p *proxy) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+	return nil
+}
+
 // ServeHTTP implements http.Handler so that the proxy may be used as a handler in a Mux or http.Server
 func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	p.logf("", r.RemoteAddr, "Host=%s Path=%s", r.Host, r.URL.Path)
@@ -81,6 +165,14 @@ func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path == "/__version__" {
 		http.ServeFile(w, r, versionJsonPath)
 	}
+	if r.URL.Path == "/__metrics__" {
+		p.serveMetrics(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, adminPathPrefix) {
+		p.serveAdmin(w, r)
+		return
+	}
 
 	// Client registration requests are a GET of path / with some headers set
 	if path, id := r.URL.Path, r.Header.Get("x-websocktunnel-id"); id != "" && path == "/" {
@@ -110,23 +202,46 @@ func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func newProxy(conf Config) (*proxy, error) {
 	p := &proxy{
 		pool:       make(map[string]*wsmux.Session),
+		tunnels:    make(map[string]*tunnelInfo),
 		upgrader:   conf.Upgrader,
 		logger:     conf.Logger,
 		jwtSecretA: conf.JWTSecretA,
 		jwtSecretB: conf.JWTSecretB,
+		publicKeys: newKeyStore(conf.PublicKeys, conf.JWKSURL),
 		urlPrefix:  strings.TrimSuffix(conf.URLPrefix, "/"),
 		audience:   conf.Audience,
+		directory:  conf.Directory,
+		replicaURL: strings.TrimSuffix(conf.ReplicaURL, "/"),
+		adminToken: conf.AdminToken,
+
+		metricsToken: conf.MetricsToken,
+
+		directoryStale: make(map[string]bool),
+		directoryWake:  make(chan struct{}, 1),
+		closed:         make(chan struct{}),
+
+		bytesPerSecond:       conf.BytesPerSecond,
+		maxConcurrentStreams: conf.MaxConcurrentStreams,
+		usage:                make(map[string]*tunnelUsage),
 	}
 
-	if len(p.jwtSecretA) == 0 || len(p.jwtSecretB) == 0 {
+	if (len(p.jwtSecretA) == 0 || len(p.jwtSecretB) == 0) && p.publicKeys.empty() {
 		panic("wsproxy: missing secrets")
 	}
 
+	if p.directory != nil && p.replicaURL == "" {
+		return nil, ErrMissingReplicaURL
+	}
+
 	if p.logger == nil {
 		logger, _ := nullLog.NewNullLogger()
 		p.logger = logger
 	}
 
+	if p.directory != nil {
+		go p.syncDirectory(directoryRefreshInterval)
+	}
+
 	return p, nil
 
 }
@@ -147,13 +262,38 @@ func (p *proxy) getWorkerSession(id string) (*wsmux.Session, bool) {
 	return s, ok
 }
 
+// isTCPTunnel returns true if the client with the given id registered its
+// tunnel to carry raw TCP connections
+func (p *proxy) isTCPTunnel(id string) bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	info, ok := p.tunnels[id]
+	return ok && info.tcp
+}
+
 // removeTunnel is an idempotent operation which deletes a client session from the proxy's
 // pool
 func (p *proxy) removeTunnel(id string) {
 	p.m.Lock()
-	defer p.m.Unlock()
+	info := p.tunnels[id]
 	delete(p.pool, id)
-	p.logf(id, "", "session removed")
+	delete(p.tunnels, id)
+	if p.directory != nil {
+		p.markDirectoryStale(id)
+	}
+	p.m.Unlock()
+
+	// usage is locked separately, so is not read with p.m held
+	if info != nil {
+		p.setUsageConnected(id, false)
+		metrics := p.getUsage(id).metrics(false)
+		p.logEvent("tunnel-disconnected", id, info.remoteAddr, logrus.Fields{
+			"duration":        time.Since(info.connected).Seconds(),
+			"requests":        metrics.Requests,
+			"bytesToClient":   metrics.BytesToClient,
+			"bytesFromClient": metrics.BytesFromClient,
+		})
+	}
 }
 
 // register is used to connect a client to the proxy so that it can start serving API endpoints.
@@ -180,6 +320,18 @@ func (p *proxy) register(w http.ResponseWriter, r *http.Request, id, tokenString
 		return
 	}
 
+	// clients carrying raw TCP connections say so
+	tcp := false
+	switch mode := r.Header.Get("x-websocktunnel-mode"); mode {
+	case "", "http":
+	case "tcp":
+		tcp = true
+	default:
+		p.logerrorf(id, r.RemoteAddr, "invalid tunnel mode %q", mode)
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+
 	// validation does not require lock
 	if err := p.validateJWT(id, tokenString); err != nil {
 		p.logerrorf(id, r.RemoteAddr, "unable to validate token: %v", err)
@@ -187,6 +339,18 @@ func (p *proxy) register(w http.ResponseWriter, r *http.Request, id, tokenString
 		return
 	}
 
+	// clients which can resume their sessions say so, giving the token of
+	// the session to resume after reconnecting
+	resumable := r.Header.Get("x-websocktunnel-resumable") == "true"
+	if token := r.Header.Get("x-websocktunnel-session"); resumable && token != "" {
+		if p.resumeTunnel(w, r, id, tcp, token) {
+			return
+		}
+	}
+
+	// ensure the tunnel appears in metrics once connected
+	p.setUsageConnected(id, true)
+
 	p.m.Lock()
 
 	// remove any existing session forcibly
@@ -209,11 +373,13 @@ func (p *proxy) register(w http.ResponseWriter, r *http.Request, id, tokenString
 
 	delete(p.pool, id)
 
-	header := make(http.Header)
-
-	url := p.urlPrefix + "/" + id
-	header.Set("x-websocktunnel-client-url", url)
-	p.logf(id, r.RemoteAddr, "sending url= %s", url)
+	header := p.registrationHeader(id, tcp)
+	sessionToken := ""
+	if resumable {
+		sessionToken = newSessionToken()
+		header.Set("x-websocktunnel-session", sessionToken)
+	}
+	p.logf(id, r.RemoteAddr, "sending url= %s", header.Get("x-websocktunnel-client-url"))
 	conn, err := p.upgrader.Upgrade(w, r, header)
 	if err != nil {
 		p.logger.Print(err)
@@ -222,18 +388,42 @@ func (p *proxy) register(w http.ResponseWriter, r *http.Request, id, tokenString
 
 	// generate config
 	conf := wsmux.Config{
-		StreamBufferSize: 4 * 1024,
 		CloseCallback: func() {
 			p.removeTunnel(id)
 			if p.onSessionRemove != nil {
 				p.onSessionRemove(id)
 			}
 		},
-		Log: p.logger,
+		Log:       p.logger,
+		Resumable: resumable,
+		DisconnectCallback: func() {
+			p.logf(id, r.RemoteAddr, "connection lost; waiting for client to resume session")
+		},
 	}
 
 	p.pool[id] = wsmux.Server(conn, conf)
-	p.logf(id, r.RemoteAddr, "added new tunnel")
+	p.tunnels[id] = &tunnelInfo{
+		tcp:          tcp,
+		remoteAddr:   r.RemoteAddr,
+		connected:    time.Now(),
+		sessionToken: sessionToken,
+	}
+	p.logEvent("tunnel-connected", id, r.RemoteAddr, logrus.Fields{"tcp": tcp})
+	if p.directory != nil {
+		p.markDirectoryStale(id)
+	}
+}
+
+// registrationHeader returns the headers of the response to a client's
+// registration
+func (p *proxy) registrationHeader(id string, tcp bool) http.Header {
+	header := make(http.Header)
+	header.Set("x-websocktunnel-client-url", p.urlPrefix+"/"+id)
+	// the mode is echoed so that clients can tell that the proxy supports it
+	if tcp {
+		header.Set("x-websocktunnel-mode", "tcp")
+	}
+	return header
 }
 
 // serveRequest serves tunnel endpoints to viewers
@@ -244,6 +434,11 @@ func (p *proxy) serveRequest(w http.ResponseWriter, r *http.Request, id string,
 
 	session, ok := p.getWorkerSession(id)
 
+	// forward the request if the tunnel is connected to another replica
+	if !ok && p.forwardRequest(w, r, id) {
+		return
+	}
+
 	// return 504 (bad gateway) if tunnel is not registered on this proxy
 	if !ok {
 		p.logerrorf(id, r.RemoteAddr, "could not find requested tunnel")
@@ -251,15 +446,39 @@ func (p *proxy) serveRequest(w http.ResponseWriter, r *http.Request, id string,
 		return
 	}
 
+	usage := p.getUsage(id)
+	if !usage.startStream(p.maxConcurrentStreams) {
+		p.logerrorf(id, r.RemoteAddr, "too many concurrent requests")
+		http.Error(w, "Too many concurrent requests to this client", 429)
+		return
+	}
+	defer usage.endStream()
+
+	// tcp tunnels carry the data of viewers' websocket messages as raw
+	// streams, with no HTTP to route requests by
+	if p.isTCPTunnel(id) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			p.logerrorf(id, r.RemoteAddr, "request to tcp tunnel is not a websocket upgrade")
+			http.Error(w, "This client only accepts websocket connections, which carry a raw TCP stream", 400)
+			return
+		}
+		_ = p.tcpProxy(w, r, session, usage, id)
+		return
+	}
+
 	// set original path as header
 	r.Header.Set("x-websocktunnel-original-path", r.URL.Path)
 
 	// check for a websocket request
 	if websocket.IsWebSocketUpgrade(r) {
-		_ = p.websocketProxy(w, r, session, id, path)
+		_ = p.websocketProxy(w, r, session, usage, id, path)
 		return
 	}
 
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = ioutil.NopCloser(usage.meter(r.Body, &usage.bytesToClient))
+	}
+
 	// Open a stream to the tunnel session
 	// path is the modified RequestURI
 	reqURI, err := url.ParseRequestURI(path)
@@ -313,11 +532,12 @@ func (p *proxy) serveRequest(w http.ResponseWriter, r *http.Request, id string,
 		_ = reqStream.Close()
 	}()
 
+	body := usage.meter(resp.Body, &usage.bytesFromClient)
 	flusher, ok := w.(http.Flusher)
 	// flusher may not be implemented by a ResponseWriter wrapper
 	// simple copy
 	if !ok {
-		n, err := io.Copy(w, resp.Body)
+		n, err := io.Copy(w, body)
 		p.logf(id, r.RemoteAddr, "data transfered over request: %d bytes, error: %v", n, err)
 		// log here
 		return
@@ -325,38 +545,57 @@ func (p *proxy) serveRequest(w http.ResponseWriter, r *http.Request, id string,
 
 	p.logf(id, r.RemoteAddr, "streaming http")
 	wf := &threadSafeWriteFlusher{w: w, f: flusher}
-	n, err := copyAndFlush(wf, resp.Body, 100*time.Millisecond)
+	n, err := copyAndFlush(wf, body, 100*time.Millisecond)
 	p.logf(id, r.RemoteAddr, "data transfered over request: %d bytes, error: %v", n, err)
 }
 
+// keyFunc returns the key to verify a jwt with, using the given secret for
+// HMAC signed tokens
+func (p *proxy) keyFunc(secret []byte) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			return secret, nil
+		case *jwt.SigningMethodRSA, *edDSASigningMethod:
+			kid, _ := token.Header["kid"].(string)
+			return p.publicKeys.lookup(kid)
+		}
+		return nil, ErrUnexpectedSigningMethod
+	}
+}
+
+func isHMAC(token *jwt.Token) bool {
+	_, ok := token.Method.(*jwt.SigningMethodHMAC)
+	return ok
+}
+
 // validate jwt
-// jwt signing and verification algorithm must be HMAC
+// jwt signing and verification algorithm must be HMAC (if secrets are
+// configured), or RS256 or EdDSA (if public keys are configured)
 func (p *proxy) validateJWT(id string, tokenString string) error {
+	validMethods := []string{}
+	if len(p.jwtSecretA) > 0 && len(p.jwtSecretB) > 0 {
+		validMethods = append(validMethods, "HS256")
+	}
+	if !p.publicKeys.empty() {
+		validMethods = append(validMethods, "RS256", "EdDSA")
+	}
+
 	// parse jwt token
 	// default parser verifies iat token if present. This can be a problem because of clocks not being
 	// in sync.
 	parser := &jwt.Parser{
-		ValidMethods:         []string{"HS256"},
+		ValidMethods:         validMethods,
 		SkipClaimsValidation: true, // Claims will be verified if token can be decoded using secret
 	}
 
-	token, err := parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, ErrUnexpectedSigningMethod
-		}
-		return p.jwtSecretA, nil
-	})
+	token, err := parser.Parse(tokenString, p.keyFunc(p.jwtSecretA))
 
-	if err != nil {
+	if err != nil && token != nil && isHMAC(token) {
 		// log first error
 		p.logerrorf(id, "", "%v: trying with second secret", err)
 
-		token, err = parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, ErrUnexpectedSigningMethod
-			}
-			return p.jwtSecretB, nil
-		})
+		token, err = parser.Parse(tokenString, p.keyFunc(p.jwtSecretB))
 	}
 
 	if err != nil {
@@ -416,3 +655,13 @@ func (p *proxy) logerrorf(id string, remoteAddr string, format string, v ...inte
 		"remote-addr": remoteAddr,
 	}).Errorf(format, v...)
 }
+
+// logEvent logs a structured event concerning a tunnel, with the given
+// additional fields
+func (p *proxy) logEvent(event string, id string, remoteAddr string, fields logrus.Fields) {
+	p.logger.WithFields(fields).WithFields(logrus.Fields{
+		"event":       event,
+		"tunnel-id":   id,
+		"remote-addr": remoteAddr,
+	}).Info(event)
+}
@@ -0,0 +1,57 @@
+package wsproxy
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+)
+
+// newSessionToken returns a random token with which a client can resume its
+// session
+func newSessionToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// resumeTunnel resumes the session of the client with the given id over the
+// requesting connection, if the session still exists and has the given
+// token.  Viewers' requests to the tunnel continue as if the client had not
+// disconnected.  It returns false if there is no such session, in which case
+// the client should be registered with a new session.
+func (p *proxy) resumeTunnel(w http.ResponseWriter, r *http.Request, id string, tcp bool, token string) bool {
+	p.m.RLock()
+	session, info := p.pool[id], p.tunnels[id]
+	p.m.RUnlock()
+	if session == nil || info == nil || info.tcp != tcp ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(info.sessionToken)) != 1 {
+		p.logf(id, r.RemoteAddr, "no session to resume; registering a new session")
+		return false
+	}
+
+	header := p.registrationHeader(id, tcp)
+	header.Set("x-websocktunnel-session", token)
+	header.Set("x-websocktunnel-resumed", "true")
+	conn, err := p.upgrader.Upgrade(w, r, header)
+	if err != nil {
+		p.logger.Print(err)
+		return true
+	}
+	if err := session.Resume(conn); err != nil {
+		p.logerrorf(id, r.RemoteAddr, "could not resume session: %v", err)
+		return true
+	}
+
+	p.m.Lock()
+	if p.pool[id] == session {
+		resumed := *info
+		resumed.remoteAddr = r.RemoteAddr
+		p.tunnels[id] = &resumed
+	}
+	p.m.Unlock()
+	p.logEvent("tunnel-resumed", id, r.RemoteAddr, nil)
+	return true
+}
@@ -0,0 +1,168 @@
+package wsproxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	logTest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/taskcluster/v30/tools/websocktunnel/client"
+	"github.com/taskcluster/taskcluster/v30/tools/websocktunnel/util"
+)
+
+// flakyLink forwards TCP connections to a server, and can drop all of them
+// at once, as a network failure would
+type flakyLink struct {
+	listener net.Listener
+	m        sync.Mutex
+	conns    []net.Conn
+}
+
+func newFlakyLink(t *testing.T, addr string) *flakyLink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l := &flakyLink{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", addr)
+			if err != nil {
+				_ = conn.Close()
+				continue
+			}
+			l.m.Lock()
+			l.conns = append(l.conns, conn, upstream)
+			l.m.Unlock()
+			go func() {
+				_, _ = io.Copy(upstream, conn)
+				_ = upstream.Close()
+			}()
+			go func() {
+				_, _ = io.Copy(conn, upstream)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func (l *flakyLink) drop() {
+	l.m.Lock()
+	defer l.m.Unlock()
+	for _, conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.conns = nil
+}
+
+func (l *flakyLink) close() {
+	_ = l.listener.Close()
+	l.drop()
+}
+
+// Test that a viewer's websocket connection to a client survives the client
+// reconnecting to the proxy
+func TestProxyResumeSession(t *testing.T) {
+	logger, hook := logTest.NewNullLogger()
+	proxy, err := New(Config{
+		Upgrader:   upgrader,
+		JWTSecretA: []byte("test-secret"),
+		JWTSecretB: []byte("another-secret"),
+		URLPrefix:  "http://localhost",
+		Logger:     logger,
+	})
+	require.NoError(t, err)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	link := newFlakyLink(t, strings.TrimPrefix(server.URL, "http://"))
+	defer link.close()
+
+	retry := client.RetryConfig{InitialDelay: 10 * time.Millisecond, MaxDelay: 100 * time.Millisecond}
+	cl, err := client.New(testConfigurer("workerid", "ws://"+link.listener.Addr().String(), retry, genLogger()))
+	require.NoError(t, err)
+	defer cl.Close()
+	url := cl.URL()
+
+	// the client echoes websocket messages
+	go func() {
+		_ = (&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for {
+				mtype, msg, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				if err := conn.WriteMessage(mtype, msg); err != nil {
+					return
+				}
+			}
+		})}).Serve(cl)
+	}()
+
+	viewer, _, err := websocket.DefaultDialer.Dial(util.MakeWsURL(server.URL)+"/workerid/echo", nil)
+	require.NoError(t, err)
+	defer viewer.Close()
+
+	echo := func(msg string) {
+		require.NoError(t, viewer.WriteMessage(websocket.TextMessage, []byte(msg)))
+		require.NoError(t, viewer.SetReadDeadline(time.Now().Add(10*time.Second)))
+		_, reply, err := viewer.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, msg, string(reply))
+	}
+	echo("before")
+
+	link.drop()
+	echo("during")
+	echo("after")
+
+	events := map[string]int{}
+	for _, entry := range hook.AllEntries() {
+		if event, ok := entry.Data["event"].(string); ok {
+			events[event]++
+		}
+	}
+	require.Equal(t, map[string]int{"tunnel-connected": 1, "tunnel-resumed": 1}, events)
+	require.Equal(t, url, cl.URL())
+}
+
+// Test that a client which asks to resume an unknown session is given a new
+// session
+func TestProxyResumeUnknownSession(t *testing.T) {
+	proxy, err := New(Config{
+		Upgrader:   upgrader,
+		JWTSecretA: []byte("test-secret"),
+		JWTSecretB: []byte("another-secret"),
+		Logger:     genLogger(),
+	})
+	require.NoError(t, err)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer "+workeridjwt)
+	header.Set("x-websocktunnel-id", "workerid")
+	header.Set("x-websocktunnel-resumable", "true")
+	header.Set("x-websocktunnel-session", "no-such-session")
+	conn, resp, err := websocket.DefaultDialer.Dial(util.MakeWsURL(server.URL), header)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, "", resp.Header.Get("x-websocktunnel-resumed"))
+	require.NotEqual(t, "", resp.Header.Get("x-websocktunnel-session"))
+	require.NotEqual(t, "no-such-session", resp.Header.Get("x-websocktunnel-session"))
+}
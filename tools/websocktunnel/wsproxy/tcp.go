@@ -0,0 +1,118 @@
+package wsproxy
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/taskcluster/taskcluster/v30/tools/websocktunnel/wsmux"
+)
+
+// tcpBufferSize is the largest websocket message sent to viewers of tcp
+// tunnels
+const tcpBufferSize = 32 * 1024
+
+// tcpProxy bridges a viewer's websocket connection to a new stream of a tcp
+// tunnel. The payloads of binary messages from the viewer are written to the
+// stream, and data read from the stream is sent to the viewer as binary
+// messages, so the client sees a raw byte stream.
+func (p *proxy) tcpProxy(w http.ResponseWriter, r *http.Request, session *wsmux.Session, usage *tunnelUsage, tunnelID string) error {
+	p.logf(tunnelID, r.RemoteAddr, "creating TCP bridge: path=%s", r.URL.RequestURI())
+	// tcp tunnels typically carry interactive sessions
+	stream, err := session.OpenWithPriority(wsmux.PriorityHigh)
+	if err != nil {
+		p.logerrorf(tunnelID, r.RemoteAddr, "could not create stream: path=%s", r.URL.RequestURI())
+		http.Error(w, http.StatusText(500), 500)
+		return err
+	}
+
+	upgrader := websocket.Upgrader{
+		Subprotocols: websocket.Subprotocols(r),
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+	viewerConn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		p.logerrorf(tunnelID, r.RemoteAddr, "could not upgrade client connection: path=%s, error: %v", r.URL.RequestURI(), err)
+		_ = stream.Close()
+		return err
+	}
+
+	// ensure both ends are closed after the bridge exits
+	defer func() {
+		_ = viewerConn.Close()
+		_ = stream.Close()
+	}()
+
+	stopper := newStopper()
+	var eSrc, eDest atomic.Value
+
+	// viewer -> tunnel
+	go func() {
+		defer stopper.stop()
+		for {
+			mtype, reader, err := viewerConn.NextReader()
+			if err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure,
+					websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+					eSrc.Store(err)
+				}
+				return
+			}
+			if mtype != websocket.BinaryMessage {
+				// text messages have no meaning in a byte stream
+				_ = viewerConn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseUnsupportedData, "only binary messages are supported"),
+					time.Now().Add(5*time.Second))
+				return
+			}
+			if _, err := io.Copy(stream, usage.meter(reader, &usage.bytesToClient)); err != nil {
+				eSrc.Store(err)
+				return
+			}
+			if stopper.is_stopped() {
+				return
+			}
+		}
+	}()
+
+	// tunnel -> viewer
+	go func() {
+		defer stopper.stop()
+		reader := usage.meter(stream, &usage.bytesFromClient)
+		buf := make([]byte, tcpBufferSize)
+		for {
+			n, err := reader.Read(buf)
+			if n > 0 {
+				if werr := viewerConn.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					eDest.Store(werr)
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					eDest.Store(err)
+				}
+				_ = viewerConn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+					time.Now().Add(5*time.Second))
+				return
+			}
+		}
+	}()
+
+	stopper.wait()
+
+	if err, ok := eSrc.Load().(error); ok && err != nil {
+		p.logerrorf(tunnelID, r.RemoteAddr, "tcp bridge closed with err: %v", err)
+		return err
+	}
+	if err, ok := eDest.Load().(error); ok && err != nil {
+		p.logerrorf(tunnelID, r.RemoteAddr, "tcp bridge closed with err: %v", err)
+		return err
+	}
+	return nil
+}
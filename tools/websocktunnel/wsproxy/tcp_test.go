@@ -0,0 +1,123 @@
+package wsproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/taskcluster/v30/tools/websocktunnel/client"
+	"github.com/taskcluster/taskcluster/v30/tools/websocktunnel/util"
+)
+
+func tcpConfigurer(id, addr string) client.Configurer {
+	configurer := testConfigurer(id, addr, client.RetryConfig{}, genLogger())
+	return func() (client.Config, error) {
+		conf, err := configurer()
+		conf.TCP = true
+		return conf, err
+	}
+}
+
+// Test that viewers' websocket connections to a tcp tunnel are carried as
+// raw byte streams
+func TestProxyTCPTunnel(t *testing.T) {
+	proxy, err := New(Config{
+		Upgrader:   upgrader,
+		JWTSecretA: []byte("test-secret"),
+		JWTSecretB: []byte("another-secret"),
+		URLPrefix:  "http://localhost",
+		Logger:     genLogger(),
+	})
+	require.NoError(t, err)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	cl, err := client.New(tcpConfigurer("tcpclient", util.MakeWsURL(server.URL)))
+	require.NoError(t, err)
+	defer cl.Close()
+
+	// the client echoes the first 5 bytes of each stream, which would be
+	// the start of an HTTP request if the data were not raw
+	go func() {
+		for {
+			stream, err := cl.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer stream.Close()
+				buf := make([]byte, 5)
+				if _, err := io.ReadFull(stream, buf); err != nil {
+					return
+				}
+				_, _ = stream.Write(buf)
+			}()
+		}
+	}()
+
+	// any path may be used
+	viewer, _, err := websocket.DefaultDialer.Dial(util.MakeWsURL(server.URL)+"/tcpclient/any/path", nil)
+	require.NoError(t, err)
+	defer viewer.Close()
+
+	require.NoError(t, viewer.WriteMessage(websocket.BinaryMessage, []byte("he")))
+	require.NoError(t, viewer.WriteMessage(websocket.BinaryMessage, []byte("llo")))
+
+	received := []byte{}
+	for len(received) < 5 {
+		mtype, payload, err := viewer.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, websocket.BinaryMessage, mtype)
+		received = append(received, payload...)
+	}
+	require.Equal(t, "hello", string(received))
+
+	// the client closing the stream closes the viewer's connection
+	_, _, err = viewer.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), "unexpected error %v", err)
+
+	// HTTP requests are not accepted
+	resp, err := http.Get(server.URL + "/tcpclient/any/path")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, 400, resp.StatusCode)
+}
+
+// Test that a tcp client fails to connect to a proxy which does not support
+// tcp tunnels
+func TestClientTCPNotSupported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	_, err := client.New(tcpConfigurer("tcpclient", util.MakeWsURL(server.URL)))
+	require.Equal(t, client.ErrTCPNotSupported, err)
+}
+
+// Test that registering with an unknown mode fails
+func TestProxyRegisterInvalidMode(t *testing.T) {
+	proxy, err := New(Config{
+		Upgrader:   upgrader,
+		JWTSecretA: []byte("test-secret"),
+		JWTSecretB: []byte("another-secret"),
+		Logger:     genLogger(),
+	})
+	require.NoError(t, err)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer "+workeridjwt)
+	header.Set("x-websocktunnel-id", "workerid")
+	header.Set("x-websocktunnel-mode", "udp")
+	_, resp, err := websocket.DefaultDialer.Dial(util.MakeWsURL(server.URL), header)
+	require.Error(t, err)
+	require.Equal(t, 400, resp.StatusCode)
+}
@@ -0,0 +1,245 @@
+package wsproxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// usageRetention is how long the usage of a tunnel is kept after its client
+// disconnects, and usagePruneInterval how often usage is checked for entries
+// to remove
+var (
+	usageRetention     = time.Hour
+	usagePruneInterval = time.Minute
+)
+
+// TunnelMetrics describes the usage of a single tunnel since the proxy
+// started. Bytes are counted in both directions: to the client (request
+// bodies and websocket messages from viewers) and from the client (response
+// bodies and websocket messages to viewers).
+type TunnelMetrics struct {
+	Connected        bool  `json:"connected"`
+	ActiveStreams    int64 `json:"activeStreams"`
+	Requests         int64 `json:"requests"`
+	RejectedRequests int64 `json:"rejectedRequests"`
+	BytesToClient    int64 `json:"bytesToClient"`
+	BytesFromClient  int64 `json:"bytesFromClient"`
+}
+
+// MetricsResponse is the json body served at /__metrics__.
+type MetricsResponse struct {
+	Tunnels map[string]TunnelMetrics `json:"tunnels"`
+}
+
+// tunnelUsage accounts for, and limits, the traffic of a single tunnel. Its
+// counters are accessed atomically.
+type tunnelUsage struct {
+	activeStreams    int64
+	requests         int64
+	rejectedRequests int64
+	bytesToClient    int64
+	bytesFromClient  int64
+	limiter          *rateLimiter
+
+	// the time at which the client disconnected, or zero if it is connected
+	// or has not yet connected; this is covered by proxy.usageM
+	disconnected time.Time
+}
+
+// startStream counts a new viewer request, returning false (and counting a
+// rejection) if the tunnel already has maxStreams active streams. If
+// maxStreams is 0, the number of streams is not limited.
+func (u *tunnelUsage) startStream(maxStreams int) bool {
+	atomic.AddInt64(&u.requests, 1)
+	if active := atomic.AddInt64(&u.activeStreams, 1); maxStreams > 0 && active > int64(maxStreams) {
+		atomic.AddInt64(&u.activeStreams, -1)
+		atomic.AddInt64(&u.rejectedRequests, 1)
+		return false
+	}
+	return true
+}
+
+func (u *tunnelUsage) endStream() {
+	atomic.AddInt64(&u.activeStreams, -1)
+}
+
+// meter returns a reader which adds the bytes read from r to counter, and
+// which is throttled to the tunnel's rate limit, if any.
+func (u *tunnelUsage) meter(r io.Reader, counter *int64) io.Reader {
+	return &meteredReader{r: r, usage: u, counter: counter}
+}
+
+func (u *tunnelUsage) metrics(connected bool) TunnelMetrics {
+	return TunnelMetrics{
+		Connected:        connected,
+		ActiveStreams:    atomic.LoadInt64(&u.activeStreams),
+		Requests:         atomic.LoadInt64(&u.requests),
+		RejectedRequests: atomic.LoadInt64(&u.rejectedRequests),
+		BytesToClient:    atomic.LoadInt64(&u.bytesToClient),
+		BytesFromClient:  atomic.LoadInt64(&u.bytesFromClient),
+	}
+}
+
+type meteredReader struct {
+	r       io.Reader
+	usage   *tunnelUsage
+	counter *int64
+}
+
+func (m *meteredReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	if n > 0 {
+		atomic.AddInt64(m.counter, int64(n))
+		if m.usage.limiter != nil {
+			time.Sleep(m.usage.limiter.reserve(n))
+		}
+	}
+	return n, err
+}
+
+// rateLimiter is a token bucket, refilled at bytesPerSecond up to one
+// second's worth of tokens. Reservations may take the bucket below zero, in
+// which case the caller must wait for it to refill.
+type rateLimiter struct {
+	m              sync.Mutex
+	bytesPerSecond float64
+	tokens         float64
+	last           time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	return &rateLimiter{
+		bytesPerSecond: float64(bytesPerSecond),
+		tokens:         float64(bytesPerSecond),
+		last:           time.Now(),
+	}
+}
+
+// reserve takes n tokens and returns how long the caller must wait before
+// using them.
+func (l *rateLimiter) reserve(n int) time.Duration {
+	l.m.Lock()
+	defer l.m.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.bytesPerSecond
+	if l.tokens > l.bytesPerSecond {
+		l.tokens = l.bytesPerSecond
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.bytesPerSecond * float64(time.Second))
+}
+
+// getUsage returns the usage of the tunnel with the given id, which persists
+// across reconnections of the client.
+func (p *proxy) getUsage(id string) *tunnelUsage {
+	p.usageM.Lock()
+	u, ok := p.usage[id]
+	if ok {
+		p.usageM.Unlock()
+		return u
+	}
+	u = &tunnelUsage{}
+	if p.bytesPerSecond > 0 {
+		u.limiter = newRateLimiter(p.bytesPerSecond)
+	}
+	p.usage[id] = u
+	p.usageM.Unlock()
+
+	// only new entries make the usage grow, so this is when to prune it
+	p.pruneUsage()
+	return u
+}
+
+// setUsageConnected records whether the client of the tunnel with the given
+// id is connected, so that the tunnel's usage can be removed some time after
+// it disconnects.
+func (p *proxy) setUsageConnected(id string, connected bool) {
+	u := p.getUsage(id)
+	p.usageM.Lock()
+	defer p.usageM.Unlock()
+	if connected {
+		u.disconnected = time.Time{}
+	} else {
+		u.disconnected = time.Now()
+	}
+}
+
+// pruneUsage removes the usage of tunnels whose clients disconnected more
+// than usageRetention ago, and have not reconnected.  This does nothing if
+// usage was pruned within the last usagePruneInterval.
+func (p *proxy) pruneUsage() {
+	p.usageM.Lock()
+	now := time.Now()
+	if now.Sub(p.usagePruned) < usagePruneInterval {
+		p.usageM.Unlock()
+		return
+	}
+	p.usagePruned = now
+	candidates := []string{}
+	for id, u := range p.usage {
+		if !u.disconnected.IsZero() && now.Sub(u.disconnected) > usageRetention {
+			candidates = append(candidates, id)
+		}
+	}
+	p.usageM.Unlock()
+
+	// p.m is not taken with usageM held, so check for clients which have
+	// reconnected separately; those are left for the next prune
+	expired := []string{}
+	for _, id := range candidates {
+		if _, connected := p.getWorkerSession(id); !connected {
+			expired = append(expired, id)
+		}
+	}
+
+	p.usageM.Lock()
+	defer p.usageM.Unlock()
+	for _, id := range expired {
+		if u, ok := p.usage[id]; ok && !u.disconnected.IsZero() && now.Sub(u.disconnected) > usageRetention {
+			delete(p.usage, id)
+		}
+	}
+}
+
+// serveMetrics serves the usage of every tunnel that has been connected to
+// this proxy or received viewer requests, other than tunnels whose clients
+// disconnected more than usageRetention ago.  It requires the metrics token
+// or the admin token, and is not available if neither is configured.
+func (p *proxy) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if p.metricsToken == "" && p.adminToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !bearerTokenMatches(r, p.metricsToken, p.adminToken) {
+		p.logerrorf("", r.RemoteAddr, "unauthorized metrics request")
+		http.Error(w, http.StatusText(401), 401)
+		return
+	}
+
+	p.pruneUsage()
+
+	// snapshot the usage, so that sessions are not looked up (taking p.m)
+	// with usageM held
+	p.usageM.Lock()
+	usage := make(map[string]*tunnelUsage, len(p.usage))
+	for id, u := range p.usage {
+		usage[id] = u
+	}
+	p.usageM.Unlock()
+
+	response := MetricsResponse{Tunnels: map[string]TunnelMetrics{}}
+	for id, u := range usage {
+		_, connected := p.getWorkerSession(id)
+		response.Tunnels[id] = u.metrics(connected)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&response)
+}
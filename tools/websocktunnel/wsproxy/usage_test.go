@@ -0,0 +1,195 @@
+package wsproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/taskcluster/v30/tools/websocktunnel/util"
+	"github.com/taskcluster/taskcluster/v30/tools/websocktunnel/wsmux"
+)
+
+// startTunnel starts a proxy with the given config and connects a client
+// serving handler to it with id workerid. The returned func closes both.
+func startTunnel(t *testing.T, conf Config, handler http.Handler) (*httptest.Server, func()) {
+	conf.Upgrader = upgrader
+	if conf.Logger == nil {
+		conf.Logger = genLogger()
+	}
+	conf.JWTSecretA = []byte("test-secret")
+	conf.JWTSecretB = []byte("another-secret")
+	conf.URLPrefix = "http://localhost"
+	proxy, err := New(conf)
+	require.NoError(t, err)
+	server := httptest.NewServer(proxy)
+
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer "+workeridjwt)
+	header.Set("x-websocktunnel-id", "workerid")
+	clientWs, _, err := websocket.DefaultDialer.Dial(util.MakeWsURL(server.URL), header)
+	require.NoError(t, err)
+	clientServer := &http.Server{Handler: handler}
+	go func() {
+		_ = clientServer.Serve(wsmux.Client(clientWs, wsmux.Config{}))
+	}()
+	return server, func() {
+		_ = clientServer.Close()
+		server.Close()
+	}
+}
+
+// getMetricsStatus requests the metrics with the given bearer token, and
+// returns the response status
+func getMetricsStatus(t *testing.T, server *httptest.Server, token string) int {
+	req, err := http.NewRequest("GET", server.URL+"/__metrics__", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func getMetrics(t *testing.T, server *httptest.Server) MetricsResponse {
+	req, err := http.NewRequest("GET", server.URL+"/__metrics__", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer metrics-token")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+	var metrics MetricsResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&metrics))
+	return metrics
+}
+
+func TestProxyMetrics(t *testing.T) {
+	server, cleanup := startTunnel(t, Config{MetricsToken: "metrics-token"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write(append(body, body...))
+	}))
+	defer cleanup()
+
+	require.Equal(t, TunnelMetrics{Connected: true}, getMetrics(t, server).Tunnels["workerid"])
+
+	resp, err := http.Post(server.URL+"/workerid/", "text/plain", bytes.NewBufferString("message"))
+	require.NoError(t, err)
+	reply, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, "messagemessage", string(reply))
+
+	require.Equal(t, TunnelMetrics{
+		Connected:       true,
+		Requests:        1,
+		BytesToClient:   7,
+		BytesFromClient: 14,
+	}, getMetrics(t, server).Tunnels["workerid"])
+}
+
+func TestProxyMetricsAuth(t *testing.T) {
+	server, cleanup := startTunnel(t, Config{MetricsToken: "metrics-token", AdminToken: "admin-token"}, http.NotFoundHandler())
+	defer cleanup()
+	require.Equal(t, 200, getMetricsStatus(t, server, "metrics-token"))
+	require.Equal(t, 200, getMetricsStatus(t, server, "admin-token"))
+	require.Equal(t, 401, getMetricsStatus(t, server, "wrong-token"))
+	require.Equal(t, 401, getMetricsStatus(t, server, ""))
+
+	// metrics are not served at all without a token configured
+	server, cleanup = startTunnel(t, Config{}, http.NotFoundHandler())
+	defer cleanup()
+	require.Equal(t, 404, getMetricsStatus(t, server, ""))
+}
+
+func TestProxyUsagePruned(t *testing.T) {
+	defer func(retention, interval time.Duration) {
+		usageRetention, usagePruneInterval = retention, interval
+	}(usageRetention, usagePruneInterval)
+	usageRetention, usagePruneInterval = 50*time.Millisecond, 0
+
+	p, err := newProxy(Config{
+		JWTSecretA: []byte("test-secret"),
+		JWTSecretB: []byte("another-secret"),
+	})
+	require.NoError(t, err)
+
+	p.setUsageConnected("gone", true)
+	p.setUsageConnected("gone", false)
+	p.setUsageConnected("connected", true)
+	p.pruneUsage()
+	require.Equal(t, 2, len(p.usage))
+
+	time.Sleep(100 * time.Millisecond)
+	p.pruneUsage()
+	require.Equal(t, 1, len(p.usage))
+	require.NotNil(t, p.usage["connected"])
+}
+
+func TestProxyMaxConcurrentStreams(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server, cleanup := startTunnel(t, Config{MaxConcurrentStreams: 1, MetricsToken: "metrics-token"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		_, _ = w.Write([]byte("done"))
+	}))
+	defer cleanup()
+
+	firstStatus := make(chan int)
+	go func() {
+		resp, err := http.Get(server.URL + "/workerid/")
+		if err != nil {
+			firstStatus <- 0
+			return
+		}
+		_ = resp.Body.Close()
+		firstStatus <- resp.StatusCode
+	}()
+	<-started
+
+	// a second request while the first is active is rejected
+	resp, err := http.Get(server.URL + "/workerid/")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, 429, resp.StatusCode)
+
+	close(release)
+	require.Equal(t, 200, <-firstStatus)
+
+	metrics := getMetrics(t, server).Tunnels["workerid"]
+	require.Equal(t, int64(2), metrics.Requests)
+	require.Equal(t, int64(1), metrics.RejectedRequests)
+	require.Equal(t, int64(0), metrics.ActiveStreams)
+}
+
+func TestProxyBytesPerSecond(t *testing.T) {
+	body := make([]byte, 30*1024)
+	server, cleanup := startTunnel(t, Config{BytesPerSecond: 10 * 1024}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	}))
+	defer cleanup()
+
+	// the first second's worth is allowed immediately, the remainder at
+	// 10KB/s
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/workerid/")
+	require.NoError(t, err)
+	reply, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, len(body), len(reply))
+	require.True(t, time.Since(start) >= 1500*time.Millisecond, "response took only %v", time.Since(start))
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	limiter := newRateLimiter(1000)
+	require.Equal(t, time.Duration(0), limiter.reserve(1000))
+	wait := limiter.reserve(500)
+	require.True(t, wait > 400*time.Millisecond && wait <= 500*time.Millisecond, "unexpected wait %v", wait)
+}
@@ -11,11 +11,13 @@ import (
 	"github.com/taskcluster/taskcluster/v30/tools/websocktunnel/wsmux"
 )
 
-func (p *proxy) websocketProxy(w http.ResponseWriter, r *http.Request, session *wsmux.Session, tunnelID string, path string) error {
+func (p *proxy) websocketProxy(w http.ResponseWriter, r *http.Request, session *wsmux.Session, usage *tunnelUsage, tunnelID string, path string) error {
 	// at this point, we are sure that r is a http websocket upgrade request
 	// connClosure returns the wsmux stream to Dial
 	p.logf(tunnelID, r.RemoteAddr, "creating WS bridge: path=%s", r.URL.RequestURI())
-	stream, err := session.Open()
+	// websockets are typically interactive, so are scheduled ahead of
+	// other requests
+	stream, err := session.OpenWithPriority(wsmux.PriorityHigh)
 	p.logf(tunnelID, r.RemoteAddr, "opened new stream for ws: path=%s", r.URL.RequestURI())
 
 	if err != nil {
@@ -70,7 +72,7 @@ func (p *proxy) websocketProxy(w http.ResponseWriter, r *http.Request, session *
 	p.logf(tunnelID, r.RemoteAddr, "initiating connection bridge")
 
 	// bridge both websocket connections
-	bridgeErr := p.bridgeConn(tunnelConn, viewerConn)
+	bridgeErr := p.bridgeConn(tunnelConn, viewerConn, usage)
 	if bridgeErr != nil {
 		p.logerrorf(tunnelID, r.RemoteAddr, "bridge closed with err: %v", bridgeErr)
 	}
@@ -84,7 +86,9 @@ func (p *proxy) websocketProxy(w http.ResponseWriter, r *http.Request, session *
 	return err
 }
 
-func (p *proxy) bridgeConn(conn1 *websocket.Conn, conn2 *websocket.Conn) error {
+// bridgeConn copies messages between the tunnel connection conn1 and the
+// viewer connection conn2, accounting for them in usage
+func (p *proxy) bridgeConn(conn1 *websocket.Conn, conn2 *websocket.Conn, usage *tunnelUsage) error {
 	// set ping and pong handlers
 	conn1.SetPingHandler(forwardControl(websocket.PingMessage, conn2))
 	conn2.SetPingHandler(forwardControl(websocket.PingMessage, conn1))
@@ -111,13 +115,17 @@ func (p *proxy) bridgeConn(conn1 *websocket.Conn, conn2 *websocket.Conn) error {
 	// Wait until errors are written
 
 	go func() {
-		err := copyWsData(conn1, conn2, stopper)
+		err := copyWsData(conn1, conn2, stopper, func(r io.Reader) io.Reader {
+			return usage.meter(r, &usage.bytesToClient)
+		})
 		if err != nil {
 			eSrc.Store(err)
 		}
 	}()
 	go func() {
-		err := copyWsData(conn2, conn1, stopper)
+		err := copyWsData(conn2, conn1, stopper, func(r io.Reader) io.Reader {
+			return usage.meter(r, &usage.bytesFromClient)
+		})
 		if err != nil {
 			eDest.Store(err)
 		}
@@ -135,7 +143,8 @@ func (p *proxy) bridgeConn(conn1 *websocket.Conn, conn2 *websocket.Conn) error {
 	return nil
 }
 
-func copyWsData(dest *websocket.Conn, src *websocket.Conn, stopper *stopper) error {
+// copyWsData copies messages from src to dest, reading each through meter
+func copyWsData(dest *websocket.Conn, src *websocket.Conn, stopper *stopper, meter func(io.Reader) io.Reader) error {
 	defer stopper.stop()
 	for {
 		mtype, reader, err := src.NextReader()
@@ -156,7 +165,7 @@ func copyWsData(dest *websocket.Conn, src *websocket.Conn, stopper *stopper) err
 			}
 			return nil
 		}
-		_, err = io.Copy(writer, reader)
+		_, err = io.Copy(writer, meter(reader))
 		_ = writer.Close()
 		if err != nil {
 			return err
@@ -2,6 +2,8 @@ package cfg
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 
 	yaml "gopkg.in/yaml.v3"
 )
@@ -32,3 +34,60 @@ func (lc *LoggingConfig) UnmarshalYAML(node *yaml.Node) error {
 
 	return nil
 }
+
+// Unpack this LoggingConfig to a logging implementation's configuration struct.  This will produce
+// an error for any missing properties.  Note that recursion is not supported.
+//
+// Structs should be tagged with `logging:"name"`, with the name defaulting to the
+// lowercased version of the field name.  Properties tagged `logging:",optional"` may
+// be omitted, leaving the field unchanged.
+func (lc *LoggingConfig) Unpack(out interface{}) error {
+	outval := reflect.ValueOf(out)
+	if outval.Kind() != reflect.Ptr || outval.IsNil() {
+		return fmt.Errorf("expected a pointer, got %s", outval.Kind())
+	}
+	destval := reflect.Indirect(outval)
+	if destval.Kind() != reflect.Struct {
+		return fmt.Errorf("expected a pointer to struct, got &%s", destval.Kind())
+	}
+	desttype := destval.Type()
+	numfield := desttype.NumField()
+	for i := 0; i < numfield; i++ {
+		// get the expected property name
+		field := desttype.Field(i)
+		var name string
+		optional := false
+		tag := field.Tag.Get("logging")
+		tagBits := strings.Split(tag, ",")
+
+		if len(tagBits) == 0 || tagBits[0] == "" {
+			name = strings.ToLower(field.Name[:1]) + field.Name[1:]
+		} else {
+			name = tagBits[0]
+		}
+
+		for _, tagBit := range tagBits {
+			if tagBit == "optional" {
+				optional = true
+			}
+		}
+
+		// get the value
+		val, ok := lc.Data[name]
+		if !ok {
+			if optional {
+				continue
+			}
+			return fmt.Errorf("Configuration value `logging.%s` not found", name)
+		}
+
+		// check types and set the struct field
+		destfield := destval.Field(i)
+		gotval := reflect.ValueOf(val)
+		if destfield.Type() != gotval.Type() {
+			return fmt.Errorf("Configuration value `logging.%s` should have type %s, got %s", name, destfield.Type(), gotval.Type())
+		}
+		destfield.Set(gotval)
+	}
+	return nil
+}
@@ -17,3 +17,45 @@ func TestUnmarshalLoggingYAML(t *testing.T) {
 	require.Equal(t, "stdio", lc.Implementation)
 	require.Equal(t, map[string]interface{}{"foo": "bar"}, lc.Data)
 }
+
+func TestLoggingUnpack(t *testing.T) {
+	type mylc struct {
+		Path     string
+		MaxSize  int  `logging:"maxSizeMB"`
+		Compress bool `logging:",optional"`
+	}
+
+	var lc LoggingConfig
+	err := yaml.Unmarshal([]byte("implementation: file\npath: /var/log/x\nmaxSizeMB: 10"), &lc)
+	require.NoError(t, err)
+
+	c := mylc{Compress: true}
+	require.NoError(t, lc.Unpack(&c))
+	require.Equal(t, mylc{"/var/log/x", 10, true}, c)
+}
+
+func TestLoggingUnpackMissing(t *testing.T) {
+	type mylc struct {
+		Path string
+	}
+
+	var lc LoggingConfig
+	err := yaml.Unmarshal([]byte("implementation: file"), &lc)
+	require.NoError(t, err)
+
+	var c mylc
+	require.Error(t, lc.Unpack(&c))
+}
+
+func TestLoggingUnpackWrongType(t *testing.T) {
+	type mylc struct {
+		MaxSize int `logging:"maxSizeMB,optional"`
+	}
+
+	var lc LoggingConfig
+	err := yaml.Unmarshal([]byte("implementation: file\nmaxSizeMB: big"), &lc)
+	require.NoError(t, err)
+
+	var c mylc
+	require.Error(t, lc.Unpack(&c))
+}
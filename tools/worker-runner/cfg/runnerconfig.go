@@ -15,6 +15,7 @@ type RunnerConfig struct {
 	Logging              *LoggingConfig             `yaml:"logging"`
 	GetSecrets           bool                       `yaml:"getSecrets"`
 	CacheOverRestarts    string                     `yaml:"cacheOverRestarts"`
+	Supervision          *SupervisionConfig         `yaml:"supervision"`
 }
 
 // Load a configuration file
@@ -0,0 +1,76 @@
+package cfg
+
+import (
+	"fmt"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// The configuration for restarting the worker when it exits unexpectedly.
+type SupervisionConfig struct {
+	// exit codes for which the worker is restarted
+	RestartOnExitCodes []int `yaml:"restartOnExitCodes"`
+
+	// the maximum number of times the worker is restarted
+	MaxRestarts int `yaml:"maxRestarts"`
+
+	// the delay before the first restart, doubling for each subsequent
+	// restart up to MaxDelay
+	InitialDelay time.Duration `yaml:"initialDelay"`
+	MaxDelay     time.Duration `yaml:"maxDelay"`
+}
+
+func (sc *SupervisionConfig) UnmarshalYAML(node *yaml.Node) error {
+	// an alias type, without this method, to decode into
+	type supervisionConfig SupervisionConfig
+
+	// set nonzero defaults
+	decoded := supervisionConfig{
+		MaxRestarts:  10,
+		InitialDelay: 5 * time.Second,
+		MaxDelay:     5 * time.Minute,
+	}
+
+	err := node.Decode(&decoded)
+	if err != nil {
+		return err
+	}
+
+	if len(decoded.RestartOnExitCodes) == 0 {
+		return fmt.Errorf("supervision config must have a non-empty `restartOnExitCodes` property")
+	}
+	if decoded.MaxRestarts < 0 {
+		return fmt.Errorf("supervision config's `maxRestarts` property must not be negative")
+	}
+
+	*sc = SupervisionConfig(decoded)
+	return nil
+}
+
+// Determine whether the worker should be restarted after exiting with the
+// given exit code, having already been restarted the given number of times.
+func (sc *SupervisionConfig) ShouldRestart(exitCode int, restarts int) bool {
+	if sc == nil || restarts >= sc.MaxRestarts {
+		return false
+	}
+	for _, code := range sc.RestartOnExitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the delay before restarting the worker, having already been restarted
+// the given number of times.
+func (sc *SupervisionConfig) RestartDelay(restarts int) time.Duration {
+	delay := sc.InitialDelay
+	for i := 0; i < restarts && delay < sc.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > sc.MaxDelay {
+		delay = sc.MaxDelay
+	}
+	return delay
+}
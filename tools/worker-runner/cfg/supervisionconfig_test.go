@@ -0,0 +1,51 @@
+package cfg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func TestUnmarshalSupervisionYAML(t *testing.T) {
+	var sc SupervisionConfig
+
+	err := yaml.Unmarshal([]byte("restartOnExitCodes: [69]\nmaxDelay: 1m"), &sc)
+	require.NoError(t, err)
+	require.Equal(t, SupervisionConfig{
+		RestartOnExitCodes: []int{69},
+		MaxRestarts:        10,
+		InitialDelay:       5 * time.Second,
+		MaxDelay:           time.Minute,
+	}, sc)
+}
+
+func TestUnmarshalSupervisionYAMLNoExitCodes(t *testing.T) {
+	var sc SupervisionConfig
+
+	err := yaml.Unmarshal([]byte("maxRestarts: 3"), &sc)
+	require.Error(t, err)
+}
+
+func TestShouldRestart(t *testing.T) {
+	sc := &SupervisionConfig{RestartOnExitCodes: []int{69, 70}, MaxRestarts: 2}
+
+	require.True(t, sc.ShouldRestart(69, 0))
+	require.True(t, sc.ShouldRestart(70, 1))
+	require.False(t, sc.ShouldRestart(69, 2))
+	require.False(t, sc.ShouldRestart(1, 0))
+
+	var nilsc *SupervisionConfig
+	require.False(t, nilsc.ShouldRestart(69, 0))
+}
+
+func TestRestartDelay(t *testing.T) {
+	sc := &SupervisionConfig{InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	require.Equal(t, time.Second, sc.RestartDelay(0))
+	require.Equal(t, 2*time.Second, sc.RestartDelay(1))
+	require.Equal(t, 4*time.Second, sc.RestartDelay(2))
+	require.Equal(t, 5*time.Second, sc.RestartDelay(3))
+	require.Equal(t, 5*time.Second, sc.RestartDelay(100))
+}
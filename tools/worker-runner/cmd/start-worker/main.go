@@ -36,9 +36,9 @@ func main() {
 	}
 
 	filename := opts["<runnerConfig>"].(string)
+	// Run logs any error itself, before sending buffered log messages
 	_, err = runner.Run(filename)
 	if err != nil {
-		log.Printf("%s", err)
 		os.Exit(1)
 	}
 }
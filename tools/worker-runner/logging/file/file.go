@@ -0,0 +1,338 @@
+package file
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/cfg"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/logging/logging"
+)
+
+// rotatedTimeFormat is the format of the timestamp appended to the names of
+// rotated files, chosen so that they sort in order of rotation
+const rotatedTimeFormat = "2006-01-02T15-04-05.000"
+
+// cleanupQueueSize is the number of rotated files that may be waiting to be
+// cleaned up; if more are rotated meanwhile, they are left uncompressed
+const cleanupQueueSize = 16
+
+type fileLoggingConfig struct {
+	Path       string
+	MaxSizeMB  int    `logging:"maxSizeMB,optional"`
+	MaxAge     string `logging:",optional"`
+	MaxBackups int    `logging:",optional"`
+	Compress   bool   `logging:",optional"`
+}
+
+type fileLogDestination struct {
+	mutex sync.Mutex
+
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+
+	// the current file, its size, and the time it was started
+	file    *os.File
+	size    int64
+	started time.Time
+
+	// compression and removal of rotated files happens in the background,
+	// one rotation at a time, so that writes do not wait for it.  Rotated
+	// files are queued on cleanup, and pending counts those not yet done.
+	cleanup chan string
+	pending sync.WaitGroup
+
+	// used to get the current time, overridden in tests
+	now func() time.Time
+
+	// used to compress rotated files, overridden in tests
+	gzipFile func(path string) error
+}
+
+func (dst *fileLogDestination) LogUnstructured(message string) {
+	dst.write(dst.now().UTC().Format(time.RFC3339) + " " + message + "\n")
+}
+
+func (dst *fileLogDestination) LogStructured(message map[string]interface{}) {
+	// include a timestamp without modifying the caller's message
+	if _, ok := message["timestamp"]; !ok {
+		withTimestamp := make(map[string]interface{}, len(message)+1)
+		for k, v := range message {
+			withTimestamp[k] = v
+		}
+		withTimestamp["timestamp"] = dst.now().UTC().Format(time.RFC3339)
+		message = withTimestamp
+	}
+	line, err := json.Marshal(message)
+	if err != nil {
+		// fall back to the unstructured form of the message
+		dst.LogUnstructured(logging.ToUnstructured(message))
+		return
+	}
+	dst.write(string(line) + "\n")
+}
+
+// write writes a line to the log file, first rotating the file if necessary.
+// Errors are reported on stderr, as there is nowhere else to log them.
+func (dst *fileLogDestination) write(line string) {
+	dst.mutex.Lock()
+	defer dst.mutex.Unlock()
+
+	if dst.shouldRotate(len(line)) {
+		if err := dst.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error rotating log file %s: %s\n", dst.path, err)
+		}
+	}
+	// if rotation failed, or the file could not be reopened after an earlier
+	// rotation, keep appending to the file at dst.path
+	if dst.file == nil {
+		if err := dst.open(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening log file %s: %s\n", dst.path, err)
+		}
+	}
+	if dst.file == nil {
+		fmt.Fprintf(os.Stderr, "%s", line)
+		return
+	}
+	n, err := io.WriteString(dst.file, line)
+	dst.size += int64(n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing to log file %s: %s\n%s", dst.path, err, line)
+	}
+}
+
+// shouldRotate determines whether the current file should be rotated before
+// writing the given number of bytes to it.  A file is never rotated while
+// empty, so a single line larger than the maximum size is still written.
+func (dst *fileLogDestination) shouldRotate(n int) bool {
+	if dst.file == nil || dst.size == 0 {
+		return false
+	}
+	if dst.maxSize > 0 && dst.size+int64(n) > dst.maxSize {
+		return true
+	}
+	return dst.maxAge > 0 && dst.now().Sub(dst.started) >= dst.maxAge
+}
+
+// open opens the log file, appending to it if it exists
+func (dst *fileLogDestination) open() error {
+	file, err := os.OpenFile(dst.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	dst.file = file
+	dst.size = info.Size()
+	dst.started = dst.now()
+	// an existing file was started no later than it was last modified
+	if dst.size > 0 && info.ModTime().Before(dst.started) {
+		dst.started = info.ModTime()
+	}
+	return nil
+}
+
+// rotate closes the current file and renames it with a timestamp suffix, then
+// compresses and removes rotated files in the background.  The caller opens a
+// new file, which is the current file again if the rename failed.
+func (dst *fileLogDestination) rotate() error {
+	err := dst.file.Close()
+	dst.file = nil
+	if err != nil {
+		return err
+	}
+
+	rotated := dst.path + "." + dst.now().UTC().Format(rotatedTimeFormat)
+	if err := os.Rename(dst.path, rotated); err != nil {
+		return err
+	}
+
+	dst.pending.Add(1)
+	select {
+	case dst.cleanup <- rotated:
+	default:
+		dst.pending.Done()
+		fmt.Fprintf(os.Stderr, "Too many rotated log files waiting to be cleaned up; not compressing %s\n", rotated)
+	}
+	return nil
+}
+
+// cleanUp compresses rotated files as they are queued, and removes old
+// files, until the queue is closed
+func (dst *fileLogDestination) cleanUp() {
+	for rotated := range dst.cleanup {
+		if dst.compress {
+			if err := dst.gzipFile(rotated); err != nil {
+				fmt.Fprintf(os.Stderr, "Error compressing log file %s: %s\n", rotated, err)
+			}
+		}
+		if err := dst.removeOldFiles(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing old log files: %s\n", err)
+		}
+		dst.pending.Done()
+	}
+}
+
+// removeOldFiles removes the oldest rotated files, beyond maxBackups
+func (dst *fileLogDestination) removeOldFiles() error {
+	if dst.maxBackups <= 0 {
+		return nil
+	}
+	rotated, err := rotatedFiles(dst.path)
+	if err != nil {
+		return err
+	}
+	for len(rotated) > dst.maxBackups {
+		if err := os.Remove(rotated[0]); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// rotatedFiles returns the paths of the rotated versions of the log file at
+// the given path, oldest first
+func rotatedFiles(path string) ([]string, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	rv := []string{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		suffix := strings.TrimSuffix(strings.TrimPrefix(name, base+"."), ".gz")
+		if _, err := time.Parse(rotatedTimeFormat, suffix); err != nil {
+			continue
+		}
+		rv = append(rv, filepath.Join(dir, name))
+	}
+	sort.Strings(rv)
+	return rv, nil
+}
+
+// compressFile replaces the file at the given path with a gzipped copy
+// having suffix .gz
+func compressFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+
+	dest, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = dest.Close()
+			_ = os.Remove(path + ".gz")
+		}
+	}()
+
+	gz := gzip.NewWriter(dest)
+	if _, err = io.Copy(gz, src); err != nil {
+		return
+	}
+	if err = gz.Close(); err != nil {
+		return
+	}
+	if err = dest.Close(); err != nil {
+		return
+	}
+	return os.Remove(path)
+}
+
+func New(runnercfg *cfg.RunnerConfig) (logging.Logger, error) {
+	// defaults, for properties that are not given
+	lc := fileLoggingConfig{
+		MaxSizeMB:  100,
+		MaxBackups: 5,
+		Compress:   true,
+	}
+	err := runnercfg.Logging.Unpack(&lc)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := &fileLogDestination{
+		path:       lc.Path,
+		maxSize:    int64(lc.MaxSizeMB) * 1024 * 1024,
+		maxBackups: lc.MaxBackups,
+		compress:   lc.Compress,
+		cleanup:    make(chan string, cleanupQueueSize),
+		now:        time.Now,
+		gzipFile:   compressFile,
+	}
+	if lc.MaxAge != "" {
+		dst.maxAge, err = time.ParseDuration(lc.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("Configuration value `logging.maxAge` is invalid: %s", err)
+		}
+	}
+
+	err = dst.open()
+	if err != nil {
+		return nil, err
+	}
+	go dst.cleanUp()
+	return dst, nil
+}
+
+func Usage() string {
+	return `
+
+The "file" logging writes to a file on local disk.  Structured messages are
+written as JSON objects, one per line, with a "timestamp" property added if
+not present; unstructured messages are written as text, prefixed with a
+timestamp.
+
+` + "```yaml" + `
+logging:
+	implementation: file
+	# path of the log file (required)
+	path: /var/log/worker-runner.log
+	# size at which the file is rotated, in MiB; 0 disables (default 100)
+	maxSizeMB: 100
+	# age at which the file is rotated, as a Go duration; if omitted, the
+	# file is only rotated by size (default omitted)
+	maxAge: 24h
+	# number of rotated files to keep; 0 keeps all (default 5)
+	maxBackups: 5
+	# compress rotated files with gzip (default true)
+	compress: true
+` + "```" + `
+
+Rotated files are renamed with a suffix giving the time of rotation, such as
+` + "`worker-runner.log.2020-05-04T13-22-18.417`" + `, and then compressed
+if configured.  If the file already exists when worker-runner starts, it is
+appended to.
+
+`
+}
@@ -0,0 +1,237 @@
+package file
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/cfg"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func makeLogger(t *testing.T, config string) (*fileLogDestination, string, func()) {
+	dir, err := ioutil.TempDir("", "worker-runner-file-logging")
+	require.NoError(t, err)
+	path := filepath.Join(dir, "runner.log")
+
+	var lc cfg.LoggingConfig
+	err = yaml.Unmarshal([]byte("implementation: file\npath: "+path+"\n"+config), &lc)
+	require.NoError(t, err)
+
+	logger, err := New(&cfg.RunnerConfig{Logging: &lc})
+	require.NoError(t, err)
+	dst := logger.(*fileLogDestination)
+
+	// use a fixed clock
+	now := time.Date(2020, 5, 4, 13, 22, 18, 0, time.UTC)
+	dst.now = func() time.Time { return now }
+	dst.started = now
+
+	return dst, path, func() {
+		waitForCleanup(dst)
+		_ = dst.file.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func readGzipFile(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(gz)
+	require.NoError(t, err)
+	return string(content)
+}
+
+// waitForCleanup waits until the background cleanup of rotated files is done
+func waitForCleanup(dst *fileLogDestination) {
+	dst.pending.Wait()
+}
+
+func TestLogUnstructured(t *testing.T) {
+	dst, path, cleanup := makeLogger(t, "")
+	defer cleanup()
+
+	dst.LogUnstructured("uhoh!")
+	require.Equal(t, "2020-05-04T13:22:18Z uhoh!\n", readFile(t, path))
+}
+
+func TestLogStructured(t *testing.T) {
+	dst, path, cleanup := makeLogger(t, "")
+	defer cleanup()
+
+	message := map[string]interface{}{"level": "bad"}
+	dst.LogStructured(message)
+	dst.LogStructured(map[string]interface{}{"timestamp": "then"})
+	require.Equal(t,
+		"{\"level\":\"bad\",\"timestamp\":\"2020-05-04T13:22:18Z\"}\n{\"timestamp\":\"then\"}\n",
+		readFile(t, path))
+	require.Equal(t, map[string]interface{}{"level": "bad"}, message, "message was modified")
+}
+
+func TestAppendsToExistingFile(t *testing.T) {
+	dst, path, cleanup := makeLogger(t, "")
+	defer cleanup()
+	dst.LogUnstructured("one")
+	_ = dst.file.Close()
+
+	require.NoError(t, dst.open())
+	dst.LogUnstructured("two")
+	require.Equal(t, "2020-05-04T13:22:18Z one\n2020-05-04T13:22:18Z two\n", readFile(t, path))
+}
+
+func TestRotateBySize(t *testing.T) {
+	dst, path, cleanup := makeLogger(t, "maxBackups: 2")
+	defer cleanup()
+	dst.maxSize = 64
+
+	line := strings.Repeat("x", 30)
+	for i := 0; i < 10; i++ {
+		dst.now = func() time.Time {
+			return time.Date(2020, 5, 4, 13, 22, i, 0, time.UTC)
+		}
+		dst.LogUnstructured(line)
+		waitForCleanup(dst)
+	}
+
+	// each file holds one line, since two lines would exceed the size
+	rotated, err := rotatedFiles(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		path + ".2020-05-04T13-22-08.000.gz",
+		path + ".2020-05-04T13-22-09.000.gz",
+	}, rotated)
+	require.Equal(t, "2020-05-04T13:22:07Z "+line+"\n", readGzipFile(t, rotated[0]))
+	require.Equal(t, "2020-05-04T13:22:08Z "+line+"\n", readGzipFile(t, rotated[1]))
+	require.Equal(t, "2020-05-04T13:22:09Z "+line+"\n", readFile(t, path))
+}
+
+func TestRotateByAge(t *testing.T) {
+	dst, path, cleanup := makeLogger(t, "maxAge: 1h\ncompress: false")
+	defer cleanup()
+	start := dst.now()
+
+	dst.LogUnstructured("one")
+	dst.now = func() time.Time { return start.Add(30 * time.Minute) }
+	dst.LogUnstructured("two")
+	dst.now = func() time.Time { return start.Add(60 * time.Minute) }
+	dst.LogUnstructured("three")
+	waitForCleanup(dst)
+
+	rotated, err := rotatedFiles(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{path + ".2020-05-04T14-22-18.000"}, rotated)
+	require.Equal(t, "2020-05-04T13:22:18Z one\n2020-05-04T13:52:18Z two\n", readFile(t, rotated[0]))
+	require.Equal(t, "2020-05-04T14:22:18Z three\n", readFile(t, path))
+}
+
+func TestRotateFails(t *testing.T) {
+	dst, path, cleanup := makeLogger(t, "")
+	defer cleanup()
+	dst.maxSize = 64
+
+	// a directory in place of the rotated file makes the rename fail
+	require.NoError(t, os.Mkdir(path+".2020-05-04T13-22-18.000", 0755))
+
+	line := strings.Repeat("x", 30)
+	for i := 0; i < 3; i++ {
+		dst.LogUnstructured(line)
+	}
+
+	// the lines are appended to the current file instead
+	require.Equal(t, strings.Repeat("2020-05-04T13:22:18Z "+line+"\n", 3), readFile(t, path))
+
+	// and once the rename succeeds, the file is rotated
+	dst.now = func() time.Time {
+		return time.Date(2020, 5, 4, 13, 22, 19, 0, time.UTC)
+	}
+	dst.LogUnstructured(line)
+	waitForCleanup(dst)
+	require.Equal(t, "2020-05-04T13:22:19Z "+line+"\n", readFile(t, path))
+	require.Equal(t, strings.Repeat("2020-05-04T13:22:18Z "+line+"\n", 3), readGzipFile(t, path+".2020-05-04T13-22-19.000.gz"))
+}
+
+func TestSlowCompression(t *testing.T) {
+	dst, path, cleanup := makeLogger(t, "")
+	defer cleanup()
+	dst.maxSize = 64
+
+	// compression of the first rotated file does not finish until released
+	started := make(chan struct{})
+	release := make(chan struct{})
+	dst.gzipFile = func(path string) error {
+		select {
+		case <-started:
+		default:
+			close(started)
+			<-release
+		}
+		return compressFile(path)
+	}
+
+	// each line is logged a second later, so each rotated file has a
+	// different name
+	line := strings.Repeat("x", 30)
+	logLine := func(i int) {
+		dst.now = func() time.Time {
+			return time.Date(2020, 5, 4, 13, 22, i, 0, time.UTC)
+		}
+		dst.LogUnstructured(line)
+	}
+	logLine(0)
+	logLine(1)
+	<-started
+
+	// writing and rotating continue while the compression is in progress
+	done := make(chan struct{})
+	go func() {
+		logLine(2)
+		logLine(3)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("logging blocked on compression of a rotated file")
+	}
+
+	close(release)
+	waitForCleanup(dst)
+	rotated, err := rotatedFiles(path)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(rotated))
+	for _, file := range rotated {
+		require.True(t, strings.HasSuffix(file, ".gz"), "%s is not compressed", file)
+	}
+}
+
+func TestMissingPath(t *testing.T) {
+	var lc cfg.LoggingConfig
+	err := yaml.Unmarshal([]byte("implementation: file"), &lc)
+	require.NoError(t, err)
+
+	_, err = New(&cfg.RunnerConfig{Logging: &lc})
+	require.Error(t, err)
+}
+
+func TestInvalidMaxAge(t *testing.T) {
+	var lc cfg.LoggingConfig
+	err := yaml.Unmarshal([]byte("implementation: file\npath: /tmp/x.log\nmaxAge: soon"), &lc)
+	require.NoError(t, err)
+
+	_, err = New(&cfg.RunnerConfig{Logging: &lc})
+	require.Error(t, err)
+}
@@ -0,0 +1,279 @@
+package httplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The file to which messages are appended until it is sealed as a batch
+const currentFile = "current.jsonl"
+
+// batchFile is a sealed batch of messages, waiting to be sent
+type batchFile struct {
+	path string
+	size int64
+}
+
+// diskBuffer holds messages on disk until they are sent.  Messages are
+// appended, one JSON object per line, to the current file, which is sealed as
+// a batch when it holds enough messages or on request.  Batches are sent
+// oldest first.  The total size of the files is bounded by dropping the oldest
+// batches when necessary.
+type diskBuffer struct {
+	mutex sync.Mutex
+
+	dir      string
+	maxSize  int64
+	maxBatch int
+
+	// the current file, the number of messages in it, and its size
+	current      *os.File
+	currentCount int
+	currentSize  int64
+
+	// sealed batches, oldest first, and the sequence number of the next
+	batches []batchFile
+	nextSeq uint64
+
+	// the number of messages dropped to bound the buffer's size, which have
+	// not yet been noted in the buffer
+	dropped int
+
+	// receives a value when a batch is sealed
+	ready chan struct{}
+
+	// used to get the current time, overridden in tests
+	now func() time.Time
+}
+
+// newDiskBuffer creates a buffer in the given directory, creating it if
+// necessary.  Batches left in the directory, such as by a previous run of
+// worker-runner, are kept, to be sent first.
+func newDiskBuffer(dir string, maxSize int64, maxBatch int) (*diskBuffer, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	b := &diskBuffer{
+		dir:      dir,
+		maxSize:  maxSize,
+		maxBatch: maxBatch,
+		ready:    make(chan struct{}, 1),
+		now:      time.Now,
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		seq, ok := batchSeq(info.Name())
+		if !ok {
+			continue
+		}
+		b.batches = append(b.batches, batchFile{filepath.Join(dir, info.Name()), info.Size()})
+		if seq >= b.nextSeq {
+			b.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(b.batches, func(i, j int) bool { return b.batches[i].path < b.batches[j].path })
+
+	err = b.openCurrent()
+	if err != nil {
+		return nil, err
+	}
+	// any messages already in the current file form a batch of their own
+	if b.currentSize > 0 {
+		err = b.seal()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// batchSeq returns the sequence number of the batch file with the given name
+func batchSeq(name string) (uint64, bool) {
+	if !strings.HasPrefix(name, "batch-") || !strings.HasSuffix(name, ".jsonl") {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "batch-"), ".jsonl"), 10, 64)
+	return seq, err == nil
+}
+
+func (b *diskBuffer) openCurrent() error {
+	file, err := os.OpenFile(filepath.Join(b.dir, currentFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	b.current = file
+	b.currentCount = 0
+	b.currentSize = info.Size()
+	return nil
+}
+
+// size returns the total size of the buffer's files
+func (b *diskBuffer) size() int64 {
+	size := b.currentSize
+	for _, batch := range b.batches {
+		size += batch.size
+	}
+	return size
+}
+
+// add adds a message to the buffer, dropping the oldest batches if necessary
+// to make room for it.  If there is still no room, the message is dropped.
+func (b *diskBuffer) add(message map[string]interface{}) error {
+	line, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.dropped > 0 {
+		notice, _ := json.Marshal(map[string]interface{}{
+			"textPayload": fmt.Sprintf("%d log messages were dropped, as the log buffer was full", b.dropped),
+			"timestamp":   b.now().UTC().Format(time.RFC3339),
+		})
+		if b.makeRoom(len(notice) + 1) {
+			b.dropped = 0
+			if err := b.write(notice); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !b.makeRoom(len(line) + 1) {
+		b.dropped++
+		return nil
+	}
+	return b.write(line)
+}
+
+// makeRoom drops the oldest batches until there is room for the given number
+// of bytes, returning false if that is not possible.  This must be called with
+// the mutex held.
+func (b *diskBuffer) makeRoom(n int) bool {
+	size := b.size()
+	for size+int64(n) > b.maxSize && len(b.batches) > 0 {
+		oldest := b.batches[0]
+		b.dropped += countLines(oldest.path)
+		_ = os.Remove(oldest.path)
+		b.batches = b.batches[1:]
+		size -= oldest.size
+	}
+	return size+int64(n) <= b.maxSize
+}
+
+// write appends a line to the current file, sealing it if it is full.  This
+// must be called with the mutex held.
+func (b *diskBuffer) write(line []byte) error {
+	n, err := b.current.Write(append(line, '\n'))
+	b.currentSize += int64(n)
+	if err != nil {
+		return err
+	}
+	b.currentCount++
+	if b.currentCount >= b.maxBatch {
+		return b.seal()
+	}
+	return nil
+}
+
+// flush seals the current file as a batch, if it contains any messages
+func (b *diskBuffer) flush() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.currentSize == 0 {
+		return nil
+	}
+	return b.seal()
+}
+
+// seal renames the current file as the newest batch, and starts a new current
+// file.  This must be called with the mutex held.
+func (b *diskBuffer) seal() error {
+	err := b.current.Close()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(b.dir, fmt.Sprintf("batch-%020d.jsonl", b.nextSeq))
+	err = os.Rename(filepath.Join(b.dir, currentFile), path)
+	if err != nil {
+		return err
+	}
+	b.batches = append(b.batches, batchFile{path, b.currentSize})
+	b.nextSeq++
+
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+	return b.openCurrent()
+}
+
+// oldest returns the oldest sealed batch, if there is one
+func (b *diskBuffer) oldest() (batchFile, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if len(b.batches) == 0 {
+		return batchFile{}, false
+	}
+	return b.batches[0], true
+}
+
+// remove removes a batch which has been sent, if it was not already dropped
+func (b *diskBuffer) remove(batch batchFile) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for i, bf := range b.batches {
+		if bf.path == batch.path {
+			_ = os.Remove(batch.path)
+			b.batches = append(b.batches[:i], b.batches[i+1:]...)
+			return
+		}
+	}
+}
+
+// readBatch reads the messages in a batch file, as JSON objects.  Lines that
+// are not valid JSON, such as one partially written before a crash, are
+// skipped.
+func readBatch(path string) ([]json.RawMessage, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	messages := []json.RawMessage{}
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if len(line) == 0 || !json.Valid(line) {
+			continue
+		}
+		messages = append(messages, json.RawMessage(line))
+	}
+	return messages, nil
+}
+
+// countLines counts the lines in a file, returning 0 if it cannot be read
+func countLines(path string) int {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	return bytes.Count(content, []byte("\n"))
+}
@@ -0,0 +1,357 @@
+package httplog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/cfg"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/logging/logging"
+)
+
+type httpLoggingConfig struct {
+	URL           string                 `logging:"url"`
+	BufferDir     string                 `logging:"bufferDir"`
+	MaxBufferMB   int                    `logging:"maxBufferMB,optional"`
+	MaxBatchSize  int                    `logging:",optional"`
+	FlushInterval string                 `logging:",optional"`
+	MaxRetryDelay string                 `logging:",optional"`
+	Gzip          bool                   `logging:",optional"`
+	Headers       map[string]interface{} `logging:",optional"`
+}
+
+type httpLogDestination struct {
+	url     string
+	headers map[string]string
+	gzip    bool
+	client  *http.Client
+	buffer  *diskBuffer
+
+	flushInterval     time.Duration
+	initialRetryDelay time.Duration
+	maxRetryDelay     time.Duration
+
+	// closed to stop the background goroutines, each of which then sends to
+	// done
+	stop chan struct{}
+	done chan struct{}
+
+	// signalled by Flush to retry sending without waiting for the backoff
+	// delay, and by sendBatches each time a batch is removed from the buffer
+	retryNow chan struct{}
+	removed  chan struct{}
+}
+
+func (dst *httpLogDestination) LogUnstructured(message string) {
+	dst.add(logging.ToStructured(message))
+}
+
+func (dst *httpLogDestination) LogStructured(message map[string]interface{}) {
+	dst.add(message)
+}
+
+// add adds a message to the buffer, with a timestamp if it does not have one.
+// Errors are reported on stderr, as there is nowhere else to log them.
+func (dst *httpLogDestination) add(message map[string]interface{}) {
+	if _, ok := message["timestamp"]; !ok {
+		withTimestamp := make(map[string]interface{}, len(message)+1)
+		for k, v := range message {
+			withTimestamp[k] = v
+		}
+		withTimestamp["timestamp"] = dst.buffer.now().UTC().Format(time.RFC3339)
+		message = withTimestamp
+	}
+	err := dst.buffer.add(message)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error buffering log message: %s\n%s\n", err, logging.ToUnstructured(message))
+	}
+}
+
+// start starts sending batches in the background
+func (dst *httpLogDestination) start() {
+	go dst.flushPeriodically()
+	go dst.sendBatches()
+}
+
+// Flush seals the current batch and waits until all batches have been sent,
+// or until the timeout expires.  Batches that were not sent remain in the
+// buffer.
+//
+// This is part of the logging.Flusher interface.
+func (dst *httpLogDestination) Flush(timeout time.Duration) error {
+	if err := dst.buffer.flush(); err != nil {
+		return err
+	}
+	select {
+	case dst.retryNow <- struct{}{}:
+	default:
+	}
+
+	deadline := time.After(timeout)
+	for {
+		if _, ok := dst.buffer.oldest(); !ok {
+			return nil
+		}
+		select {
+		case <-dst.removed:
+		case <-deadline:
+			return fmt.Errorf("Timed out sending logs to %s; unsent logs remain in the buffer", dst.url)
+		}
+	}
+}
+
+// close stops sending batches, leaving any that were not sent in the buffer
+func (dst *httpLogDestination) close() {
+	close(dst.stop)
+	<-dst.done
+	<-dst.done
+	_ = dst.buffer.current.Close()
+}
+
+// flushPeriodically seals the current batch every flushInterval, so that
+// messages are sent even if they arrive slowly
+func (dst *httpLogDestination) flushPeriodically() {
+	defer func() { dst.done <- struct{}{} }()
+	ticker := time.NewTicker(dst.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-dst.stop:
+			return
+		case <-ticker.C:
+		}
+		if err := dst.buffer.flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error flushing log buffer: %s\n", err)
+		}
+	}
+}
+
+// sendBatches sends batches, oldest first, as they become ready.  Batches
+// are retried with exponential backoff until the collector accepts them.
+func (dst *httpLogDestination) sendBatches() {
+	defer func() { dst.done <- struct{}{} }()
+
+	retry := backoff.NewExponentialBackOff()
+	retry.InitialInterval = dst.initialRetryDelay
+	retry.MaxInterval = dst.maxRetryDelay
+	// retry forever
+	retry.MaxElapsedTime = 0
+
+	for {
+		batch, ok := dst.buffer.oldest()
+		if !ok {
+			select {
+			case <-dst.stop:
+				return
+			case <-dst.buffer.ready:
+				continue
+			}
+		}
+
+		retryable, err := dst.send(batch)
+		if err == nil || !retryable {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Dropping log batch rejected by %s: %s\n", dst.url, err)
+			}
+			dst.buffer.remove(batch)
+			select {
+			case dst.removed <- struct{}{}:
+			default:
+			}
+			retry.Reset()
+			continue
+		}
+
+		delay := retry.NextBackOff()
+		fmt.Fprintf(os.Stderr, "Error sending logs to %s (retrying in %s): %s\n", dst.url, delay, err)
+		select {
+		case <-dst.stop:
+			return
+		case <-dst.retryNow:
+		case <-time.After(delay):
+		}
+	}
+}
+
+// send sends a batch to the collector, as a JSON array of messages.  If it
+// fails, this returns whether it should be retried.
+func (dst *httpLogDestination) send(batch batchFile) (bool, error) {
+	messages, err := readBatch(batch.path)
+	if err != nil {
+		// the batch may have been dropped while being read
+		return false, err
+	}
+	if len(messages) == 0 {
+		return false, nil
+	}
+	body, err := json.Marshal(messages)
+	if err != nil {
+		return false, err
+	}
+
+	var reader io.Reader = bytes.NewReader(body)
+	if dst.gzip {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		if _, err = gz.Write(body); err != nil {
+			return false, err
+		}
+		if err = gz.Close(); err != nil {
+			return false, err
+		}
+		reader = &compressed
+	}
+
+	req, err := http.NewRequest("POST", dst.url, reader)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if dst.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range dst.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := dst.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	_ = res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return true, fmt.Errorf("HTTP status %s", res.Status)
+	default:
+		return false, fmt.Errorf("HTTP status %s", res.Status)
+	}
+}
+
+// newHTTPLogDestination creates a destination from the given configuration,
+// without starting it
+func newHTTPLogDestination(lc httpLoggingConfig) (*httpLogDestination, error) {
+	flushInterval, err := time.ParseDuration(lc.FlushInterval)
+	if err != nil {
+		return nil, fmt.Errorf("Configuration value `logging.flushInterval` is invalid: %s", err)
+	}
+	if flushInterval <= 0 {
+		return nil, fmt.Errorf("Configuration value `logging.flushInterval` must be positive")
+	}
+	maxRetryDelay, err := time.ParseDuration(lc.MaxRetryDelay)
+	if err != nil {
+		return nil, fmt.Errorf("Configuration value `logging.maxRetryDelay` is invalid: %s", err)
+	}
+	if lc.MaxBatchSize < 1 {
+		return nil, fmt.Errorf("Configuration value `logging.maxBatchSize` must be at least 1")
+	}
+	headers := make(map[string]string, len(lc.Headers))
+	for k, v := range lc.Headers {
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("Configuration value `logging.headers.%s` should be a string", k)
+		}
+		headers[k] = str
+	}
+
+	buffer, err := newDiskBuffer(lc.BufferDir, int64(lc.MaxBufferMB)*1024*1024, lc.MaxBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create log buffer: %s", err)
+	}
+
+	return &httpLogDestination{
+		url:               lc.URL,
+		headers:           headers,
+		gzip:              lc.Gzip,
+		client:            &http.Client{Timeout: 30 * time.Second},
+		buffer:            buffer,
+		flushInterval:     flushInterval,
+		initialRetryDelay: time.Second,
+		maxRetryDelay:     maxRetryDelay,
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}, 2),
+		retryNow:          make(chan struct{}, 1),
+		removed:           make(chan struct{}, 1),
+	}, nil
+}
+
+// configure creates a destination from the runner configuration, without
+// starting it
+func configure(runnercfg *cfg.RunnerConfig) (*httpLogDestination, error) {
+	// defaults, for properties that are not given
+	lc := httpLoggingConfig{
+		MaxBufferMB:   100,
+		MaxBatchSize:  1000,
+		FlushInterval: "5s",
+		MaxRetryDelay: "5m",
+		Gzip:          true,
+	}
+	err := runnercfg.Logging.Unpack(&lc)
+	if err != nil {
+		return nil, err
+	}
+	return newHTTPLogDestination(lc)
+}
+
+func New(runnercfg *cfg.RunnerConfig) (logging.Logger, error) {
+	dst, err := configure(runnercfg)
+	if err != nil {
+		return nil, err
+	}
+	dst.start()
+	return dst, nil
+}
+
+func Usage() string {
+	return `
+
+The "http" logging sends messages in batches to a log collector over HTTP,
+such as the HTTP input of Fluent Bit.  Each batch is sent as the body of a
+POST request, containing a JSON array of messages.  Unstructured messages are
+sent as objects with a ` + "`textPayload`" + ` property, and all messages are given a
+` + "`timestamp`" + ` property if they do not have one.
+
+` + "```yaml" + `
+logging:
+	implementation: http
+	# URL to which batches are sent (required)
+	url: https://logs.example.com/worker-runner
+	# directory in which messages are buffered until sent (required)
+	bufferDir: /var/cache/worker-runner/logs
+	# limit on the size of the buffer, in MiB (default 100)
+	maxBufferMB: 100
+	# maximum number of messages in a batch (default 1000)
+	maxBatchSize: 1000
+	# interval at which a batch is sent, even if not full (default 5s)
+	flushInterval: 5s
+	# maximum delay between attempts to send a batch (default 5m)
+	maxRetryDelay: 5m
+	# compress batches with gzip, using Content-Encoding (default true)
+	gzip: true
+	# additional headers for each request, such as for authentication
+	headers:
+		Authorization: Bearer s3kr1t
+` + "```" + `
+
+Messages are written to the buffer directory as they are logged, so they are
+not lost if the collector is unavailable.  Batches which fail with a network
+error or with a 429 or 5xx response are retried, with exponential backoff,
+until the collector accepts them; batches rejected with any other response are
+dropped.  If the buffer reaches its size limit, the oldest batches are dropped
+to make room, and a message noting the number of messages dropped is sent in
+their place.  When worker-runner exits, it waits briefly for the buffered
+messages to be sent; batches still left in the buffer directory are sent when
+it next starts.
+
+`
+}
@@ -0,0 +1,290 @@
+package httplog
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/cfg"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// fakeCollector records the batches it receives, failing requests with the
+// given statuses first
+type fakeCollector struct {
+	m        sync.Mutex
+	server   *httptest.Server
+	statuses []int
+	batches  [][]map[string]interface{}
+	received chan struct{}
+}
+
+func newFakeCollector(t *testing.T, statuses ...int) *fakeCollector {
+	c := &fakeCollector{statuses: statuses, received: make(chan struct{}, 100)}
+	c.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.m.Lock()
+		defer c.m.Unlock()
+		if len(c.statuses) > 0 {
+			w.WriteHeader(c.statuses[0])
+			c.statuses = c.statuses[1:]
+			return
+		}
+
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			body = gz
+		}
+		var batch []map[string]interface{}
+		if err := json.NewDecoder(body).Decode(&batch); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		c.batches = append(c.batches, batch)
+		c.received <- struct{}{}
+	}))
+	return c
+}
+
+// wait waits until the collector has received the given number of batches,
+// and returns all of the batches received
+func (c *fakeCollector) wait(t *testing.T, n int) [][]map[string]interface{} {
+	for i := 0; i < n; i++ {
+		select {
+		case <-c.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("collector received only %d batches", i)
+		}
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.batches
+}
+
+func makeLogger(t *testing.T, url, dir string) *httpLogDestination {
+	var lc cfg.LoggingConfig
+	err := yaml.Unmarshal([]byte("implementation: http\nurl: "+url+"\nbufferDir: "+dir+"\nmaxBatchSize: 3\nflushInterval: 10ms"), &lc)
+	require.NoError(t, err)
+
+	dst, err := configure(&cfg.RunnerConfig{Logging: &lc})
+	require.NoError(t, err)
+	dst.initialRetryDelay = 10 * time.Millisecond
+	dst.start()
+	return dst
+}
+
+func makeDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "worker-runner-httplog")
+	require.NoError(t, err)
+	return dir
+}
+
+func texts(batches [][]map[string]interface{}) []string {
+	rv := []string{}
+	for _, batch := range batches {
+		for _, message := range batch {
+			rv = append(rv, message["textPayload"].(string))
+		}
+	}
+	return rv
+}
+
+func TestSendBatches(t *testing.T) {
+	collector := newFakeCollector(t)
+	defer collector.server.Close()
+	dir := makeDir(t)
+	defer os.RemoveAll(dir)
+
+	dst := makeLogger(t, collector.server.URL, dir)
+	defer dst.close()
+
+	dst.LogUnstructured("one")
+	dst.LogStructured(map[string]interface{}{"textPayload": "two", "level": "error"})
+	dst.LogUnstructured("three")
+	dst.LogUnstructured("four")
+
+	batches := collector.wait(t, 2)
+	require.Equal(t, 3, len(batches[0]))
+	require.Equal(t, []string{"one", "two", "three", "four"}, texts(batches))
+	require.Equal(t, "error", batches[0][1]["level"])
+	require.Contains(t, batches[0][0], "timestamp")
+}
+
+func TestRetry(t *testing.T) {
+	collector := newFakeCollector(t, 503, 429, 500)
+	defer collector.server.Close()
+	dir := makeDir(t)
+	defer os.RemoveAll(dir)
+
+	dst := makeLogger(t, collector.server.URL, dir)
+	defer dst.close()
+
+	dst.LogUnstructured("one")
+	dst.LogUnstructured("two")
+	dst.LogUnstructured("three")
+
+	require.Equal(t, []string{"one", "two", "three"}, texts(collector.wait(t, 1)))
+}
+
+func TestRejectedBatch(t *testing.T) {
+	collector := newFakeCollector(t, 400)
+	defer collector.server.Close()
+	dir := makeDir(t)
+	defer os.RemoveAll(dir)
+
+	dst := makeLogger(t, collector.server.URL, dir)
+	defer dst.close()
+
+	for _, msg := range []string{"one", "two", "three", "four", "five", "six"} {
+		dst.LogUnstructured(msg)
+	}
+
+	require.Equal(t, []string{"four", "five", "six"}, texts(collector.wait(t, 1)))
+}
+
+func TestResendAfterRestart(t *testing.T) {
+	collector := newFakeCollector(t)
+	defer collector.server.Close()
+	dir := makeDir(t)
+	defer os.RemoveAll(dir)
+
+	// the collector is unreachable at first
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	dst := makeLogger(t, down.URL, dir)
+	for _, msg := range []string{"one", "two", "three", "four"} {
+		dst.LogUnstructured(msg)
+	}
+	dst.close()
+
+	dst = makeLogger(t, collector.server.URL, dir)
+	defer dst.close()
+
+	require.Equal(t, []string{"one", "two", "three", "four"}, texts(collector.wait(t, 2)))
+}
+
+func TestFlush(t *testing.T) {
+	collector := newFakeCollector(t, 503)
+	defer collector.server.Close()
+	dir := makeDir(t)
+	defer os.RemoveAll(dir)
+
+	// messages are not otherwise flushed, and the failed first attempt is
+	// not otherwise retried, during the test
+	var lc cfg.LoggingConfig
+	err := yaml.Unmarshal([]byte("implementation: http\nurl: "+collector.server.URL+"\nbufferDir: "+dir+"\nflushInterval: 1h"), &lc)
+	require.NoError(t, err)
+	dst, err := configure(&cfg.RunnerConfig{Logging: &lc})
+	require.NoError(t, err)
+	dst.initialRetryDelay = time.Hour
+	dst.start()
+	defer dst.close()
+
+	dst.LogUnstructured("one")
+	dst.LogUnstructured("two")
+	require.NoError(t, dst.Flush(5*time.Second))
+
+	collector.m.Lock()
+	defer collector.m.Unlock()
+	require.Equal(t, []string{"one", "two"}, texts(collector.batches))
+}
+
+func TestFlushTimeout(t *testing.T) {
+	dir := makeDir(t)
+	defer os.RemoveAll(dir)
+
+	// the collector is unreachable
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	dst := makeLogger(t, down.URL, dir)
+	defer dst.close()
+
+	dst.LogUnstructured("one")
+	require.Error(t, dst.Flush(50*time.Millisecond))
+
+	// the message remains in the buffer, to be sent when next started
+	_, ok := dst.buffer.oldest()
+	require.True(t, ok)
+}
+
+func TestBufferLimit(t *testing.T) {
+	dir := makeDir(t)
+	defer os.RemoveAll(dir)
+
+	buffer, err := newDiskBuffer(dir, 1024, 3)
+	require.NoError(t, err)
+	defer buffer.current.Close()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, buffer.add(map[string]interface{}{"textPayload": "message", "i": i}))
+		require.True(t, buffer.size() <= 1024)
+	}
+	require.NoError(t, buffer.flush())
+
+	// the oldest messages were dropped, and notices added in their place
+	messages := []map[string]interface{}{}
+	for _, batch := range buffer.batches {
+		lines, err := readBatch(batch.path)
+		require.NoError(t, err)
+		for _, line := range lines {
+			var message map[string]interface{}
+			require.NoError(t, json.Unmarshal(line, &message))
+			messages = append(messages, message)
+		}
+	}
+	notices := 0
+	for _, message := range messages {
+		if message["textPayload"] != "message" {
+			require.Contains(t, message["textPayload"], "log messages were dropped")
+			notices++
+		}
+	}
+	require.NotEqual(t, 0, notices)
+	require.Equal(t, float64(99), messages[len(messages)-1]["i"])
+}
+
+func TestPartialLine(t *testing.T) {
+	dir := makeDir(t)
+	defer os.RemoveAll(dir)
+
+	// a crash left a partially-written message in the current file
+	err := ioutil.WriteFile(dir+"/"+currentFile, []byte("{\"textPayload\":\"one\"}\n{\"textPay"), 0600)
+	require.NoError(t, err)
+
+	buffer, err := newDiskBuffer(dir, 1024, 3)
+	require.NoError(t, err)
+	defer buffer.current.Close()
+
+	batch, ok := buffer.oldest()
+	require.True(t, ok)
+	lines, err := readBatch(batch.path)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(lines))
+	require.Equal(t, "{\"textPayload\":\"one\"}", string(lines[0]))
+}
+
+func TestBadConfig(t *testing.T) {
+	for _, config := range []string{
+		"url: http://localhost",
+		"url: http://localhost\nbufferDir: /tmp/x\nflushInterval: often",
+		"url: http://localhost\nbufferDir: /tmp/x\nheaders:\n  Authorization: 1",
+	} {
+		var lc cfg.LoggingConfig
+		err := yaml.Unmarshal([]byte("implementation: http\n"+config), &lc)
+		require.NoError(t, err)
+		_, err = New(&cfg.RunnerConfig{Logging: &lc})
+		require.Error(t, err, config)
+	}
+}
@@ -0,0 +1,189 @@
+package journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/cfg"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/logging/logging"
+)
+
+// The socket on which journald receives messages in its native protocol
+const defaultSocket = "/run/systemd/journal/socket"
+
+// Fields which are set by this implementation, and which the fields of
+// structured messages cannot override
+var reservedFields = map[string]bool{
+	"MESSAGE":           true,
+	"PRIORITY":          true,
+	"SYSLOG_IDENTIFIER": true,
+}
+
+type journaldLoggingConfig struct {
+	SyslogIdentifier string `logging:"syslogIdentifier,optional"`
+	Socket           string `logging:",optional"`
+}
+
+type journaldLogDestination struct {
+	mutex sync.Mutex
+
+	identifier string
+	conn       *net.UnixConn
+	addr       *net.UnixAddr
+}
+
+func (dst *journaldLogDestination) LogUnstructured(message string) {
+	dst.send(message, logging.SeverityInfo, nil)
+}
+
+func (dst *journaldLogDestination) LogStructured(message map[string]interface{}) {
+	text, ok := message["textPayload"].(string)
+	fields := make(map[string]interface{}, len(message))
+	for k, v := range message {
+		if k == "textPayload" && ok {
+			continue
+		}
+		fields[k] = v
+	}
+	if !ok {
+		text = logging.ToUnstructured(message)
+	}
+	dst.send(text, logging.Severity(message), fields)
+}
+
+// send sends a message to journald.  Errors are reported on stderr, as there
+// is nowhere else to log them.
+func (dst *journaldLogDestination) send(text string, severity int, fields map[string]interface{}) {
+	payload := dst.serialize(text, severity, fields)
+
+	dst.mutex.Lock()
+	defer dst.mutex.Unlock()
+	err := write(dst.conn, dst.addr, payload)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error sending to journald: %s\n%s\n", err, text)
+	}
+}
+
+// serialize encodes a message in journald's native protocol
+func (dst *journaldLogDestination) serialize(text string, severity int, fields map[string]interface{}) []byte {
+	var buf bytes.Buffer
+	appendField(&buf, "MESSAGE", text)
+	appendField(&buf, "PRIORITY", fmt.Sprintf("%d", severity))
+	appendField(&buf, "SYSLOG_IDENTIFIER", dst.identifier)
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := fieldName(k)
+		if name == "" {
+			continue
+		}
+		appendField(&buf, name, fieldValue(fields[k]))
+	}
+	return buf.Bytes()
+}
+
+// appendField appends a field to a serialized message.  Values containing
+// newlines are given as a little-endian 64-bit length followed by the value.
+func appendField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(name + "=" + value + "\n")
+		return
+	}
+	buf.WriteString(name + "\n")
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
+
+// fieldName converts the name of a field of a structured message to a journal
+// field name, which consists of at most 64 uppercase letters, digits, and
+// underscores, and does not start with a digit or an underscore.  It returns
+// an empty string if there is no such name.
+func fieldName(name string) string {
+	rv := []byte(strings.ToUpper(name))
+	for i, c := range rv {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			rv[i] = '_'
+		}
+	}
+	name = strings.TrimLeft(string(rv), "_")
+	if name == "" {
+		return ""
+	}
+	if reservedFields[name] || (name[0] >= '0' && name[0] <= '9') {
+		name = "X_" + name
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// fieldValue converts the value of a field of a structured message to a
+// string
+func fieldValue(value interface{}) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+	j, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%#v", value)
+	}
+	return string(j)
+}
+
+func New(runnercfg *cfg.RunnerConfig) (logging.Logger, error) {
+	// defaults, for properties that are not given
+	lc := journaldLoggingConfig{
+		SyslogIdentifier: "worker-runner",
+		Socket:           defaultSocket,
+	}
+	err := runnercfg.Logging.Unpack(&lc)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := &net.UnixAddr{Name: lc.Socket, Net: "unixgram"}
+	conn, err := dial(addr)
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to journald: %s", err)
+	}
+	return &journaldLogDestination{identifier: lc.SyslogIdentifier, conn: conn, addr: addr}, nil
+}
+
+func Usage() string {
+	return `
+
+The "journald" logging sends messages to the systemd journal, using its native
+protocol.  It is only available on Linux.
+
+` + "```yaml" + `
+logging:
+	implementation: journald
+	# SYSLOG_IDENTIFIER of each message (default worker-runner)
+	syslogIdentifier: worker-runner
+	# path of journald's socket (default /run/systemd/journal/socket)
+	socket: /run/systemd/journal/socket
+` + "```" + `
+
+The fields of structured messages are sent as journal fields, with names
+converted to uppercase and characters other than letters, digits, and
+underscores replaced with underscores.  Names which would begin with a digit,
+or which are among those set by worker-runner, are prefixed with ` + "`X_`" + `.
+The ` + "`MESSAGE`" + ` field is the message's ` + "`textPayload`" + `, if it has one, or
+otherwise a textual form of all of its fields.  The ` + "`PRIORITY`" + ` of
+structured messages is taken from their ` + "`level`" + ` field, if it is a level
+such as ` + "`error`" + ` or ` + "`warning`" + `, and is otherwise informational.
+
+`
+}
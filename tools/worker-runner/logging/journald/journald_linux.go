@@ -0,0 +1,59 @@
+package journald
+
+import (
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// dial creates a socket from which to send messages to journald at the given
+// address.  The socket is not connected, as passing files over a connected
+// socket is not supported.
+func dial(addr *net.UnixAddr) (*net.UnixConn, error) {
+	if _, err := os.Stat(addr.Name); err != nil {
+		return nil, err
+	}
+	return net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+}
+
+// write sends a serialized message.  Messages too large to send as a single
+// datagram are written to a sealed memfd, which is passed to journald
+// instead.
+func write(conn *net.UnixConn, addr *net.UnixAddr, payload []byte) error {
+	_, err := conn.WriteToUnix(payload, addr)
+	if err == nil || !isTooLarge(err) {
+		return err
+	}
+
+	fd, err := unix.MemfdCreate("journal-message", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return err
+	}
+	file := os.NewFile(uintptr(fd), "journal-message")
+	defer file.Close()
+
+	_, err = file.Write(payload)
+	if err != nil {
+		return err
+	}
+	_, err = unix.FcntlInt(file.Fd(), unix.F_ADD_SEALS, unix.F_SEAL_SHRINK|unix.F_SEAL_GROW|unix.F_SEAL_WRITE|unix.F_SEAL_SEAL)
+	if err != nil {
+		return err
+	}
+	_, _, err = conn.WriteMsgUnix(nil, unix.UnixRights(int(file.Fd())), addr)
+	return err
+}
+
+// isTooLarge determines whether an error from writing a datagram indicates
+// that it was too large
+func isTooLarge(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.EMSGSIZE || err == syscall.ENOBUFS
+}
@@ -0,0 +1,18 @@
+// +build !linux
+
+package journald
+
+import (
+	"errors"
+	"net"
+)
+
+var errNotSupported = errors.New("journald logging is only supported on Linux")
+
+func dial(addr *net.UnixAddr) (*net.UnixConn, error) {
+	return nil, errNotSupported
+}
+
+func write(conn *net.UnixConn, addr *net.UnixAddr, payload []byte) error {
+	return errNotSupported
+}
@@ -0,0 +1,144 @@
+// +build linux
+
+package journald
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/cfg"
+	"golang.org/x/sys/unix"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// fakeJournal listens on a socket as journald does
+type fakeJournal struct {
+	dir      string
+	socket   string
+	listener *net.UnixConn
+}
+
+func newFakeJournal(t *testing.T) *fakeJournal {
+	dir, err := ioutil.TempDir("", "worker-runner-journald")
+	require.NoError(t, err)
+	socket := filepath.Join(dir, "socket")
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	return &fakeJournal{dir, socket, listener}
+}
+
+func (j *fakeJournal) close() {
+	_ = j.listener.Close()
+	_ = os.RemoveAll(j.dir)
+}
+
+// receive receives a message, reading it from a passed file if necessary
+func (j *fakeJournal) receive(t *testing.T) []byte {
+	buf := make([]byte, 65536)
+	oob := make([]byte, 1024)
+	require.NoError(t, j.listener.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, oobn, _, _, err := j.listener.ReadMsgUnix(buf, oob)
+	require.NoError(t, err)
+	if oobn == 0 {
+		return buf[:n]
+	}
+
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	require.NoError(t, err)
+	require.Equal(t, 1, len(msgs))
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	require.NoError(t, err)
+	require.Equal(t, 1, len(fds))
+	file := os.NewFile(uintptr(fds[0]), "passed")
+	defer file.Close()
+	// journald reads the file from the start, regardless of its offset
+	_, err = file.Seek(0, 0)
+	require.NoError(t, err)
+	payload, err := ioutil.ReadAll(file)
+	require.NoError(t, err)
+	return payload
+}
+
+func makeLogger(t *testing.T, j *fakeJournal) *journaldLogDestination {
+	var lc cfg.LoggingConfig
+	err := yaml.Unmarshal([]byte("implementation: journald\nsocket: "+j.socket), &lc)
+	require.NoError(t, err)
+
+	logger, err := New(&cfg.RunnerConfig{Logging: &lc})
+	require.NoError(t, err)
+	return logger.(*journaldLogDestination)
+}
+
+func TestLogUnstructured(t *testing.T) {
+	j := newFakeJournal(t)
+	defer j.close()
+	dst := makeLogger(t, j)
+
+	dst.LogUnstructured("uhoh!")
+	require.Equal(t,
+		"MESSAGE=uhoh!\nPRIORITY=6\nSYSLOG_IDENTIFIER=worker-runner\n",
+		string(j.receive(t)))
+}
+
+func TestLogStructured(t *testing.T) {
+	j := newFakeJournal(t)
+	defer j.close()
+	dst := makeLogger(t, j)
+
+	dst.LogStructured(map[string]interface{}{
+		"textPayload": "uhoh!",
+		"level":       "warning",
+		"taskId":      "abc",
+		"priority":    "high",
+		"2nd":         []int{1, 2},
+		"_private":    true,
+	})
+	require.Equal(t,
+		"MESSAGE=uhoh!\nPRIORITY=4\nSYSLOG_IDENTIFIER=worker-runner\n"+
+			"X_2ND=[1,2]\nPRIVATE=true\nLEVEL=warning\nX_PRIORITY=high\nTASKID=abc\n",
+		string(j.receive(t)))
+}
+
+func TestMultilineField(t *testing.T) {
+	j := newFakeJournal(t)
+	defer j.close()
+	dst := makeLogger(t, j)
+
+	dst.LogUnstructured("two\nlines")
+	payload := j.receive(t)
+	prefix := "MESSAGE\n"
+	require.True(t, strings.HasPrefix(string(payload), prefix))
+	require.Equal(t, uint64(9), binary.LittleEndian.Uint64(payload[len(prefix):]))
+	require.Equal(t,
+		"two\nlines\nPRIORITY=6\nSYSLOG_IDENTIFIER=worker-runner\n",
+		string(payload[len(prefix)+8:]))
+}
+
+func TestLargeMessage(t *testing.T) {
+	j := newFakeJournal(t)
+	defer j.close()
+	dst := makeLogger(t, j)
+
+	// this is larger than the maximum size of a datagram
+	large := strings.Repeat("x", 8*1024*1024)
+	dst.LogUnstructured(large)
+	require.Equal(t,
+		"MESSAGE="+large+"\nPRIORITY=6\nSYSLOG_IDENTIFIER=worker-runner\n",
+		string(j.receive(t)))
+}
+
+func TestNoJournal(t *testing.T) {
+	var lc cfg.LoggingConfig
+	err := yaml.Unmarshal([]byte("implementation: journald\nsocket: /no/such/socket"), &lc)
+	require.NoError(t, err)
+
+	_, err = New(&cfg.RunnerConfig{Logging: &lc})
+	require.Error(t, err)
+}
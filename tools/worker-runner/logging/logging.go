@@ -7,10 +7,15 @@ import (
 	"log"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/cfg"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/logging/file"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/logging/httplog"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/logging/journald"
 	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/logging/logging"
 	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/logging/stdio"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/logging/syslog"
 )
 
 var Destination logging.Logger
@@ -22,27 +27,47 @@ func init() {
 }
 
 type implInfo struct {
-	constructor func(*cfg.RunnerConfig) logging.Logger
+	constructor func(*cfg.RunnerConfig) (logging.Logger, error)
 	usage       func() string
 }
 
 var implementations map[string]implInfo = map[string]implInfo{
-	"stdio": implInfo{stdio.New, stdio.Usage},
+	"file":     implInfo{file.New, file.Usage},
+	"http":     implInfo{httplog.New, httplog.Usage},
+	"journald": implInfo{journald.New, journald.Usage},
+	"stdio": implInfo{func(runnercfg *cfg.RunnerConfig) (logging.Logger, error) {
+		return stdio.New(runnercfg), nil
+	}, stdio.Usage},
+	"syslog": implInfo{syslog.New, syslog.Usage},
 }
 
-func Configure(runnercfg *cfg.RunnerConfig) {
+func Configure(runnercfg *cfg.RunnerConfig) error {
 	if runnercfg.Logging == nil || runnercfg.Logging.Implementation == "" {
 		// just stick with the built-in stdio logging
-		return
+		return nil
 	}
 	impl := runnercfg.Logging.Implementation
 
 	li, ok := implementations[impl]
 	if !ok {
 		log.Printf("Unrecognized logging implementation %s (falling back to stdio)", impl)
-		return
+		return nil
 	}
-	Destination = li.constructor(runnercfg)
+	dst, err := li.constructor(runnercfg)
+	if err != nil {
+		return err
+	}
+	Destination = dst
+	return nil
+}
+
+// Flush sends any messages buffered by the Destination, waiting no longer than
+// the given timeout.  This should be called before worker-runner exits.
+func Flush(timeout time.Duration) error {
+	if flusher, ok := Destination.(logging.Flusher); ok {
+		return flusher.Flush(timeout)
+	}
+	return nil
 }
 
 func Usage() string {
@@ -1,5 +1,7 @@
 package logging
 
+import "time"
+
 // A Logger handles sending log output to an appropriate place, per user
 // configuration.  It must handle both structured (arbitrary JSON) and
 // unstructured (plain text) inputs, and can produce whatever format is
@@ -11,3 +13,10 @@ type Logger interface {
 	// Log a structured message.
 	LogStructured(message map[string]interface{})
 }
+
+// A Flusher is a Logger that buffers messages before sending them, and can
+// send any buffered messages before worker-runner exits.
+type Flusher interface {
+	// Send any buffered messages, waiting no longer than the given timeout.
+	Flush(timeout time.Duration) error
+}
@@ -0,0 +1,49 @@
+package logging
+
+import "strings"
+
+// Message severities, as defined by syslog (RFC 5424) and also used by
+// journald
+const (
+	SeverityEmergency = 0
+	SeverityAlert     = 1
+	SeverityCritical  = 2
+	SeverityError     = 3
+	SeverityWarning   = 4
+	SeverityNotice    = 5
+	SeverityInfo      = 6
+	SeverityDebug     = 7
+)
+
+var severities = map[string]int{
+	"emerg":     SeverityEmergency,
+	"emergency": SeverityEmergency,
+	"panic":     SeverityEmergency,
+	"alert":     SeverityAlert,
+	"crit":      SeverityCritical,
+	"critical":  SeverityCritical,
+	"fatal":     SeverityCritical,
+	"err":       SeverityError,
+	"error":     SeverityError,
+	"warn":      SeverityWarning,
+	"warning":   SeverityWarning,
+	"notice":    SeverityNotice,
+	"info":      SeverityInfo,
+	"debug":     SeverityDebug,
+	"trace":     SeverityDebug,
+}
+
+// Determine the severity of a structured message from its `level` property,
+// if that is a recognized level name such as "error" or "warning".  Messages
+// without a recognized level have SeverityInfo.
+func Severity(message map[string]interface{}) int {
+	level, ok := message["level"].(string)
+	if !ok {
+		return SeverityInfo
+	}
+	severity, ok := severities[strings.ToLower(level)]
+	if !ok {
+		return SeverityInfo
+	}
+	return severity
+}
@@ -0,0 +1,15 @@
+package logging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeverity(t *testing.T) {
+	require.Equal(t, SeverityInfo, Severity(map[string]interface{}{}))
+	require.Equal(t, SeverityError, Severity(map[string]interface{}{"level": "error"}))
+	require.Equal(t, SeverityWarning, Severity(map[string]interface{}{"level": "WARN"}))
+	require.Equal(t, SeverityInfo, Severity(map[string]interface{}{"level": "bad"}))
+	require.Equal(t, SeverityInfo, Severity(map[string]interface{}{"level": 3}))
+}
@@ -0,0 +1,337 @@
+package syslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/cfg"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/logging/logging"
+)
+
+// The SD-ID of the structured data element containing the fields of
+// structured messages.  Taskcluster has no private enterprise number, so this
+// uses the number reserved for documentation by RFC 5612.
+const sdID = "fields@32473"
+
+// The timestamp format of RFC 5424, with microsecond precision
+const timestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// Local syslog sockets, in the order they are tried
+var localAddresses = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+type syslogLoggingConfig struct {
+	Network  string `logging:",optional"`
+	Address  string `logging:",optional"`
+	Facility string `logging:",optional"`
+	AppName  string `logging:"appName,optional"`
+	Hostname string `logging:",optional"`
+}
+
+type syslogLogDestination struct {
+	mutex sync.Mutex
+
+	network  string
+	address  string
+	facility int
+	appName  string
+	hostname string
+	procID   string
+
+	// the current connection, and the network it uses; this is nil if not
+	// yet connected, or if the connection failed
+	conn     net.Conn
+	connType string
+
+	// used to get the current time, overridden in tests
+	now func() time.Time
+}
+
+func (dst *syslogLogDestination) LogUnstructured(message string) {
+	dst.send(dst.format(logging.SeverityInfo, message, nil))
+}
+
+func (dst *syslogLogDestination) LogStructured(message map[string]interface{}) {
+	text, ok := message["textPayload"].(string)
+	fields := make(map[string]interface{}, len(message))
+	for k, v := range message {
+		if k == "textPayload" && ok {
+			continue
+		}
+		fields[k] = v
+	}
+	if !ok {
+		text = logging.ToUnstructured(message)
+	}
+	dst.send(dst.format(logging.Severity(message), text, fields))
+}
+
+// format formats a message as described in RFC 5424
+func (dst *syslogLogDestination) format(severity int, text string, fields map[string]interface{}) string {
+	return fmt.Sprintf("<%d>1 %s %s %s %s - %s %s",
+		dst.facility*8+severity,
+		dst.now().Format(timestampFormat),
+		dst.hostname,
+		dst.appName,
+		dst.procID,
+		structuredData(fields),
+		text)
+}
+
+// structuredData formats the fields of a structured message as an element of
+// structured data, or "-" if there are none
+func structuredData(fields map[string]interface{}) string {
+	if len(fields) == 0 {
+		return "-"
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sd strings.Builder
+	sd.WriteString("[" + sdID)
+	for _, k := range keys {
+		fmt.Fprintf(&sd, " %s=\"%s\"", paramName(k), paramValue(fields[k]))
+	}
+	sd.WriteString("]")
+	return sd.String()
+}
+
+// paramName converts a field name to a valid SD-NAME, which is at most 32
+// printable ASCII characters other than '=', ' ', ']' and '"'
+func paramName(name string) string {
+	rv := []byte(name)
+	if len(rv) > 32 {
+		rv = rv[:32]
+	}
+	for i, c := range rv {
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			rv[i] = '_'
+		}
+	}
+	if len(rv) == 0 {
+		return "_"
+	}
+	return string(rv)
+}
+
+// paramValue converts a field value to a PARAM-VALUE, escaping the characters
+// which must be escaped
+func paramValue(value interface{}) string {
+	str, ok := value.(string)
+	if !ok {
+		j, err := json.Marshal(value)
+		if err != nil {
+			str = fmt.Sprintf("%#v", value)
+		} else {
+			str = string(j)
+		}
+	}
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(str)
+}
+
+// headerField converts a value to a valid header field of at most the given
+// length, which has no spaces and is "-" if empty
+func headerField(value string, length int) string {
+	rv := []byte(value)
+	if len(rv) > length {
+		rv = rv[:length]
+	}
+	for i, c := range rv {
+		if c <= ' ' || c > '~' {
+			rv[i] = '_'
+		}
+	}
+	if len(rv) == 0 {
+		return "-"
+	}
+	return string(rv)
+}
+
+// send sends a formatted message, connecting first if necessary.  A failed
+// send is retried once over a new connection.  Errors are reported on
+// stderr, as there is nowhere else to log them.
+func (dst *syslogLogDestination) send(msg string) {
+	dst.mutex.Lock()
+	defer dst.mutex.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if dst.conn == nil {
+			err = dst.connect()
+			if err != nil {
+				continue
+			}
+		}
+		err = dst.write(msg)
+		if err == nil {
+			return
+		}
+		_ = dst.conn.Close()
+		dst.conn = nil
+	}
+	fmt.Fprintf(os.Stderr, "Error sending to syslog: %s\n%s\n", err, msg)
+}
+
+// connect connects to the syslog server
+func (dst *syslogLogDestination) connect() error {
+	switch dst.network {
+	case "unix":
+		// local syslog daemons may listen for datagrams or streams
+		var err error
+		addresses := localAddresses
+		if dst.address != "" {
+			addresses = []string{dst.address}
+		}
+		for _, address := range addresses {
+			for _, network := range []string{"unixgram", "unix"} {
+				var conn net.Conn
+				conn, err = net.Dial(network, address)
+				if err == nil {
+					dst.conn, dst.connType = conn, network
+					return nil
+				}
+			}
+		}
+		return err
+	default:
+		conn, err := net.DialTimeout(dst.network, dst.address, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		dst.conn, dst.connType = conn, dst.network
+		return nil
+	}
+}
+
+// write writes a message to the current connection, framed as appropriate
+// for its network
+func (dst *syslogLogDestination) write(msg string) error {
+	var framed string
+	switch dst.connType {
+	case "tcp":
+		// octet counting, as described in RFC 6587
+		framed = fmt.Sprintf("%d %s", len(msg), msg)
+	case "unix":
+		// local syslog daemons expect newline-terminated messages on streams
+		framed = msg + "\n"
+	default:
+		// one message per datagram
+		framed = msg
+	}
+	_, err := dst.conn.Write([]byte(framed))
+	return err
+}
+
+func New(runnercfg *cfg.RunnerConfig) (logging.Logger, error) {
+	// defaults, for properties that are not given
+	lc := syslogLoggingConfig{
+		Network:  "unix",
+		Facility: "daemon",
+		AppName:  "worker-runner",
+	}
+	err := runnercfg.Logging.Unpack(&lc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch lc.Network {
+	case "unix":
+	case "udp", "tcp":
+		if lc.Address == "" {
+			return nil, fmt.Errorf("Configuration value `logging.address` is required for network %s", lc.Network)
+		}
+	default:
+		return nil, fmt.Errorf("Configuration value `logging.network` must be one of unix, udp, or tcp; got %s", lc.Network)
+	}
+
+	facility, ok := facilities[lc.Facility]
+	if !ok {
+		return nil, fmt.Errorf("Configuration value `logging.facility` is not a syslog facility: %s", lc.Facility)
+	}
+
+	if lc.Hostname == "" {
+		lc.Hostname, _ = os.Hostname()
+	}
+
+	dst := &syslogLogDestination{
+		network:  lc.Network,
+		address:  lc.Address,
+		facility: facility,
+		appName:  headerField(lc.AppName, 48),
+		hostname: headerField(lc.Hostname, 255),
+		procID:   fmt.Sprintf("%d", os.Getpid()),
+		now:      time.Now,
+	}
+
+	// connect now, so that configuration errors are found at startup
+	err = dst.connect()
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to syslog: %s", err)
+	}
+	return dst, nil
+}
+
+func Usage() string {
+	return `
+
+The "syslog" logging sends messages to a syslog server in the format described
+in RFC 5424.  Messages are sent over a local socket, UDP, or TCP (using the
+octet-counting framing of RFC 6587).
+
+` + "```yaml" + `
+logging:
+	implementation: syslog
+	# one of unix, udp, or tcp (default unix)
+	network: udp
+	# address of the syslog server, as host:port for udp and tcp, or the path
+	# of a socket for unix; for unix, this defaults to the first of /dev/log,
+	# /var/run/syslog, and /var/run/log that is available
+	address: syslog.example.com:514
+	# syslog facility (default daemon)
+	facility: local0
+	# APP-NAME of each message (default worker-runner)
+	appName: worker-runner
+	# HOSTNAME of each message (default is the host's name)
+	hostname: my-worker
+` + "```" + `
+
+The fields of structured messages are sent as the parameters of a structured
+data element with SD-ID ` + "`fields@32473`" + `.  The message text is the message's
+` + "`textPayload`" + `, if it has one, or otherwise a textual form of all of its
+fields.  The severity of structured messages is taken from their ` + "`level`" + `
+field, if it is a level such as ` + "`error`" + ` or ` + "`warning`" + `, and is otherwise
+informational.
+
+`
+}
@@ -0,0 +1,172 @@
+package syslog
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/cfg"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func makeLogger(t *testing.T, config string) (*syslogLogDestination, error) {
+	var lc cfg.LoggingConfig
+	err := yaml.Unmarshal([]byte("implementation: syslog\nhostname: my-host\n"+config), &lc)
+	require.NoError(t, err)
+
+	logger, err := New(&cfg.RunnerConfig{Logging: &lc})
+	if err != nil {
+		return nil, err
+	}
+	dst := logger.(*syslogLogDestination)
+
+	// use a fixed clock and process ID
+	dst.now = func() time.Time { return time.Date(2020, 5, 4, 13, 22, 18, 417000000, time.UTC) }
+	dst.procID = "1234"
+	return dst, nil
+}
+
+func TestFormat(t *testing.T) {
+	dst := &syslogLogDestination{
+		facility: 16,
+		appName:  "worker-runner",
+		hostname: "my-host",
+		procID:   "1234",
+		now:      func() time.Time { return time.Date(2020, 5, 4, 13, 22, 18, 417000000, time.UTC) },
+	}
+
+	t.Run("unstructured", func(t *testing.T) {
+		require.Equal(t,
+			"<134>1 2020-05-04T13:22:18.417000Z my-host worker-runner 1234 - - uhoh!",
+			dst.format(6, "uhoh!", nil))
+	})
+	t.Run("structured", func(t *testing.T) {
+		require.Equal(t,
+			`<131>1 2020-05-04T13:22:18.417000Z my-host worker-runner 1234 - [fields@32473 a="1" b="x\"y\]z" bad_name="[\]"] uhoh!`,
+			dst.format(3, "uhoh!", map[string]interface{}{"b": `x"y]z`, "a": 1, "bad name": []int{}}))
+	})
+}
+
+func TestHeaderField(t *testing.T) {
+	require.Equal(t, "-", headerField("", 48))
+	require.Equal(t, "my_host", headerField("my host", 48))
+	require.Equal(t, "abc", headerField("abcdef", 3))
+}
+
+func TestUDP(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	dst, err := makeLogger(t, "network: udp\naddress: "+listener.LocalAddr().String())
+	require.NoError(t, err)
+
+	dst.LogStructured(map[string]interface{}{"textPayload": "uhoh!", "level": "error"})
+
+	buf := make([]byte, 1024)
+	require.NoError(t, listener.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := listener.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t,
+		`<27>1 2020-05-04T13:22:18.417000Z my-host worker-runner 1234 - [fields@32473 level="error"] uhoh!`,
+		string(buf[:n]))
+}
+
+func TestTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	dst, err := makeLogger(t, "network: tcp\nfacility: local0\naddress: "+listener.Addr().String())
+	require.NoError(t, err)
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	dst.LogUnstructured("one")
+	dst.LogStructured(map[string]interface{}{"a": "b"})
+
+	one := "<134>1 2020-05-04T13:22:18.417000Z my-host worker-runner 1234 - - one"
+	two := `<134>1 2020-05-04T13:22:18.417000Z my-host worker-runner 1234 - [fields@32473 a="b"] a: b`
+	expected := fmt.Sprintf("%d %s%d %s", len(one), one, len(two), two)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, len(expected))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(buf))
+}
+
+func TestUnixDatagram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-runner-syslog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+
+	listener, err := net.ListenPacket("unixgram", path)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	dst, err := makeLogger(t, "address: "+path)
+	require.NoError(t, err)
+	require.Equal(t, "unixgram", dst.connType)
+
+	dst.LogUnstructured("uhoh!")
+
+	buf := make([]byte, 1024)
+	require.NoError(t, listener.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := listener.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "<30>1 2020-05-04T13:22:18.417000Z my-host worker-runner 1234 - - uhoh!", string(buf[:n]))
+}
+
+func TestReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	dst, err := makeLogger(t, "network: tcp\naddress: "+listener.Addr().String())
+	require.NoError(t, err)
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	_ = conn.Close()
+
+	// writes to the closed connection eventually fail, and the message is
+	// then sent over a new connection
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msg, _ := ioutil.ReadAll(conn)
+		received <- string(msg)
+	}()
+	for i := 0; i < 10; i++ {
+		dst.LogUnstructured("uhoh!")
+		time.Sleep(10 * time.Millisecond)
+	}
+	_ = dst.conn.Close()
+
+	select {
+	case msg := <-received:
+		require.Contains(t, msg, " uhoh!")
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not reconnect")
+	}
+}
+
+func TestBadConfig(t *testing.T) {
+	_, err := makeLogger(t, "network: udp")
+	require.Error(t, err)
+	_, err = makeLogger(t, "network: carrier-pigeon")
+	require.Error(t, err)
+	_, err = makeLogger(t, "network: udp\naddress: 127.0.0.1:514\nfacility: nope")
+	require.Error(t, err)
+}
@@ -2,6 +2,7 @@ package logging
 
 import (
 	"sync"
+	"time"
 
 	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/logging/logging"
 )
@@ -9,6 +10,7 @@ import (
 type TestLogDestination struct {
 	mutex    sync.Mutex
 	messages []map[string]interface{}
+	flushes  int
 }
 
 func (dst *TestLogDestination) Messages() []map[string]interface{} {
@@ -18,6 +20,20 @@ func (dst *TestLogDestination) Messages() []map[string]interface{} {
 	return messages
 }
 
+// Flushes returns the number of times Flush has been called
+func (dst *TestLogDestination) Flushes() int {
+	dst.mutex.Lock()
+	defer dst.mutex.Unlock()
+	return dst.flushes
+}
+
+func (dst *TestLogDestination) Flush(timeout time.Duration) error {
+	dst.mutex.Lock()
+	dst.flushes++
+	dst.mutex.Unlock()
+	return nil
+}
+
 func (dst *TestLogDestination) Clear() {
 	dst.mutex.Lock()
 	dst.messages = []map[string]interface{}{}
@@ -132,9 +132,6 @@ func (p *AWSProvider) checkTerminationTime() bool {
 }
 
 func (p *AWSProvider) WorkerStarted(state *run.State) error {
-	// start polling for graceful shutdown
-	p.terminationTicker = time.NewTicker(30 * time.Second)
-
 	p.proto.Register("shutdown", func(msg workerproto.Message) {
 		if err := provider.RemoveWorker(state, p.workerManagerClientFactory); err != nil {
 			log.Printf("Shutdown error: %v\n", err)
@@ -143,13 +140,18 @@ func (p *AWSProvider) WorkerStarted(state *run.State) error {
 	p.proto.AddCapability("shutdown")
 	p.proto.AddCapability("graceful-termination")
 
-	go func() {
-		for {
-			<-p.terminationTicker.C
-			log.Println("polling for termination-time")
-			p.checkTerminationTime()
-		}
-	}()
+	// start polling for graceful shutdown, unless already polling for a
+	// previous start of the worker
+	if p.terminationTicker == nil {
+		p.terminationTicker = time.NewTicker(30 * time.Second)
+		go func() {
+			for {
+				<-p.terminationTicker.C
+				log.Println("polling for termination-time")
+				p.checkTerminationTime()
+			}
+		}()
+	}
 
 	return nil
 }
@@ -165,18 +165,21 @@ func (p *AzureProvider) WorkerStarted(state *run.State) error {
 	p.proto.AddCapability("shutdown")
 	p.proto.AddCapability("graceful-termination")
 
-	// start polling for graceful shutdown
-	p.terminationTicker = time.NewTicker(30 * time.Second)
-	go func() {
-		for {
-			<-p.terminationTicker.C
-			log.Println("polling for termination-time")
-			// NOTE: the first call to this method may take up to 120s:
-			// https://docs.microsoft.com/en-us/azure/virtual-machines/linux/scheduled-events#enabling-and-disabling-scheduled-events
-			// that may lead to a "backlog" of checks, but that won't do any real harm.
-			p.checkTerminationTime()
-		}
-	}()
+	// start polling for graceful shutdown, unless already polling for a
+	// previous start of the worker
+	if p.terminationTicker == nil {
+		p.terminationTicker = time.NewTicker(30 * time.Second)
+		go func() {
+			for {
+				<-p.terminationTicker.C
+				log.Println("polling for termination-time")
+				// NOTE: the first call to this method may take up to 120s:
+				// https://docs.microsoft.com/en-us/azure/virtual-machines/linux/scheduled-events#enabling-and-disabling-scheduled-events
+				// that may lead to a "backlog" of checks, but that won't do any real harm.
+				p.checkTerminationTime()
+			}
+		}()
+	}
 
 	return nil
 }
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"time"
 
 	"github.com/taskcluster/taskcluster/v30/internal/workerproto"
 	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/cfg"
@@ -15,13 +16,30 @@ import (
 	loggingProtocol "github.com/taskcluster/taskcluster/v30/tools/worker-runner/logging/protocol"
 	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/perms"
 	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/provider"
+	providerIface "github.com/taskcluster/taskcluster/v30/tools/worker-runner/provider/provider"
 	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/run"
 	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/secrets"
 	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/worker"
+	workerIface "github.com/taskcluster/taskcluster/v30/tools/worker-runner/worker/worker"
 )
 
+// logFlushTimeout is the time allowed for sending buffered log messages
+// before exiting
+const logFlushTimeout = 30 * time.Second
+
 // Run the worker.  This embodies the execution of the start-worker command.
+// Any error is logged, and buffered log messages sent, before returning.
 func Run(configFile string) (state run.State, err error) {
+	defer func() {
+		if err != nil {
+			log.Printf("%s", err)
+		}
+		// there is nowhere else to report a failure to send the logs
+		if flushErr := logging.Flush(logFlushTimeout); flushErr != nil {
+			fmt.Fprintf(os.Stderr, "Error sending buffered log messages: %s\n", flushErr)
+		}
+	}()
+
 	// load configuration
 
 	log.Printf("Loading worker-runner configuration from %s", configFile)
@@ -31,7 +49,11 @@ func Run(configFile string) (state run.State, err error) {
 		return
 	}
 
-	logging.Configure(runnercfg)
+	err = logging.Configure(runnercfg)
+	if err != nil {
+		err = fmt.Errorf("Error configuring logging: %s", err)
+		return
+	}
 
 	runCached := false
 	if runnercfg.CacheOverRestarts != "" {
@@ -59,76 +81,127 @@ func Run(configFile string) (state run.State, err error) {
 
 	state.WorkerConfig = state.WorkerConfig.Merge(runnercfg.WorkerConfig)
 
-	// initialize provider
+	// initialize provider and worker
 
 	provider, err := provider.New(runnercfg)
 	if err != nil {
 		return
 	}
 
+	worker, err := worker.New(runnercfg)
+	if err != nil {
+		return
+	}
+
 	if !runCached {
-		log.Printf("Configuring with provider %s", runnercfg.Provider.ProviderType)
-		err = provider.ConfigureRun(&state)
+		err = configureRun(runnercfg, provider, worker, &state)
 		if err != nil {
 			return
 		}
 	} else {
-		err = provider.UseCachedRun(&state)
+		err = useCachedRun(provider, worker, &state)
 		if err != nil {
 			return
 		}
 	}
 
-	err = state.CheckProviderResults()
+	// handle credential expiratoin
+	ce := credexp.New(&state)
+
+	// run the worker, restarting it as configured if it fails
+
+	for restarts := 0; ; restarts++ {
+		err = runWorker(provider, worker, ce, &state)
+		if err == nil {
+			break
+		}
+
+		code, ok := exitCode(err)
+		if !ok || !runnercfg.Supervision.ShouldRestart(code, restarts) {
+			return
+		}
+
+		err = ce.WorkerFinished()
+		if err != nil {
+			return
+		}
+
+		// registration with worker-manager is one-shot, so the worker cannot
+		// be restarted if the credentials will not last long enough to be
+		// useful to it
+		if !credentialsValid(&state) {
+			err = fmt.Errorf("Worker exited with code %d, and cannot be restarted as its credentials expire at %s",
+				code, state.CredentialsExpire)
+			return
+		}
+
+		delay := runnercfg.Supervision.RestartDelay(restarts)
+		log.Printf("Worker exited with code %d; restarting in %s (restart %d of at most %d)",
+			code, delay, restarts+1, runnercfg.Supervision.MaxRestarts)
+		time.Sleep(delay)
+	}
+
+	// shut things down
+
+	err = provider.WorkerFinished(&state)
+	if err != nil {
+		return
+	}
+
+	err = ce.WorkerFinished()
 	if err != nil {
 		return
 	}
 
+	return
+}
+
+// Configure a new run, with the provider, the secrets service, and the worker
+func configureRun(runnercfg *cfg.RunnerConfig, provider providerIface.Provider, worker workerIface.Worker, state *run.State) error {
+	log.Printf("Configuring with provider %s", runnercfg.Provider.ProviderType)
+	err := provider.ConfigureRun(state)
+	if err != nil {
+		return err
+	}
+
+	err = state.CheckProviderResults()
+	if err != nil {
+		return err
+	}
+
 	// log the worker identity; this is useful for finding the worker in logfiles
 	log.Printf("Identified as worker %s/%s", state.WorkerGroup, state.WorkerID)
 
 	// fetch secrets
 
-	if !runCached && runnercfg.GetSecrets {
+	if runnercfg.GetSecrets {
 		log.Println("Getting secrets from secrets service")
-		err = secrets.ConfigureRun(runnercfg, &state)
+		err = secrets.ConfigureRun(runnercfg, state)
 		if err != nil {
-			return
+			return err
 		}
 	}
 
-	// initialize worker
+	// configure worker
 
-	worker, err := worker.New(runnercfg)
+	log.Printf("Configuring for worker implementation %s", runnercfg.WorkerImplementation.Implementation)
+	err = worker.ConfigureRun(state)
 	if err != nil {
-		return
-	}
-
-	if !runCached {
-		log.Printf("Configuring for worker implementation %s", runnercfg.WorkerImplementation.Implementation)
-		err = worker.ConfigureRun(&state)
-		if err != nil {
-			return
-		}
-	} else {
-		err = worker.UseCachedRun(&state)
-		if err != nil {
-			return
-		}
+		return err
 	}
 
 	// cache the state if we might end up restarting
 
-	if !runCached && runnercfg.CacheOverRestarts != "" {
+	if runnercfg.CacheOverRestarts != "" {
 		log.Printf("Caching runnercfg at %s", runnercfg.CacheOverRestarts)
 		var encoded []byte
-		encoded, err = json.Marshal(&state)
+		encoded, err = json.Marshal(state)
 		if err != nil {
-			return
+			return err
 		}
 		err = ioutil.WriteFile(runnercfg.CacheOverRestarts, encoded, 0700)
 		if err != nil {
-			return
+			return err
 		}
 
 		// This file contains secrets, so ensure that this is really only
@@ -136,34 +209,45 @@ func Run(configFile string) (state run.State, err error) {
 		// should be the current user).
 		err = perms.MakePrivateToOwner(runnercfg.CacheOverRestarts)
 		if err != nil {
-			return
+			return err
 		}
 
 		err = perms.VerifyPrivateToOwner(runnercfg.CacheOverRestarts)
 		if err != nil {
-			return
+			return err
 		}
 	}
 
 	// extract files
 
-	if !runCached {
-		log.Printf("Writing files")
-		err = files.ExtractAll(state.Files)
-		if err != nil {
-			return
-		}
+	log.Printf("Writing files")
+	return files.ExtractAll(state.Files)
+}
+
+// Recover a run from cached state
+func useCachedRun(provider providerIface.Provider, worker workerIface.Worker, state *run.State) error {
+	err := provider.UseCachedRun(state)
+	if err != nil {
+		return err
 	}
 
-	// handle credential expiratoin
-	ce := credexp.New(&state)
+	err = state.CheckProviderResults()
+	if err != nil {
+		return err
+	}
 
-	// start
+	// log the worker identity; this is useful for finding the worker in logfiles
+	log.Printf("Identified as worker %s/%s", state.WorkerGroup, state.WorkerID)
 
+	return worker.UseCachedRun(state)
+}
+
+// Start the worker and wait for it to terminate
+func runWorker(provider providerIface.Provider, worker workerIface.Worker, ce *credexp.CredExp, state *run.State) error {
 	log.Printf("Starting worker")
-	transp, err := worker.StartWorker(&state)
+	transp, err := worker.StartWorker(state)
 	if err != nil {
-		return
+		return err
 	}
 
 	// set up protocol
@@ -180,12 +264,12 @@ func Run(configFile string) (state run.State, err error) {
 	// are no race conditions around the capabilities negotiation
 	err = ce.WorkerStarted()
 	if err != nil {
-		return
+		return err
 	}
 
-	err = provider.WorkerStarted(&state)
+	err = provider.WorkerStarted(state)
 	if err != nil {
-		return
+		return err
 	}
 
 	proto.Start(false)
@@ -193,22 +277,5 @@ func Run(configFile string) (state run.State, err error) {
 	// wait for the worker to terminate, first reading everything from the
 	// protocol to capture any output just before the process exited
 	proto.WaitForEOF()
-	err = worker.Wait()
-	if err != nil {
-		return
-	}
-
-	// shut things down
-
-	err = provider.WorkerFinished(&state)
-	if err != nil {
-		return
-	}
-
-	err = ce.WorkerFinished()
-	if err != nil {
-		return
-	}
-
-	return
+	return worker.Wait()
 }
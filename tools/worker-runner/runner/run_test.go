@@ -1,6 +1,7 @@
 package runner
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os/exec"
@@ -11,13 +12,16 @@ import (
 
 	"github.com/Flaque/filet"
 	"github.com/stretchr/testify/require"
+	taskcluster "github.com/taskcluster/taskcluster/v30/clients/client-go"
 	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/logging"
 	loggingCommon "github.com/taskcluster/taskcluster/v30/tools/worker-runner/logging/logging"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/perms"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/run"
 )
 
 func buildFakeGenericWorker(workerPath string) error {
 	cmd := exec.Command("go")
-	// fake.go just exits 0
+	// fake.go exits 0, or with the codes in `fakeExitCodesFile` if given
 	cmd.Args = append(cmd.Args, "build", "-o", workerPath, "../worker/genericworker/fake")
 	return cmd.Run()
 }
@@ -74,6 +78,9 @@ worker:
 		map[string]interface{}{"conversationLevel": "low", "textPayload": "workin hard or hardly workin, amirite?"},
 	}, loggingDestination.Messages())
 
+	// buffered log messages were sent before returning
+	require.Equal(t, 1, loggingDestination.Flushes())
+
 	// sleep a short bit to let NTFS figure out that fake.exe isn't in use anymore
 	// and it's safe to delete
 	if runtime.GOOS == "windows" {
@@ -81,6 +88,141 @@ worker:
 	}
 }
 
+// Run the fake generic worker with the given supervision configuration,
+// exiting with the given codes on successive runs.  If credentialsExpire is
+// not zero, the run is started from cached state with credentials expiring
+// at that time.
+func runSupervisedFakeGenericWorker(t *testing.T, supervision string, exitCodes string, credentialsExpire time.Time) error {
+	defer filet.CleanUp(t)
+	dir := filet.TmpDir(t, "")
+	workerPath := filepath.Join(dir, "fake.exe")
+	configPath := filepath.Join(dir, "runner.yaml")
+	workerConfigPath := filepath.Join(dir, "worker.yaml")
+	exitCodesPath := filepath.Join(dir, "exit-codes")
+	cachePath := filepath.Join(dir, "cache.json")
+
+	require.NoError(t, buildFakeGenericWorker(workerPath))
+	require.NoError(t, ioutil.WriteFile(exitCodesPath, []byte(exitCodes), 0644))
+
+	cacheConfig := ""
+	if !credentialsExpire.IsZero() {
+		cached, err := json.Marshal(run.State{
+			RootURL:           "https://tc.example.com",
+			Credentials:       taskcluster.Credentials{ClientID: "fake", AccessToken: "fake"},
+			CredentialsExpire: credentialsExpire,
+			WorkerPoolID:      "pp/ww",
+			WorkerGroup:       "wg",
+			WorkerID:          "wi",
+			WorkerLocation:    map[string]string{"cloud": "standalone"},
+		})
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(cachePath, cached, 0600))
+		require.NoError(t, perms.MakePrivateToOwner(cachePath))
+		cacheConfig = "cacheOverRestarts: " + cachePath
+	}
+
+	configData := fmt.Sprintf(`
+provider:
+  providerType: standalone
+  rootURL: https://tc.example.com
+  clientID: fake
+  accessToken: fake
+  workerPoolID: pp/ww
+  workerGroup: wg
+  workerID: wi
+getSecrets: false
+worker:
+  implementation: generic-worker
+  configPath: %s
+  path: %s
+workerConfig:
+  fakeExitCodesFile: %s
+supervision:
+%s
+%s
+`, workerConfigPath, workerPath, exitCodesPath, supervision, cacheConfig)
+
+	err := ioutil.WriteFile(configPath, []byte(configData), 0755)
+	require.NoError(t, err)
+
+	_, err = Run(configPath)
+
+	if runtime.GOOS == "windows" {
+		time.Sleep(5 * time.Second)
+	}
+	return err
+}
+
+func TestSupervisionRestarts(t *testing.T) {
+	loggingDestination := setupLogging()
+	defer teardownLogging()
+
+	err := runSupervisedFakeGenericWorker(t, `
+  restartOnExitCodes: [69, 72]
+  initialDelay: 10ms
+`, "69\n72\n0\n", time.Time{})
+	require.NoError(t, err)
+
+	// one log message for each of the three runs of the worker
+	require.Equal(t, 3, len(loggingDestination.Messages()))
+}
+
+func TestSupervisionMaxRestarts(t *testing.T) {
+	setupLogging()
+	defer teardownLogging()
+
+	err := runSupervisedFakeGenericWorker(t, `
+  restartOnExitCodes: [69]
+  maxRestarts: 1
+  initialDelay: 10ms
+`, "69\n69\n0\n", time.Time{})
+	require.Error(t, err)
+	code, ok := exitCode(err)
+	require.True(t, ok)
+	require.Equal(t, 69, code)
+}
+
+func TestSupervisionOtherExitCode(t *testing.T) {
+	loggingDestination := setupLogging()
+	defer teardownLogging()
+
+	err := runSupervisedFakeGenericWorker(t, `
+  restartOnExitCodes: [69]
+  initialDelay: 10ms
+`, "1\n0\n", time.Time{})
+	require.Error(t, err)
+	code, ok := exitCode(err)
+	require.True(t, ok)
+	require.Equal(t, 1, code)
+
+	// the worker was not restarted
+	require.Equal(t, 1, len(loggingDestination.Messages()))
+}
+
+func TestSupervisionCredentialsExpiring(t *testing.T) {
+	loggingDestination := setupLogging()
+	defer teardownLogging()
+
+	// the credentials remain valid for a restarted worker
+	err := runSupervisedFakeGenericWorker(t, `
+  restartOnExitCodes: [69]
+  initialDelay: 10ms
+`, "69\n0\n", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(loggingDestination.Messages()))
+
+	// the credentials expire too soon, and the worker cannot be registered
+	// again, so it is not restarted
+	loggingDestination.Clear()
+	err = runSupervisedFakeGenericWorker(t, `
+  restartOnExitCodes: [69]
+  initialDelay: 10ms
+`, "69\n0\n", time.Now().Add(2*time.Minute))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot be restarted as its credentials expire")
+	require.Equal(t, 1, len(loggingDestination.Messages()))
+}
+
 func TestDummy(t *testing.T) {
 	defer filet.CleanUp(t)
 	dir := filet.TmpDir(t, "")
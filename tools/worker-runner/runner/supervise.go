@@ -0,0 +1,30 @@
+package runner
+
+import (
+	"os/exec"
+	"time"
+
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/run"
+)
+
+// A restarted worker reuses the existing credentials only if they remain
+// valid for at least this long
+const minCredentialsLifetime = 5 * time.Minute
+
+// Get the exit code of a worker process from the error returned by
+// worker.Wait, if the error is due to the process's exit status.
+func exitCode(err error) (int, bool) {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), true
+	}
+	return 0, false
+}
+
+// Determine whether the credentials in the given state are valid for long
+// enough to use them for a restarted worker
+func credentialsValid(state *run.State) bool {
+	if state.CredentialsExpire.IsZero() {
+		return true
+	}
+	return time.Until(state.CredentialsExpire) > minCredentialsLifetime
+}
@@ -0,0 +1,22 @@
+package runner
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/taskcluster/taskcluster/v30/tools/worker-runner/run"
+)
+
+func TestExitCodeNotExitError(t *testing.T) {
+	_, ok := exitCode(errors.New("uhoh"))
+	require.False(t, ok)
+}
+
+func TestCredentialsValid(t *testing.T) {
+	require.True(t, credentialsValid(&run.State{}))
+	require.True(t, credentialsValid(&run.State{CredentialsExpire: time.Now().Add(time.Hour)}))
+	require.False(t, credentialsValid(&run.State{CredentialsExpire: time.Now().Add(time.Minute)}))
+	require.False(t, credentialsValid(&run.State{CredentialsExpire: time.Now().Add(-time.Hour)}))
+}
@@ -40,6 +40,21 @@ the following fields:
   implementations that restart the system as part of their normal operation
   and expect to start up with the same config after a restart.
 
+* |supervision|: if set, the worker is restarted when it exits with one of
+  the given exit codes, rather than worker-runner exiting.  The restarted
+  worker reuses the existing run state and credentials if they remain valid
+  for at least five minutes; otherwise, as a worker can only register with
+  worker-manager once, worker-runner exits rather than restarting it.  Exit
+  codes are only available when the worker runs as a child process, not as a
+  Windows service.
+
+  * |restartOnExitCodes|: (required) exit codes for which the worker is
+    restarted, such as 69 for generic-worker's INTERNAL_ERROR.
+  * |maxRestarts|: the maximum number of restarts (default 10).
+  * |initialDelay|: the delay before the first restart, doubling for each
+    subsequent restart (default |5s|).
+  * |maxDelay|: the maximum delay before a restart (default |5m|).
+
 **NOTE** for Windows users: the configuration file must be a UNIX-style text file.
 DOS-style newlines and encodings other than utf-8 are not supported.`, "|", "`")
 }
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/taskcluster/taskcluster/v30/internal/workerproto"
 )
@@ -26,4 +30,54 @@ func main() {
 	} else {
 		fmt.Println("proto does not support log")
 	}
+
+	os.Exit(exitCode())
+}
+
+// If the worker config names a file containing exit codes, one per line,
+// remove the first and return it; otherwise return 0.  This allows tests to
+// exercise restarting the worker.
+func exitCode() int {
+	var configPath string
+	for i, arg := range os.Args {
+		if arg == "--config" && i+1 < len(os.Args) {
+			configPath = os.Args[i+1]
+		}
+	}
+	if configPath == "" {
+		return 0
+	}
+
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		panic(err)
+	}
+	var config struct {
+		ExitCodesFile string `json:"fakeExitCodesFile"`
+	}
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		panic(err)
+	}
+	if config.ExitCodesFile == "" {
+		return 0
+	}
+
+	content, err = ioutil.ReadFile(config.ExitCodesFile)
+	if err != nil {
+		panic(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if lines[0] == "" {
+		return 0
+	}
+	err = ioutil.WriteFile(config.ExitCodesFile, []byte(strings.Join(lines[1:], "\n")), 0600)
+	if err != nil {
+		panic(err)
+	}
+	code, err := strconv.Atoi(lines[0])
+	if err != nil {
+		panic(err)
+	}
+	return code
 }
@@ -106,6 +106,14 @@ func TestChainOfTrustUpload(t *testing.T) {
 			ContentEncoding: "gzip",
 			Expires:         td.Expires,
 		},
+		"public/chain-of-trust-signatures.json": {
+			Extracts: []string{
+				`"keyId": "9a6ede112fceb72d"`,
+			},
+			ContentType:     "application/json; charset=utf-8",
+			ContentEncoding: "gzip",
+			Expires:         td.Expires,
+		},
 		"public/build/X.txt": {
 			Extracts: []string{
 				"test artifact",
@@ -173,6 +181,9 @@ func TestChainOfTrustUpload(t *testing.T) {
 	if len(cotCert.Artifacts) != 3 {
 		t.Fatalf("Expected 3 artifact hashes to be listed, but found %v", len(cotCert.Artifacts))
 	}
+	if len(cotCert.SigningKeys) != 1 || cotCert.SigningKeys[0].KeyID != "9a6ede112fceb72d" || cotCert.SigningKeys[0].Algorithm != "ed25519" {
+		t.Fatalf("Expected signingKeys to list the single ed25519 key 9a6ede112fceb72d but was %#v", cotCert.SigningKeys)
+	}
 	if cotCert.TaskID != taskID {
 		t.Fatalf("Expected taskId to be %q but was %q", taskID, cotCert.TaskID)
 	}
@@ -330,3 +341,66 @@ func TestProtectedArtifactsReplaced(t *testing.T) {
 		}
 	}
 }
+
+func TestChainOfTrustMounts(t *testing.T) {
+
+	defer setup(t)()
+
+	mounts := []MountEntry{
+		&FileMount{
+			File: filepath.Join("preloaded", "raw.txt"),
+			Content: json.RawMessage(`{
+				"raw": "Hello Raw!"
+			}`),
+		},
+		&WritableDirectoryCache{
+			CacheName: "banana-cache",
+			Directory: filepath.Join("my-task-caches", "bananas"),
+		},
+	}
+
+	payload := GenericWorkerPayload{
+		Mounts:     toMountArray(t, &mounts),
+		Command:    helloGoodbye(),
+		MaxRunTime: 30,
+		Features: FeatureFlags{
+			ChainOfTrust: true,
+		},
+	}
+	td := testTask(t)
+	td.Scopes = []string{"generic-worker:cache:banana-cache"}
+
+	// Chain of trust is not allowed when running as current user
+	// since signing key cannot be secured
+	if config.RunTasksAsCurrentUser {
+		expectChainOfTrustKeyNotSecureMessage(t, td, payload)
+		return
+	}
+
+	taskID := submitAndAssert(t, td, payload, "completed", "completed")
+
+	cotUnsignedBytes, _, _, _ := getArtifactContent(t, taskID, "public/chain-of-trust.json")
+	var cotCert ChainOfTrustData
+	err := json.Unmarshal(cotUnsignedBytes, &cotCert)
+	if err != nil {
+		t.Fatalf("Could not interpret public/chain-of-trust.json as json")
+	}
+	expectedMounts := []MountedInput{
+		{
+			Type: "file",
+			Path: filepath.Join("preloaded", "raw.txt"),
+			Content: &MountedContent{
+				Type:   "raw",
+				SHA256: "241513e37d9d6a4d5c9c19e37f9b3f0186bd4557514ac1b47d0fbb5216fbd804",
+			},
+		},
+		{
+			Type:      "cache",
+			Path:      filepath.Join("my-task-caches", "bananas"),
+			CacheName: "banana-cache",
+		},
+	}
+	if !reflect.DeepEqual(cotCert.Mounts, expectedMounts) {
+		t.Fatalf("Did not get expected mounts in chain of trust certificate:\n%#v\n ** vs **\n%#v", cotCert.Mounts, expectedMounts)
+	}
+}
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -30,10 +31,45 @@ var (
 	unsignedCertName      = "public/chain-of-trust.json"
 	ed25519SignedCertPath = filepath.Join("generic-worker", "chain-of-trust.json.sig")
 	ed25519SignedCertName = "public/chain-of-trust.json.sig"
+	certSignaturesPath    = filepath.Join("generic-worker", "chain-of-trust-signatures.json")
+	certSignaturesName    = "public/chain-of-trust-signatures.json"
 )
 
 type ChainOfTrustFeature struct {
-	Ed25519PrivateKey ed25519.PrivateKey
+	// SigningKeys holds the primary signing key (from config setting
+	// ed25519SigningKeyLocation) followed by any additional signing keys
+	// (from config setting additionalEd25519SigningKeyLocations).
+	SigningKeys []*CoTSigningKey
+}
+
+// CoTSigningKey is a private key that chain of trust certificates are signed
+// with.
+type CoTSigningKey struct {
+	// Algorithm is always "ed25519", the only supported key type
+	Algorithm  string
+	KeyID      string
+	Location   string
+	PrivateKey ed25519.PrivateKey
+}
+
+// CoTSigningKeyID identifies a key that a chain of trust certificate has been
+// signed with.
+type CoTSigningKeyID struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"keyId"`
+}
+
+// CoTSignature is a detached signature of a chain of trust certificate.
+type CoTSignature struct {
+	CoTSigningKeyID
+	// Base64 encoded signature
+	Signature string `json:"signature"`
+}
+
+// CoTSignatures is the content of the chain-of-trust-signatures.json
+// artifact, containing one detached signature per signing key.
+type CoTSignatures struct {
+	Signatures []CoTSignature `json:"signatures"`
 }
 
 type ArtifactHash struct {
@@ -57,11 +93,37 @@ type ChainOfTrustData struct {
 	WorkerGroup string                         `json:"workerGroup"`
 	WorkerID    string                         `json:"workerId"`
 	Environment CoTEnvironment                 `json:"environment"`
+	Mounts      []MountedInput                 `json:"mounts"`
+	SigningKeys []CoTSigningKeyID              `json:"signingKeys"`
 }
 
 type ChainOfTrustTaskFeature struct {
-	task           *TaskRun
-	ed25519PrivKey ed25519.PrivateKey
+	task        *TaskRun
+	signingKeys []*CoTSigningKey
+}
+
+func newEd25519CoTSigningKey(location string) (*CoTSigningKey, error) {
+	privateKey, err := readEd25519PrivateKeyFromFile(location)
+	if err != nil {
+		return nil, err
+	}
+	return &CoTSigningKey{
+		Algorithm:  "ed25519",
+		KeyID:      ed25519KeyID(privateKey.Public().(ed25519.PublicKey)),
+		Location:   location,
+		PrivateKey: privateKey,
+	}, nil
+}
+
+func (key *CoTSigningKey) ID() CoTSigningKeyID {
+	return CoTSigningKeyID{
+		Algorithm: key.Algorithm,
+		KeyID:     key.KeyID,
+	}
+}
+
+func (key *CoTSigningKey) Sign(data []byte) []byte {
+	return ed25519.Sign(key.PrivateKey, data)
 }
 
 func (feature *ChainOfTrustFeature) Name() string {
@@ -73,14 +135,25 @@ func (feature *ChainOfTrustFeature) PersistState() error {
 }
 
 func (feature *ChainOfTrustFeature) Initialise() (err error) {
-	feature.Ed25519PrivateKey, err = readEd25519PrivateKeyFromFile(config.Ed25519SigningKeyLocation)
-	if err != nil {
-		return
+	locations := append([]string{config.Ed25519SigningKeyLocation}, config.AdditionalEd25519SigningKeyLocations...)
+	keyIDs := map[string]string{}
+	feature.SigningKeys = make([]*CoTSigningKey, len(locations))
+	for i, location := range locations {
+		feature.SigningKeys[i], err = newEd25519CoTSigningKey(location)
+		if err != nil {
+			return
+		}
+		keyID := feature.SigningKeys[i].KeyID
+		if previous, exists := keyIDs[keyID]; exists {
+			return fmt.Errorf("Chain of trust signing key %v is the same key as %v (key ID %v)", location, previous, keyID)
+		}
+		keyIDs[keyID] = location
+		log.Printf("Chain of trust certificates will be signed with %v key %v from %v", feature.SigningKeys[i].Algorithm, keyID, location)
 	}
 
 	// platform-specific mechanism to lock down file permissions
-	// of private signing key
-	err = fileutil.SecureFiles(config.Ed25519SigningKeyLocation)
+	// of private signing keys
+	err = fileutil.SecureFiles(locations...)
 	return
 }
 
@@ -90,17 +163,22 @@ func (feature *ChainOfTrustFeature) IsEnabled(task *TaskRun) bool {
 
 func (feature *ChainOfTrustFeature) NewTaskFeature(task *TaskRun) TaskFeature {
 	return &ChainOfTrustTaskFeature{
-		task:           task,
-		ed25519PrivKey: feature.Ed25519PrivateKey,
+		task:        task,
+		signingKeys: feature.SigningKeys,
 	}
 }
 
 func (feature *ChainOfTrustTaskFeature) ReservedArtifacts() []string {
-	return []string{
+	artifacts := []string{
 		unsignedCertName,
 		ed25519SignedCertName,
+		certSignaturesName,
 		certifiedLogName,
 	}
+	if config.PublishSLSAProvenance {
+		artifacts = append(artifacts, slsaProvenanceName)
+	}
+	return artifacts
 }
 
 func (feature *ChainOfTrustTaskFeature) RequiredScopes() scopes.Required {
@@ -126,6 +204,7 @@ func (feature *ChainOfTrustTaskFeature) Stop(err *ExecutionErrors) {
 	certifiedLogFile := filepath.Join(taskContext.TaskDir, certifiedLogPath)
 	unsignedCert := filepath.Join(taskContext.TaskDir, unsignedCertPath)
 	ed25519SignedCert := filepath.Join(taskContext.TaskDir, ed25519SignedCertPath)
+	certSignatures := filepath.Join(taskContext.TaskDir, certSignaturesPath)
 	copyErr := copyFileContents(logFile, certifiedLogFile)
 	if copyErr != nil {
 		panic(copyErr)
@@ -161,6 +240,11 @@ func (feature *ChainOfTrustTaskFeature) Stop(err *ExecutionErrors) {
 			InstanceType:     config.InstanceType,
 			Region:           config.Region,
 		},
+		Mounts:      feature.task.MountedInputs,
+		SigningKeys: make([]CoTSigningKeyID, len(feature.signingKeys)),
+	}
+	for i, key := range feature.signingKeys {
+		cotCert.SigningKeys[i] = key.ID()
 	}
 
 	if config.PublicIP != nil {
@@ -178,8 +262,35 @@ func (feature *ChainOfTrustTaskFeature) Stop(err *ExecutionErrors) {
 	}
 	err.add(feature.task.uploadLog(unsignedCertName, unsignedCertPath))
 
-	// create detached ed25519 chain-of-trust.json.sig
-	sig := ed25519.Sign(feature.ed25519PrivKey, certBytes)
+	// create detached signatures with every signing key
+	signatures := &CoTSignatures{
+		Signatures: make([]CoTSignature, len(feature.signingKeys)),
+	}
+	for i, key := range feature.signingKeys {
+		signatures.Signatures[i] = CoTSignature{
+			CoTSigningKeyID: key.ID(),
+			Signature:       base64.StdEncoding.EncodeToString(key.Sign(certBytes)),
+		}
+	}
+	e = fileutil.WriteToFileAsJSON(signatures, certSignatures)
+	if e != nil {
+		panic(e)
+	}
+	err.add(feature.task.uploadArtifact(
+		&S3Artifact{
+			BaseArtifact: &BaseArtifact{
+				Name:    certSignaturesName,
+				Expires: feature.task.Definition.Expires,
+			},
+			ContentType:     "application/json; charset=utf-8",
+			ContentEncoding: "gzip",
+			Path:            certSignaturesPath,
+		},
+	))
+
+	// create detached ed25519 chain-of-trust.json.sig, signed with the
+	// primary signing key, for consumers that only support a single key
+	sig := feature.signingKeys[0].Sign(certBytes)
 	e = ioutil.WriteFile(ed25519SignedCert, sig, 0644)
 	if e != nil {
 		panic(e)
@@ -195,17 +306,23 @@ func (feature *ChainOfTrustTaskFeature) Stop(err *ExecutionErrors) {
 			Path:            ed25519SignedCertPath,
 		},
 	))
+
+	if config.PublishSLSAProvenance {
+		err.add(feature.publishSLSAProvenance(artifactHashes, cotCert.Environment))
+	}
 }
 
 func (cot *ChainOfTrustTaskFeature) ensureTaskUserCantReadPrivateCotKey() error {
-	c, err := cot.catCotKeyCommand()
-	if err != nil {
-		panic(fmt.Errorf("SERIOUS BUG: Could not create command (not even trying to execute it yet) to cat private chain of trust key %v - %v", config.Ed25519SigningKeyLocation, err))
-	}
-	r := c.Execute()
-	if !r.Failed() {
-		log.Print(r.String())
-		return errors.New(ChainOfTrustKeyNotSecureMessage)
+	for _, key := range cot.signingKeys {
+		c, err := cot.catCotKeyCommand(key.Location)
+		if err != nil {
+			panic(fmt.Errorf("SERIOUS BUG: Could not create command (not even trying to execute it yet) to cat private chain of trust key %v - %v", key.Location, err))
+		}
+		r := c.Execute()
+		if !r.Failed() {
+			log.Print(r.String())
+			return errors.New(ChainOfTrustKeyNotSecureMessage)
+		}
 	}
 	return nil
 }
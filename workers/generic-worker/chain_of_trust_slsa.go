@@ -0,0 +1,232 @@
+// +build multiuser
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	tcurls "github.com/taskcluster/taskcluster-lib-urls"
+	tcclient "github.com/taskcluster/taskcluster/v30/clients/client-go"
+	"github.com/taskcluster/taskcluster/v30/clients/client-go/tcqueue"
+	"github.com/taskcluster/taskcluster/v30/workers/generic-worker/fileutil"
+)
+
+const (
+	inTotoStatementType    = "https://in-toto.io/Statement/v0.1"
+	inTotoPayloadType      = "application/vnd.in-toto+json"
+	slsaProvenanceType     = "https://slsa.dev/provenance/v0.2"
+	genericWorkerBuildType = "https://github.com/taskcluster/taskcluster/tree/main/workers/generic-worker#chain-of-trust@v1"
+)
+
+var (
+	slsaProvenancePath = filepath.Join("generic-worker", "chain-of-trust-provenance.intoto.json")
+	slsaProvenanceName = "public/chain-of-trust-provenance.intoto.json"
+)
+
+// InTotoStatement is an in-toto attestation statement, see
+// https://github.com/in-toto/attestation/blob/main/spec/README.md
+type InTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []InTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     SLSAProvenance  `json:"predicate"`
+}
+
+type InTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// SLSAProvenance is a SLSA v0.2 provenance predicate, see
+// https://slsa.dev/provenance/v0.2
+type SLSAProvenance struct {
+	Builder    SLSABuilder    `json:"builder"`
+	BuildType  string         `json:"buildType"`
+	Invocation SLSAInvocation `json:"invocation"`
+	Metadata   SLSAMetadata   `json:"metadata"`
+	Materials  []SLSAMaterial `json:"materials"`
+}
+
+type SLSABuilder struct {
+	ID string `json:"id"`
+}
+
+type SLSAInvocation struct {
+	Parameters  tcqueue.TaskDefinitionResponse `json:"parameters"`
+	Environment SLSAEnvironment                `json:"environment"`
+}
+
+type SLSAEnvironment struct {
+	CoTEnvironment
+	TaskID       string `json:"taskId"`
+	RunID        uint   `json:"runId"`
+	WorkerPoolID string `json:"workerPoolId"`
+	WorkerGroup  string `json:"workerGroup"`
+	WorkerID     string `json:"workerId"`
+}
+
+type SLSAMetadata struct {
+	BuildInvocationID string           `json:"buildInvocationId"`
+	BuildStartedOn    tcclient.Time    `json:"buildStartedOn"`
+	BuildFinishedOn   tcclient.Time    `json:"buildFinishedOn"`
+	Completeness      SLSACompleteness `json:"completeness"`
+	Reproducible      bool             `json:"reproducible"`
+}
+
+type SLSACompleteness struct {
+	Parameters  bool `json:"parameters"`
+	Environment bool `json:"environment"`
+	Materials   bool `json:"materials"`
+}
+
+type SLSAMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+// DSSEEnvelope is a Dead Simple Signing Envelope, see
+// https://github.com/secure-systems-lab/dsse/blob/master/envelope.md
+type DSSEEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []DSSESignature `json:"signatures"`
+}
+
+type DSSESignature struct {
+	KeyID     string `json:"keyid"`
+	Signature string `json:"sig"`
+}
+
+// dssePAE returns the DSSE v1 Pre-Authentication Encoding of the given
+// payload, which is what gets signed
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+func (feature *ChainOfTrustTaskFeature) slsaProvenance(artifactHashes map[string]ArtifactHash, cotEnvironment CoTEnvironment) *InTotoStatement {
+	subjects := []InTotoSubject{}
+	for name, hash := range artifactHashes {
+		subjects = append(
+			subjects,
+			InTotoSubject{
+				Name: name,
+				Digest: map[string]string{
+					"sha256": hash.SHA256,
+				},
+			},
+		)
+	}
+	sort.Slice(subjects, func(i, j int) bool {
+		return subjects[i].Name < subjects[j].Name
+	})
+
+	// Writable caches that were used by earlier tasks, and raw/base64
+	// content (which is part of the task definition rather than being
+	// fetched), do not have a resolvable material
+	materialsComplete := true
+	materials := []SLSAMaterial{}
+	for _, mount := range feature.task.MountedInputs {
+		if mount.PreexistingCache {
+			materialsComplete = false
+		}
+		if mount.Content == nil {
+			continue
+		}
+		var uri string
+		switch mount.Content.Type {
+		case "url":
+			uri = mount.Content.URL
+		case "artifact":
+			uri = tcurls.API(config.RootURL, "queue", "v1", fmt.Sprintf("task/%v/artifacts/%v", mount.Content.TaskID, mount.Content.Artifact))
+		default:
+			continue
+		}
+		materials = append(
+			materials,
+			SLSAMaterial{
+				URI: uri,
+				Digest: map[string]string{
+					"sha256": mount.Content.SHA256,
+				},
+			},
+		)
+	}
+
+	workerPoolID := config.ProvisionerID + "/" + config.WorkerType
+	return &InTotoStatement{
+		Type:          inTotoStatementType,
+		Subject:       subjects,
+		PredicateType: slsaProvenanceType,
+		Predicate: SLSAProvenance{
+			Builder: SLSABuilder{
+				ID: tcurls.UI(config.RootURL, fmt.Sprintf("provisioners/%v/worker-types/%v/workers/%v/%v", config.ProvisionerID, config.WorkerType, config.WorkerGroup, config.WorkerID)),
+			},
+			BuildType: genericWorkerBuildType,
+			Invocation: SLSAInvocation{
+				Parameters: feature.task.Definition,
+				Environment: SLSAEnvironment{
+					CoTEnvironment: cotEnvironment,
+					TaskID:         feature.task.TaskID,
+					RunID:          feature.task.RunID,
+					WorkerPoolID:   workerPoolID,
+					WorkerGroup:    config.WorkerGroup,
+					WorkerID:       config.WorkerID,
+				},
+			},
+			Metadata: SLSAMetadata{
+				BuildInvocationID: fmt.Sprintf("%v/%v", feature.task.TaskID, feature.task.RunID),
+				BuildStartedOn:    tcclient.Time(feature.task.LocalClaimTime),
+				BuildFinishedOn:   tcclient.Time(time.Now()),
+				Completeness: SLSACompleteness{
+					Parameters:  true,
+					Environment: false,
+					Materials:   materialsComplete,
+				},
+				Reproducible: false,
+			},
+			Materials: materials,
+		},
+	}
+}
+
+// publishSLSAProvenance creates an in-toto statement containing SLSA
+// provenance for the task artifacts, wraps it in a DSSE envelope signed with
+// the chain of trust signing keys, and uploads it as an artifact.
+func (feature *ChainOfTrustTaskFeature) publishSLSAProvenance(artifactHashes map[string]ArtifactHash, cotEnvironment CoTEnvironment) *CommandExecutionError {
+	statement, err := json.Marshal(feature.slsaProvenance(artifactHashes, cotEnvironment))
+	if err != nil {
+		panic(err)
+	}
+	pae := dssePAE(inTotoPayloadType, statement)
+	envelope := &DSSEEnvelope{
+		PayloadType: inTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(statement),
+		Signatures:  make([]DSSESignature, len(feature.signingKeys)),
+	}
+	for i, key := range feature.signingKeys {
+		envelope.Signatures[i] = DSSESignature{
+			KeyID:     key.KeyID,
+			Signature: base64.StdEncoding.EncodeToString(key.Sign(pae)),
+		}
+	}
+	err = fileutil.WriteToFileAsJSON(envelope, filepath.Join(taskContext.TaskDir, slsaProvenancePath))
+	if err != nil {
+		panic(err)
+	}
+	return feature.task.uploadArtifact(
+		&S3Artifact{
+			BaseArtifact: &BaseArtifact{
+				Name:    slsaProvenanceName,
+				Expires: feature.task.Definition.Expires,
+			},
+			ContentType:     "application/json; charset=utf-8",
+			ContentEncoding: "gzip",
+			Path:            slsaProvenancePath,
+		},
+	)
+}
@@ -0,0 +1,91 @@
+// +build multiuser
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestDSSEPAE(t *testing.T) {
+	// example from https://github.com/secure-systems-lab/dsse/blob/master/protocol.md
+	pae := dssePAE("http://example.com/HelloWorld", []byte("hello world"))
+	expected := "DSSEv1 29 http://example.com/HelloWorld 11 hello world"
+	if string(pae) != expected {
+		t.Fatalf("Expected PAE %q but got %q", expected, string(pae))
+	}
+}
+
+func TestChainOfTrustSLSAProvenance(t *testing.T) {
+
+	defer setup(t)()
+
+	config.PublishSLSAProvenance = true
+
+	payload := GenericWorkerPayload{
+		Command:    helloGoodbye(),
+		MaxRunTime: 30,
+		Features: FeatureFlags{
+			ChainOfTrust: true,
+		},
+	}
+	td := testTask(t)
+
+	// Chain of trust is not allowed when running as current user
+	// since signing key cannot be secured
+	if config.RunTasksAsCurrentUser {
+		expectChainOfTrustKeyNotSecureMessage(t, td, payload)
+		return
+	}
+
+	taskID := submitAndAssert(t, td, payload, "completed", "completed")
+
+	envelopeBytes, _, _, _ := getArtifactContent(t, taskID, "public/chain-of-trust-provenance.intoto.json")
+	var envelope DSSEEnvelope
+	err := json.Unmarshal(envelopeBytes, &envelope)
+	if err != nil {
+		t.Fatalf("Could not interpret public/chain-of-trust-provenance.intoto.json as json: %v", err)
+	}
+	if envelope.PayloadType != "application/vnd.in-toto+json" {
+		t.Fatalf("Expected payloadType application/vnd.in-toto+json but got %v", envelope.PayloadType)
+	}
+	statementBytes, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		t.Fatalf("Could not base64 decode DSSE payload: %v", err)
+	}
+	privateKey, err := readEd25519PrivateKeyFromFile(config.Ed25519SigningKeyLocation)
+	if err != nil {
+		t.Fatalf("Could not read ed25519 private key: %v", err)
+	}
+	if len(envelope.Signatures) != 1 || envelope.Signatures[0].KeyID != "9a6ede112fceb72d" {
+		t.Fatalf("Expected a single signature from key 9a6ede112fceb72d but got %#v", envelope.Signatures)
+	}
+	sig, err := base64.StdEncoding.DecodeString(envelope.Signatures[0].Signature)
+	if err != nil {
+		t.Fatalf("Could not base64 decode DSSE signature: %v", err)
+	}
+	if !ed25519.Verify(privateKey.Public().(ed25519.PublicKey), dssePAE(envelope.PayloadType, statementBytes), sig) {
+		t.Fatal("Could not verify DSSE envelope signature")
+	}
+
+	var statement InTotoStatement
+	err = json.Unmarshal(statementBytes, &statement)
+	if err != nil {
+		t.Fatalf("Could not interpret in-toto statement as json: %v", err)
+	}
+	if statement.PredicateType != "https://slsa.dev/provenance/v0.2" {
+		t.Fatalf("Expected SLSA v0.2 provenance predicate but got %v", statement.PredicateType)
+	}
+	if len(statement.Subject) != 1 || statement.Subject[0].Name != "public/logs/certified.log" {
+		t.Fatalf("Expected certified.log to be the only subject but got %#v", statement.Subject)
+	}
+	if statement.Predicate.Invocation.Environment.TaskID != taskID {
+		t.Fatalf("Expected taskId %v in invocation environment but got %v", taskID, statement.Predicate.Invocation.Environment.TaskID)
+	}
+	if statement.Predicate.Invocation.Environment.WorkerPoolID != "test-provisioner/"+config.WorkerType {
+		t.Fatalf("Expected workerPoolId test-provisioner/%v but got %v", config.WorkerType, statement.Predicate.Invocation.Environment.WorkerPoolID)
+	}
+}
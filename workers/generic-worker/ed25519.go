@@ -1,8 +1,12 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 
 	"golang.org/x/crypto/ed25519"
@@ -24,6 +28,38 @@ func generateEd25519Keypair(privateKeyFile string) error {
 	return nil
 }
 
+func showEd25519KeyID(privateKeyFile string) error {
+	privateKey, err := readEd25519PrivateKeyFromFile(privateKeyFile)
+	if err != nil {
+		return err
+	}
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	_, err = fmt.Fprintf(
+		os.Stdout,
+		"Key ID:      %v\nFingerprint: %v\nPublic key:  %v\n",
+		ed25519KeyID(publicKey),
+		ed25519Fingerprint(publicKey),
+		base64.StdEncoding.EncodeToString(publicKey),
+	)
+	return err
+}
+
+// ed25519Fingerprint returns the SHA256 fingerprint of the given public key,
+// in the form "SHA256:<hex digest>".
+func ed25519Fingerprint(publicKey ed25519.PublicKey) string {
+	digest := sha256.Sum256(publicKey)
+	return "SHA256:" + hex.EncodeToString(digest[:])
+}
+
+// ed25519KeyID returns a short identifier for the given public key, which is
+// the first 16 hex characters of its SHA256 fingerprint. Key IDs are embedded
+// in chain of trust certificates so that verifiers can tell which key(s)
+// produced a signature.
+func ed25519KeyID(publicKey ed25519.PublicKey) string {
+	digest := sha256.Sum256(publicKey)
+	return hex.EncodeToString(digest[:8])
+}
+
 func writeEd25519PublicKeyToLog(publicKey []byte) error {
 	str := base64.StdEncoding.EncodeToString(publicKey)
 	_, _ = io.WriteString(os.Stdout, str)
@@ -42,3 +78,19 @@ func writeEd25519PrivateKeyToFile(privateKey ed25519.PrivateKey, privateKeyFile
 	}
 	return nil
 }
+
+func readEd25519PrivateKeyFromFile(path string) (privateKey ed25519.PrivateKey, err error) {
+	base64Seed, e := ioutil.ReadFile(path)
+	if e != nil {
+		return privateKey, e
+	}
+	seed, e := base64.StdEncoding.DecodeString(string(base64Seed))
+	if e != nil {
+		return privateKey, e
+	}
+	if len(seed) != ed25519.SeedSize {
+		return privateKey, fmt.Errorf("ed25519 private key file %v should contain a base64 encoded %v byte seed, but contains %v bytes", path, ed25519.SeedSize, len(seed))
+	}
+	privateKey = ed25519.NewKeyFromSeed(seed)
+	return
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestEd25519KeyID(t *testing.T) {
+	privateKey, err := readEd25519PrivateKeyFromFile(filepath.Join("testdata", "ed25519_private_key"))
+	if err != nil {
+		t.Fatalf("Could not read ed25519 private key: %v", err)
+	}
+	base64PublicKey, err := ioutil.ReadFile(filepath.Join("testdata", "ed25519_public_key"))
+	if err != nil {
+		t.Fatalf("Could not read ed25519 public key: %v", err)
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(string(base64PublicKey))
+	if err != nil {
+		t.Fatalf("Could not decode ed25519 public key: %v", err)
+	}
+	if !bytes.Equal(privateKey.Public().(ed25519.PublicKey), publicKey) {
+		t.Fatal("Public key derived from testdata private key does not match testdata public key")
+	}
+	if keyID := ed25519KeyID(publicKey); keyID != "9a6ede112fceb72d" {
+		t.Fatalf("Expected key ID 9a6ede112fceb72d but got %v", keyID)
+	}
+	expectedFingerprint := "SHA256:9a6ede112fceb72d9047d1244e655ada53642e0f6e5cece8fefb22e02a26e1bf"
+	if fingerprint := ed25519Fingerprint(publicKey); fingerprint != expectedFingerprint {
+		t.Fatalf("Expected fingerprint %v but got %v", expectedFingerprint, fingerprint)
+	}
+}
+
+func TestReadInvalidEd25519PrivateKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "short_key")
+	err = ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString([]byte("too short"))), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = readEd25519PrivateKeyFromFile(keyFile)
+	if err == nil {
+		t.Fatal("Was expecting an error reading an ed25519 private key with an invalid seed length")
+	}
+}
@@ -75,30 +75,24 @@ type wstExposure struct {
 }
 
 func (exposure *wstExposure) start() error {
-	wstClient, err := client.New(func() (client.Config, error) {
-		wstClientId := fmt.Sprintf("%s.%s.%d", exposure.exposer.workerGroup, exposure.exposer.workerId, exposure.targetPort)
-		tokenResponse, err := exposure.exposer.authClient.WebsocktunnelToken(
-			exposure.exposer.wstAudience, wstClientId)
-		if err != nil {
-			return client.Config{}, err
-		}
-		// note that we ignore the "expires" value, as we simply generate a new
-		// token each time we need one
-
-		return client.Config{
-			ID:         wstClientId,
-			TunnelAddr: exposure.exposer.serverURL,
-			Token:      tokenResponse.Token,
-		}, nil
-	})
+	// TCP ports are exposed with a tcp tunnel, so that websocktunnel carries
+	// the data of viewers' websocket messages as a raw stream
+	tcp := !exposure.isHTTP
+	wstClient, err := client.New(exposure.configurer(tcp))
+	if err == client.ErrTCPNotSupported {
+		// older websocktunnel servers do not support tcp tunnels, so the
+		// websocket connections must be unwrapped here
+		tcp = false
+		wstClient, err = client.New(exposure.configurer(tcp))
+	}
 	if err != nil {
 		return err
 	}
 	exposure.wstClient = wstClient
 
-	if exposure.isHTTP {
+	if exposure.isHTTP || tcp {
 		// forward connections via the websocktunnel to the target port; these will all be
-		// HTTP connections
+		// HTTP connections, or raw TCP connections for a tcp tunnel
 		go forwardPort(wstClient, fmt.Sprintf("127.0.0.1:%d", exposure.targetPort))
 	} else {
 		// forward websocket connections at path / to the local port
@@ -113,6 +107,26 @@ func (exposure *wstExposure) start() error {
 	return nil
 }
 
+func (exposure *wstExposure) configurer(tcp bool) client.Configurer {
+	return func() (client.Config, error) {
+		wstClientId := fmt.Sprintf("%s.%s.%d", exposure.exposer.workerGroup, exposure.exposer.workerId, exposure.targetPort)
+		tokenResponse, err := exposure.exposer.authClient.WebsocktunnelToken(
+			exposure.exposer.wstAudience, wstClientId)
+		if err != nil {
+			return client.Config{}, err
+		}
+		// note that we ignore the "expires" value, as we simply generate a new
+		// token each time we need one
+
+		return client.Config{
+			ID:         wstClientId,
+			TunnelAddr: exposure.exposer.serverURL,
+			Token:      tokenResponse.Token,
+			TCP:        tcp,
+		}, nil
+	}
+}
+
 func (exposure *wstExposure) Close() error {
 	return exposure.wstClient.Close()
 }
@@ -28,45 +28,49 @@ type (
 
 	PublicConfig struct {
 		PublicEngineConfig
-		AuthRootURL                    string                 `json:"authRootURL"`
-		AvailabilityZone               string                 `json:"availabilityZone"`
-		CachesDir                      string                 `json:"cachesDir"`
-		CheckForNewDeploymentEverySecs uint                   `json:"checkForNewDeploymentEverySecs"`
-		CleanUpTaskDirs                bool                   `json:"cleanUpTaskDirs"`
-		ClientID                       string                 `json:"clientId"`
-		DeploymentID                   string                 `json:"deploymentId"`
-		DisableReboots                 bool                   `json:"disableReboots"`
-		DownloadsDir                   string                 `json:"downloadsDir"`
-		Ed25519SigningKeyLocation      string                 `json:"ed25519SigningKeyLocation"`
-		IdleTimeoutSecs                uint                   `json:"idleTimeoutSecs"`
-		InstanceID                     string                 `json:"instanceId"`
-		InstanceType                   string                 `json:"instanceType"`
-		LiveLogExecutable              string                 `json:"livelogExecutable"`
-		NumberOfTasksToRun             uint                   `json:"numberOfTasksToRun"`
-		PrivateIP                      net.IP                 `json:"privateIP"`
-		ProvisionerID                  string                 `json:"provisionerId"`
-		PublicIP                       net.IP                 `json:"publicIP"`
-		PurgeCacheRootURL              string                 `json:"purgeCacheRootURL"`
-		QueueRootURL                   string                 `json:"queueRootURL"`
-		Region                         string                 `json:"region"`
-		RequiredDiskSpaceMegabytes     uint                   `json:"requiredDiskSpaceMegabytes"`
-		RootURL                        string                 `json:"rootURL"`
-		RunAfterUserCreation           string                 `json:"runAfterUserCreation"`
-		SecretsRootURL                 string                 `json:"secretsRootURL"`
-		SentryProject                  string                 `json:"sentryProject"`
-		ShutdownMachineOnIdle          bool                   `json:"shutdownMachineOnIdle"`
-		ShutdownMachineOnInternalError bool                   `json:"shutdownMachineOnInternalError"`
-		TaskclusterProxyExecutable     string                 `json:"taskclusterProxyExecutable"`
-		TaskclusterProxyPort           uint16                 `json:"taskclusterProxyPort"`
-		TasksDir                       string                 `json:"tasksDir"`
-		WorkerGroup                    string                 `json:"workerGroup"`
-		WorkerID                       string                 `json:"workerId"`
-		WorkerLocation                 string                 `json:"workerLocation"`
-		WorkerManagerRootURL           string                 `json:"workerManagerRootURL"`
-		WorkerType                     string                 `json:"workerType"`
-		WorkerTypeMetadata             map[string]interface{} `json:"workerTypeMetadata"`
-		WSTAudience                    string                 `json:"wstAudience"`
-		WSTServerURL                   string                 `json:"wstServerURL"`
+		AdditionalEd25519SigningKeyLocations []string               `json:"additionalEd25519SigningKeyLocations"`
+		AuthRootURL                          string                 `json:"authRootURL"`
+		AvailabilityZone                     string                 `json:"availabilityZone"`
+		CachesDir                            string                 `json:"cachesDir"`
+		CheckForNewDeploymentEverySecs       uint                   `json:"checkForNewDeploymentEverySecs"`
+		CleanUpTaskDirs                      bool                   `json:"cleanUpTaskDirs"`
+		ClientID                             string                 `json:"clientId"`
+		DeploymentID                         string                 `json:"deploymentId"`
+		DisableReboots                       bool                   `json:"disableReboots"`
+		DownloadsDir                         string                 `json:"downloadsDir"`
+		Ed25519SigningKeyLocation            string                 `json:"ed25519SigningKeyLocation"`
+		IdleTimeoutSecs                      uint                   `json:"idleTimeoutSecs"`
+		InstanceID                           string                 `json:"instanceId"`
+		InstanceType                         string                 `json:"instanceType"`
+		LiveLogExecutable                    string                 `json:"livelogExecutable"`
+		NumberOfTasksToRun                   uint                   `json:"numberOfTasksToRun"`
+		PrivateIP                            net.IP                 `json:"privateIP"`
+		ProvisionerID                        string                 `json:"provisionerId"`
+		PublicIP                             net.IP                 `json:"publicIP"`
+		PublishSLSAProvenance                bool                   `json:"publishSLSAProvenance"`
+		PurgeCacheRootURL                    string                 `json:"purgeCacheRootURL"`
+		QueueRootURL                         string                 `json:"queueRootURL"`
+		Region                               string                 `json:"region"`
+		RequiredDiskSpaceMegabytes           uint                   `json:"requiredDiskSpaceMegabytes"`
+		RootURL                              string                 `json:"rootURL"`
+		RunAfterUserCreation                 string                 `json:"runAfterUserCreation"`
+		SecretsRootURL                       string                 `json:"secretsRootURL"`
+		SentryProject                        string                 `json:"sentryProject"`
+		ShutdownMachineOnIdle                bool                   `json:"shutdownMachineOnIdle"`
+		ShutdownMachineOnInternalError       bool                   `json:"shutdownMachineOnInternalError"`
+		TaskclusterProxyAuditLog             bool                   `json:"taskclusterProxyAuditLog"`
+		TaskclusterProxyExecutable           string                 `json:"taskclusterProxyExecutable"`
+		TaskclusterProxyPort                 uint16                 `json:"taskclusterProxyPort"`
+		TaskclusterProxyUnixSocket           bool                   `json:"taskclusterProxyUnixSocket"`
+		TasksDir                             string                 `json:"tasksDir"`
+		WorkerGroup                          string                 `json:"workerGroup"`
+		WorkerID                             string                 `json:"workerId"`
+		WorkerLocation                       string                 `json:"workerLocation"`
+		WorkerManagerRootURL                 string                 `json:"workerManagerRootURL"`
+		WorkerType                           string                 `json:"workerType"`
+		WorkerTypeMetadata                   map[string]interface{} `json:"workerTypeMetadata"`
+		WSTAudience                          string                 `json:"wstAudience"`
+		WSTServerURL                         string                 `json:"wstServerURL"`
 	}
 
 	PrivateConfig struct {
@@ -224,6 +224,9 @@ func main() {
 	case arguments["new-ed25519-keypair"]:
 		err := generateEd25519Keypair(arguments["--file"].(string))
 		exitOnError(CANT_CREATE_ED25519_KEYPAIR, err, "Error generating ed25519 keypair %v for worker", arguments["--file"].(string))
+	case arguments["show-ed25519-key-id"]:
+		err := showEd25519KeyID(arguments["--file"].(string))
+		exitOnError(CANT_READ_ED25519_KEY, err, "Error reading ed25519 private key %v", arguments["--file"].(string))
 	default:
 		// platform specific...
 		os.Exit(int(platformTargets(arguments)))
@@ -243,32 +246,36 @@ func loadConfig(configFile *gwconfig.File, provider Provider) (gwconfig.Provider
 	// only one place if possible (defaults also declared in `usage`)
 	config = &gwconfig.Config{
 		PublicConfig: gwconfig.PublicConfig{
-			AuthRootURL:                    "",
-			CachesDir:                      "caches",
-			CheckForNewDeploymentEverySecs: 1800,
-			CleanUpTaskDirs:                true,
-			DisableReboots:                 false,
-			DownloadsDir:                   "downloads",
-			IdleTimeoutSecs:                0,
-			LiveLogExecutable:              "livelog",
-			NumberOfTasksToRun:             0,
-			ProvisionerID:                  "test-provisioner",
-			PurgeCacheRootURL:              "",
-			QueueRootURL:                   "",
-			RequiredDiskSpaceMegabytes:     10240,
-			RootURL:                        "",
-			RunAfterUserCreation:           "",
-			SecretsRootURL:                 "",
-			SentryProject:                  "generic-worker",
-			ShutdownMachineOnIdle:          false,
-			ShutdownMachineOnInternalError: false,
-			TaskclusterProxyExecutable:     "taskcluster-proxy",
-			TaskclusterProxyPort:           80,
-			TasksDir:                       defaultTasksDir(),
-			WorkerGroup:                    "test-worker-group",
-			WorkerLocation:                 "",
-			WorkerManagerRootURL:           "",
-			WorkerTypeMetadata:             map[string]interface{}{},
+			AdditionalEd25519SigningKeyLocations: []string{},
+			AuthRootURL:                          "",
+			CachesDir:                            "caches",
+			CheckForNewDeploymentEverySecs:       1800,
+			CleanUpTaskDirs:                      true,
+			DisableReboots:                       false,
+			DownloadsDir:                         "downloads",
+			IdleTimeoutSecs:                      0,
+			LiveLogExecutable:                    "livelog",
+			NumberOfTasksToRun:                   0,
+			ProvisionerID:                        "test-provisioner",
+			PublishSLSAProvenance:                false,
+			PurgeCacheRootURL:                    "",
+			QueueRootURL:                         "",
+			RequiredDiskSpaceMegabytes:           10240,
+			RootURL:                              "",
+			RunAfterUserCreation:                 "",
+			SecretsRootURL:                       "",
+			SentryProject:                        "generic-worker",
+			ShutdownMachineOnIdle:                false,
+			ShutdownMachineOnInternalError:       false,
+			TaskclusterProxyAuditLog:             false,
+			TaskclusterProxyExecutable:           "taskcluster-proxy",
+			TaskclusterProxyPort:                 80,
+			TaskclusterProxyUnixSocket:           false,
+			TasksDir:                             defaultTasksDir(),
+			WorkerGroup:                          "test-worker-group",
+			WorkerLocation:                       "",
+			WorkerManagerRootURL:                 "",
+			WorkerTypeMetadata:                   map[string]interface{}{},
 		},
 	}
 
@@ -631,6 +638,7 @@ func ClaimWork() *TaskRun {
 			Queue:             taskQueue,
 			TaskClaimResponse: tcqueue.TaskClaimResponse(taskResponse),
 			Artifacts:         map[string]TaskArtifact{},
+			MountedInputs:     []MountedInput{},
 			featureArtifacts: map[string]string{
 				logName: "Native Log",
 			},
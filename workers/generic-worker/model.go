@@ -22,8 +22,11 @@ type (
 		Payload             GenericWorkerPayload           `json:"-"`
 		// Artifacts is a map from artifact name to artifact
 		Artifacts map[string]TaskArtifact `json:"-"`
-		Status    TaskStatus              `json:"-"`
-		Commands  []*process.Command      `json:"-"`
+		// MountedInputs lists the content mounted into the task by the
+		// mounts feature
+		MountedInputs []MountedInput     `json:"-"`
+		Status        TaskStatus         `json:"-"`
+		Commands      []*process.Command `json:"-"`
 		// not exported
 		logMux         sync.RWMutex
 		logWriter      io.Writer
@@ -99,6 +99,54 @@ func (cache *Cache) Expunge(task *TaskRun) error {
 type MountsFeature struct {
 }
 
+// MountedInput records what was mounted into a task, and where it came from,
+// so that the chain of trust certificate can describe the inputs of the task.
+type MountedInput struct {
+	// One of "file", "directory" or "cache"
+	Type string `json:"type"`
+	// Location of the mounted file or directory, relative to the task
+	// directory
+	Path string `json:"path"`
+	// Name of the writable directory cache (type "cache" only)
+	CacheName string `json:"cacheName,omitempty"`
+	// True if an existing writable directory cache from a previous task was
+	// mounted, in which case the cache content was not (re)extracted
+	PreexistingCache bool `json:"preexistingCache,omitempty"`
+	// Source of the content that was mounted, if any
+	Content *MountedContent `json:"content,omitempty"`
+}
+
+// MountedContent describes the source of mounted content, and the SHA256 of
+// the content actually observed by the worker.
+type MountedContent struct {
+	// One of "url", "artifact", "raw" or "base64"
+	Type     string `json:"type"`
+	URL      string `json:"url,omitempty"`
+	TaskID   string `json:"taskId,omitempty"`
+	Artifact string `json:"artifact,omitempty"`
+	SHA256   string `json:"sha256"`
+}
+
+func newMountedContent(fsContent FSContent, sha256 string) *MountedContent {
+	mc := &MountedContent{
+		SHA256: sha256,
+	}
+	switch c := fsContent.(type) {
+	case *URLContent:
+		mc.Type = "url"
+		mc.URL = c.URL
+	case *ArtifactContent:
+		mc.Type = "artifact"
+		mc.TaskID = c.TaskID
+		mc.Artifact = c.Artifact
+	case *RawContent:
+		mc.Type = "raw"
+	case *Base64Content:
+		mc.Type = "base64"
+	}
+	return mc
+}
+
 func (feature *MountsFeature) Name() string {
 	return "Mounts/Caches"
 }
@@ -447,6 +495,12 @@ func (w *WritableDirectoryCache) Mount(task *TaskRun) error {
 		if err != nil {
 			panic(fmt.Errorf("[mounts] Not able to rename dir %v as %v: %v", src, target, err))
 		}
+		task.MountedInputs = append(task.MountedInputs, MountedInput{
+			Type:             "cache",
+			Path:             w.Directory,
+			CacheName:        w.CacheName,
+			PreexistingCache: true,
+		})
 	} else {
 		// new cache, let's initialise it...
 		basename := slugid.Nice()
@@ -459,20 +513,27 @@ func (w *WritableDirectoryCache) Mount(task *TaskRun) error {
 			Owner:    directoryCaches,
 			Key:      w.CacheName,
 		}
+		mountedInput := MountedInput{
+			Type:      "cache",
+			Path:      w.Directory,
+			CacheName: w.CacheName,
+		}
 		// preloaded content?
 		if w.Content != nil {
 			c, err := FSContentFrom(w.Content)
 			if err != nil {
 				return fmt.Errorf("Not able to retrieve FSContent: %v", err)
 			}
-			err = extract(c, w.Format, target, task)
+			sha256, err := extract(c, w.Format, target, task)
 			if err != nil {
 				return err
 			}
+			mountedInput.Content = newMountedContent(c, sha256)
 		} else {
 			// no preloaded content => just create dir in place
 			MkdirAllOrDie(task, target, 0700)
 		}
+		task.MountedInputs = append(task.MountedInputs, mountedInput)
 	}
 	// Regardless of whether we are running as current user, grant task user access
 	// since the mounted folder sits inside the task directory of the task user,
@@ -542,10 +603,15 @@ func (r *ReadOnlyDirectory) Mount(task *TaskRun) error {
 		return fmt.Errorf("Not able to retrieve FSContent: %v", err)
 	}
 	dir := filepath.Join(taskContext.TaskDir, r.Directory)
-	err = extract(c, r.Format, dir, task)
+	sha256, err := extract(c, r.Format, dir, task)
 	if err != nil {
 		return err
 	}
+	task.MountedInputs = append(task.MountedInputs, MountedInput{
+		Type:    "directory",
+		Path:    r.Directory,
+		Content: newMountedContent(c, sha256),
+	})
 	return makeDirReadWritableForTaskUser(task, dir)
 }
 
@@ -559,7 +625,7 @@ func (f *FileMount) Mount(task *TaskRun) error {
 	if err != nil {
 		return err
 	}
-	cacheFile, err := ensureCached(fsContent, task)
+	cacheFile, sha256, err := ensureCached(fsContent, task)
 	if err != nil {
 		return err
 	}
@@ -584,6 +650,11 @@ func (f *FileMount) Mount(task *TaskRun) error {
 		task.Infof("%v", err)
 		return err
 	}
+	task.MountedInputs = append(task.MountedInputs, MountedInput{
+		Type:    "file",
+		Path:    f.File,
+		Content: newMountedContent(fsContent, sha256),
+	})
 	return makeFileReadWritableForTaskUser(task, file)
 }
 
@@ -592,10 +663,10 @@ func (f *FileMount) Unmount(task *TaskRun) error {
 	return nil
 }
 
-// ensureCached returns a file containing the given content
-func ensureCached(fsContent FSContent, task *TaskRun) (file string, err error) {
+// ensureCached returns a file containing the given content, and the SHA256 of
+// that file
+func ensureCached(fsContent FSContent, task *TaskRun) (file string, sha256 string, err error) {
 	cacheKey := fsContent.UniqueKey()
-	var sha256 string
 	requiredSHA256 := fsContent.RequiredSHA256()
 	if _, inCache := fileCaches[cacheKey]; inCache {
 		file = fileCaches[cacheKey].Location
@@ -632,6 +703,14 @@ func ensureCached(fsContent FSContent, task *TaskRun) (file string, err error) {
 		task.Errorf("Could not download %v to %v due to %v", fsContent.UniqueKey(), file, err)
 		return
 	}
+	// raw and base64 content is written rather than downloaded, so no SHA256
+	// has been calculated yet
+	if sha256 == "" {
+		sha256, err = fileutil.CalculateSHA256(file)
+		if err != nil {
+			panic(fmt.Sprintf("Internal worker bug! Cannot calculate SHA256 of file %v that I just wrote: %v", file, err))
+		}
+	}
 	fileCaches[cacheKey] = &Cache{
 		Location: file,
 		Hits:     1,
@@ -656,29 +735,34 @@ func ensureCached(fsContent FSContent, task *TaskRun) (file string, err error) {
 	return
 }
 
-func extract(fsContent FSContent, format string, dir string, task *TaskRun) error {
-	cacheFile, err := ensureCached(fsContent, task)
+// extract extracts the given archive content into dir, and returns the SHA256
+// of the archive
+func extract(fsContent FSContent, format string, dir string, task *TaskRun) (sha256 string, err error) {
+	var cacheFile string
+	cacheFile, sha256, err = ensureCached(fsContent, task)
 	if err != nil {
 		log.Printf("Could not cache content: %v", err)
-		return err
+		return
 	}
 	err = MkdirAll(task, dir, 0700)
 	if err != nil {
-		return err
+		return
 	}
 	task.Infof("[mounts] Extracting %v file %v to '%v'", format, cacheFile, dir)
 	switch format {
 	case "zip":
-		return archiver.Zip.Open(cacheFile, dir)
+		err = archiver.Zip.Open(cacheFile, dir)
 	case "tar.gz":
-		return archiver.TarGz.Open(cacheFile, dir)
+		err = archiver.TarGz.Open(cacheFile, dir)
 	case "rar":
-		return archiver.Rar.Open(cacheFile, dir)
+		err = archiver.Rar.Open(cacheFile, dir)
 	case "tar.bz2":
-		return archiver.TarBz2.Open(cacheFile, dir)
+		err = archiver.TarBz2.Open(cacheFile, dir)
+	default:
+		log.Fatalf("Unsupported format %v", format)
+		err = fmt.Errorf("Unsupported archive format %v", format)
 	}
-	log.Fatalf("Unsupported format %v", format)
-	return fmt.Errorf("Unsupported archive format %v", format)
+	return
 }
 
 // FSContentFrom returns either a *ArtifactContent or *URLContent or *RawContent or *Base64Content based on the content
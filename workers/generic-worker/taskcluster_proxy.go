@@ -2,10 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"path/filepath"
 
 	tcclient "github.com/taskcluster/taskcluster/v30/clients/client-go"
 	"github.com/taskcluster/taskcluster/v30/internal/scopes"
@@ -37,7 +40,19 @@ type TaskclusterProxyTask struct {
 	taskStatusChangeListener *TaskStatusChangeListener
 }
 
+var (
+	tcProxyAuditLogPath = filepath.Join("generic-worker", "taskcluster-proxy-audit.jsonl")
+	tcProxyAuditLogName = "public/logs/taskcluster-proxy-audit.jsonl"
+	// relative to the task directory, which is only accessible to the task user
+	tcProxySocketPath = "taskcluster-proxy.sock"
+)
+
 func (l *TaskclusterProxyTask) ReservedArtifacts() []string {
+	if config.TaskclusterProxyAuditLog {
+		return []string{
+			tcProxyAuditLogName,
+		}
+	}
 	return []string{}
 }
 
@@ -53,9 +68,17 @@ func (l *TaskclusterProxyTask) RequiredScopes() scopes.Required {
 }
 
 func (l *TaskclusterProxyTask) Start() *CommandExecutionError {
-	// Set TASKCLUSTER_PROXY_URL in the task environment
-	err := l.task.setVariable("TASKCLUSTER_PROXY_URL",
-		fmt.Sprintf("http://localhost:%d", config.TaskclusterProxyPort))
+	unixSocket := ""
+	var err error
+	if config.TaskclusterProxyUnixSocket {
+		// Set TASKCLUSTER_PROXY_SOCKET in the task environment
+		unixSocket = filepath.Join(taskContext.TaskDir, tcProxySocketPath)
+		err = l.task.setVariable("TASKCLUSTER_PROXY_SOCKET", unixSocket)
+	} else {
+		// Set TASKCLUSTER_PROXY_URL in the task environment
+		err = l.task.setVariable("TASKCLUSTER_PROXY_URL",
+			fmt.Sprintf("http://localhost:%d", config.TaskclusterProxyPort))
+	}
 	if err != nil {
 		return MalformedPayloadError(err)
 	}
@@ -64,9 +87,14 @@ func (l *TaskclusterProxyTask) Start() *CommandExecutionError {
 	// this task (which cannot be represented in task.scopes)
 	scopes := append(l.task.Definition.Scopes,
 		fmt.Sprintf("queue:create-artifact:%s/%d", l.task.TaskID, l.task.RunID))
+	auditLogFile := ""
+	if config.TaskclusterProxyAuditLog {
+		auditLogFile = filepath.Join(taskContext.TaskDir, tcProxyAuditLogPath)
+	}
 	taskclusterProxy, err := tcproxy.New(
 		config.TaskclusterProxyExecutable,
 		config.TaskclusterProxyPort,
+		unixSocket,
 		config.RootURL,
 		&tcclient.Credentials{
 			AccessToken:      l.task.TaskClaimResponse.Credentials.AccessToken,
@@ -74,11 +102,22 @@ func (l *TaskclusterProxyTask) Start() *CommandExecutionError {
 			ClientID:         l.task.TaskClaimResponse.Credentials.ClientID,
 			AuthorizedScopes: scopes,
 		},
+		auditLogFile,
+		l.task.TaskID,
+		l.task.RunID,
 	)
 	if err != nil {
 		return executionError(internalError, errored, fmt.Errorf("Could not start taskcluster proxy: %s", err))
 	}
 	l.taskclusterProxy = taskclusterProxy
+	if unixSocket != "" {
+		// the socket is created accessible only to the worker user, so hand
+		// it over to the task user
+		err = makeFileReadWritableForTaskUser(l.task, unixSocket)
+		if err != nil {
+			return executionError(internalError, errored, fmt.Errorf("Could not grant task user access to taskcluster proxy socket %v: %v", unixSocket, err))
+		}
+	}
 	l.taskStatusChangeListener = &TaskStatusChangeListener{
 		Name: "taskcluster-proxy",
 		Callback: func(ts TaskStatus) {
@@ -93,11 +132,21 @@ func (l *TaskclusterProxyTask) Start() *CommandExecutionError {
 			}
 			buffer := bytes.NewBuffer(b)
 			putURL := fmt.Sprintf("http://localhost:%v/credentials", config.TaskclusterProxyPort)
+			client := &http.Client{}
+			if unixSocket != "" {
+				// host name is ignored when dialing the socket
+				putURL = "http://localhost/credentials"
+				client.Transport = &http.Transport{
+					DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+						var dialer net.Dialer
+						return dialer.DialContext(ctx, "unix", unixSocket)
+					},
+				}
+			}
 			req, err := http.NewRequest("PUT", putURL, buffer)
 			if err != nil {
 				panic(fmt.Sprintf("Could not create PUT request to taskcluster-proxy /credentials endpoint: %v", err))
 			}
-			client := &http.Client{}
 			res, err := client.Do(req)
 			if err != nil {
 				panic(fmt.Sprintf("Could not PUT to %v: %v", putURL, err))
@@ -122,4 +171,17 @@ func (l *TaskclusterProxyTask) Stop(err *ExecutionErrors) {
 		l.task.Warnf("[taskcluster-proxy] Could not terminate taskcluster proxy process: %s", errTerminate)
 		log.Printf("WARNING: could not terminate taskcluster proxy writer: %s", errTerminate)
 	}
+	if config.TaskclusterProxyAuditLog {
+		err.add(l.task.uploadArtifact(
+			&S3Artifact{
+				BaseArtifact: &BaseArtifact{
+					Name:    tcProxyAuditLogName,
+					Expires: l.task.Definition.Expires,
+				},
+				ContentType:     "text/plain; charset=utf-8",
+				ContentEncoding: "gzip",
+				Path:            tcProxyAuditLogPath,
+			},
+		))
+	}
 }
@@ -6,26 +6,34 @@ import (
 	"fmt"
 	"os"
 	"testing"
+
+	"github.com/taskcluster/taskcluster/v30/clients/client-go/tcqueue"
 )
 
-func TestTaskclusterProxy(t *testing.T) {
-	defer setup(t)()
+// submitTaskclusterProxyTask submits a task which fetches an artifact of
+// another task via taskcluster-proxy, and asserts that it completes.  If
+// reclaim is true, the task runs for long enough to be reclaimed, and so get
+// new credentials, before fetching the artifact.
+func submitTaskclusterProxyTask(t *testing.T, reclaim bool) (td *tcqueue.TaskDefinitionRequest, taskID string) {
+	artifactTaskID := CreateArtifactFromFile(t, "SampleArtifacts/_/X.txt", "SampleArtifacts/_/X.txt")
 
-	taskID := CreateArtifactFromFile(t, "SampleArtifacts/_/X.txt", "SampleArtifacts/_/X.txt")
+	command := goEnv()
+	if reclaim {
+		command = append(command, sleep(12)...)
+	}
+	command = append(
+		command,
+		goRun(
+			"curlget.go",
+			// note that curlget.go supports substituting the proxy URL from its
+			// runtime environment, and dials $TASKCLUSTER_PROXY_SOCKET when it
+			// is set
+			fmt.Sprintf("TASKCLUSTER_PROXY_URL/queue/v1/task/"+artifactTaskID+"/runs/0/artifacts/SampleArtifacts/_/X.txt"),
+		)...,
+	)
 
 	payload := GenericWorkerPayload{
-		Command: append(
-			append(
-				goEnv(),
-				// long enough to reclaim and get new credentials
-				sleep(12)...,
-			),
-			goRun(
-				"curlget.go",
-				// note that curlget.go supports substituting the proxy URL from its runtime environment
-				fmt.Sprintf("TASKCLUSTER_PROXY_URL/queue/v1/task/"+taskID+"/runs/0/artifacts/SampleArtifacts/_/X.txt"),
-			)...,
-		),
+		Command:    command,
 		MaxRunTime: 180,
 		Env:        map[string]string{},
 		Features: FeatureFlags{
@@ -41,12 +49,19 @@ func TestTaskclusterProxy(t *testing.T) {
 			payload.Env[envVar] = v
 		}
 	}
-	td := testTask(t)
+	td = testTask(t)
 	td.Scopes = []string{"queue:get-artifact:SampleArtifacts/_/X.txt"}
-	td.Dependencies = []string{taskID}
-	reclaimEvery5Seconds = true
+	td.Dependencies = []string{artifactTaskID}
+	reclaimEvery5Seconds = reclaim
 	taskID = submitAndAssert(t, td, payload, "completed", "completed")
 	reclaimEvery5Seconds = false
+	return
+}
+
+func TestTaskclusterProxy(t *testing.T) {
+	defer setup(t)()
+
+	td, taskID := submitTaskclusterProxyTask(t, true)
 
 	expectedArtifacts := ExpectedArtifacts{
 		"public/logs/live_backing.log": {
@@ -62,3 +77,49 @@ func TestTaskclusterProxy(t *testing.T) {
 
 	expectedArtifacts.Validate(t, taskID, 0)
 }
+
+func TestTaskclusterProxyAuditLog(t *testing.T) {
+	defer setup(t)()
+
+	config.TaskclusterProxyAuditLog = true
+
+	td, taskID := submitTaskclusterProxyTask(t, false)
+
+	expectedArtifacts := ExpectedArtifacts{
+		"public/logs/taskcluster-proxy-audit.jsonl": {
+			Extracts: []string{
+				`"method":"GET"`,
+				`"service":"queue"`,
+				`/artifacts/SampleArtifacts/_/X.txt"`,
+				`"status":`,
+			},
+			ContentType:     "text/plain; charset=utf-8",
+			ContentEncoding: "gzip",
+			Expires:         td.Expires,
+		},
+	}
+
+	expectedArtifacts.Validate(t, taskID, 0)
+}
+
+func TestTaskclusterProxyUnixSocket(t *testing.T) {
+	defer setup(t)()
+
+	config.TaskclusterProxyUnixSocket = true
+
+	td, taskID := submitTaskclusterProxyTask(t, false)
+
+	expectedArtifacts := ExpectedArtifacts{
+		"public/logs/live_backing.log": {
+			Extracts: []string{
+				"test artifact",
+				"Successful task run",
+			},
+			ContentType:     "text/plain; charset=utf-8",
+			ContentEncoding: "gzip",
+			Expires:         td.Expires,
+		},
+	}
+
+	expectedArtifacts.Validate(t, taskID, 0)
+}
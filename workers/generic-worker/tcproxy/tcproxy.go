@@ -17,15 +17,20 @@ import (
 
 // TaskclusterProxy provides access to a taskcluster-proxy process running on the OS.
 type TaskclusterProxy struct {
-	mut      sync.Mutex
-	command  *exec.Cmd
-	HTTPPort uint16
-	Pid      int
+	mut        sync.Mutex
+	command    *exec.Cmd
+	HTTPPort   uint16
+	UnixSocket string
+	Pid        int
 }
 
 // New starts a tcproxy OS process using the executable specified, and returns
-// a *TaskclusterProxy.
-func New(taskclusterProxyExecutable string, httpPort uint16, rootURL string, creds *tcclient.Credentials) (*TaskclusterProxy, error) {
+// a *TaskclusterProxy. If unixSocket is not empty, the proxy listens on a unix
+// domain socket at that path (accessible only to the user running the proxy)
+// instead of httpPort. If auditLogFile is not empty, the proxy records each
+// proxied request to that file. If taskID is not empty, the proxy's
+// /upload-artifact/<name> endpoint creates artifacts for run runID of taskID.
+func New(taskclusterProxyExecutable string, httpPort uint16, unixSocket string, rootURL string, creds *tcclient.Credentials, auditLogFile string, taskID string, runID uint) (*TaskclusterProxy, error) {
 	args := []string{
 		"--port", strconv.Itoa(int(httpPort)),
 		"--root-url", rootURL,
@@ -33,13 +38,23 @@ func New(taskclusterProxyExecutable string, httpPort uint16, rootURL string, cre
 		"--access-token", creds.AccessToken,
 		"--ip-address", "127.0.0.1",
 	}
+	if unixSocket != "" {
+		args = append(args, "--unix-socket", unixSocket)
+	}
+	if taskID != "" {
+		args = append(args, "--upload-task-id", taskID, "--upload-run-id", strconv.Itoa(int(runID)))
+	}
 	if creds.Certificate != "" {
 		args = append(args, "--certificate", creds.Certificate)
 	}
+	if auditLogFile != "" {
+		args = append(args, "--audit-log", auditLogFile)
+	}
 	args = append(args, creds.AuthorizedScopes...)
 	l := &TaskclusterProxy{
-		command:  exec.Command(taskclusterProxyExecutable, args...),
-		HTTPPort: httpPort,
+		command:    exec.Command(taskclusterProxyExecutable, args...),
+		HTTPPort:   httpPort,
+		UnixSocket: unixSocket,
 	}
 	err := l.command.Start()
 	// Note - we're assuming here that if the process fails to launch we'll get
@@ -50,7 +65,11 @@ func New(taskclusterProxyExecutable string, httpPort uint16, rootURL string, cre
 	l.Pid = l.command.Process.Pid
 	log.Printf("Started taskcluster proxy process (PID %v)", l.Pid)
 	// Just to be safe, let's make sure the port is actually active before returning.
-	err = waitForPortToBeActive(httpPort)
+	if unixSocket != "" {
+		err = waitForSocketToBeActive(unixSocket)
+	} else {
+		err = waitForPortToBeActive(httpPort)
+	}
 	return l, err
 }
 
@@ -66,6 +85,7 @@ func (l *TaskclusterProxy) Terminate() error {
 	defer func() {
 		log.Printf("Stopped taskcluster proxy process (PID %v)", l.Pid)
 		l.HTTPPort = 0
+		l.UnixSocket = ""
 		l.Pid = 0
 		l.command = nil
 	}()
@@ -84,3 +104,16 @@ func waitForPortToBeActive(port uint16) error {
 	}
 	return fmt.Errorf("Timeout waiting for taskcluster-proxy port %v to be active", port)
 }
+
+func waitForSocketToBeActive(path string) error {
+	deadline := time.Now().Add(60 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err := net.DialTimeout("unix", path, 60*time.Second)
+		if err == nil {
+			_ = conn.Close()
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("Timeout waiting for taskcluster-proxy unix socket %v to be active", path)
+}
@@ -27,7 +27,7 @@ func TestTcProxy(t *testing.T) {
 		Certificate:      certificate,
 		AuthorizedScopes: []string{"queue:get-artifact:SampleArtifacts/_/X.txt"},
 	}
-	ll, err := New(executable, 34569, rootURL, creds)
+	ll, err := New(executable, 34569, "", rootURL, creds, "", "", 0)
 	// Do defer before checking err since err could be a different error and
 	// process may have already started up.
 	defer func() {
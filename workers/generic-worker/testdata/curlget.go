@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -10,11 +12,23 @@ import (
 
 func main() {
 	if len(os.Args) != 2 {
-		log.Fatal("Usage: go run curlget.go <url>\n<url> will have the current $TASKCLUSTER_PROXY_URL substituted for the string TASKCLUSTER_PROXY_URL")
+		log.Fatal("Usage: go run curlget.go <url>\n<url> will have the current $TASKCLUSTER_PROXY_URL substituted (or, if $TASKCLUSTER_PROXY_SOCKET is set, requests are sent over that unix domain socket) for the string TASKCLUSTER_PROXY_URL")
 	}
 	url := os.Args[1]
-	url = strings.Replace(url, "TASKCLUSTER_PROXY_URL", os.Getenv("TASKCLUSTER_PROXY_URL"), -1)
-	res, err := http.Get(url)
+	client := &http.Client{}
+	proxyURL := os.Getenv("TASKCLUSTER_PROXY_URL")
+	// if taskcluster-proxy is listening on a unix domain socket, dial that instead
+	if socket := os.Getenv("TASKCLUSTER_PROXY_SOCKET"); socket != "" {
+		proxyURL = "http://localhost"
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+	}
+	url = strings.Replace(url, "TASKCLUSTER_PROXY_URL", proxyURL, -1)
+	res, err := client.Get(url)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
@@ -19,6 +19,7 @@ const (
 	CANT_CREATE_ED25519_KEYPAIR ExitCode = 75
 	CANT_SAVE_CONFIG            ExitCode = 76
 	CANT_CONNECT_PROTOCOL_PIPE  ExitCode = 78
+	CANT_READ_ED25519_KEY       ExitCode = 79
 )
 
 func usage(versionName string) string {
@@ -35,7 +36,8 @@ and reports back results to the queue.
                                             [--worker-runner-protocol-pipe PIPE]
                                             [--configure-for-aws | --configure-for-gcp | --configure-for-azure]` + installServiceSummary() + `
     generic-worker show-payload-schema
-    generic-worker new-ed25519-keypair      --file ED25519-PRIVATE-KEY-FILE` + customTargetsSummary() + `
+    generic-worker new-ed25519-keypair      --file ED25519-PRIVATE-KEY-FILE
+    generic-worker show-ed25519-key-id      --file ED25519-PRIVATE-KEY-FILE` + customTargetsSummary() + `
     generic-worker --help
     generic-worker --version
 
@@ -52,7 +54,12 @@ and reports back results to the queue.
     new-ed25519-keypair                     This will generate a fresh, new ed25519
                                             compliant private/public key pair. The public
                                             key will be written to stdout and the private
-                                            key will be written to the specified file.` + customTargets() + `
+                                            key will be written to the specified file.
+    show-ed25519-key-id                     This will read the ed25519 private key from the
+                                            specified file, and write its key ID, SHA256
+                                            fingerprint and public key to stdout. The key ID
+                                            is the value recorded in chain of trust
+                                            certificates signed with this key.` + customTargets() + `
 
   Options:
     --config CONFIG-FILE                    Json configuration file to use. See
@@ -81,9 +88,11 @@ and reports back results to the queue.
                                             installation by querying the GCP environment
                                             and setting appropriate values.` + platformCommandLineParameters() + `
     --file PRIVATE-KEY-FILE                 The path to the file to write the private key
-                                            to. The parent directory must already exist.
-                                            If the file exists it will be overwritten,
-                                            otherwise it will be created.` + sidSID() + `
+                                            to (new-ed25519-keypair) or to read the private
+                                            key from (show-ed25519-key-id). When writing,
+                                            the parent directory must already exist. If the
+                                            file exists it will be overwritten, otherwise it
+                                            will be created.` + sidSID() + `
     --help                                  Display this help text.
     --version                               The release version of the generic-worker.
 
@@ -111,6 +120,13 @@ and reports back results to the queue.
         ** OPTIONAL ** properties
         =========================
 
+          additionalEd25519SigningKeyLocations
+                                            A list of further ed25519 private key files to sign
+                                            chain of trust certificates with, in addition to
+                                            ed25519SigningKeyLocation. This allows keys to be
+                                            rotated without a flag day: add the new key here,
+                                            wait for consumers to trust it, and then promote it
+                                            to ed25519SigningKeyLocation. [default: []]
           authRootURL                       The root URL for taskcluster auth API calls.
                                             If not provided, the value from config property
                                             rootURL is used. Intended for development/testing.
@@ -175,6 +191,13 @@ and reports back results to the queue.
                                             running on them. [default: "test-provisioner"]
           publicIP                          The IP address for VNC access.  Also used by chain of
                                             trust when present.
+          publishSLSAProvenance             If true, tasks with the chainOfTrust feature enabled
+                                            will additionally publish an in-toto statement with
+                                            SLSA provenance for their artifacts, wrapped in a
+                                            DSSE envelope signed with the chain of trust signing
+                                            key(s), as artifact
+                                            public/chain-of-trust-provenance.intoto.json.
+                                            [default: false]
           purgeCacheRootURL                 The root URL for taskcluster purge cache API calls.
                                             If not provided, the value from config property
                                             rootURL is used. Intended for development/testing.
@@ -224,11 +247,27 @@ and reports back results to the queue.
                                             for machines running in production, such as on AWS
                                             EC2 spot instances. Use with caution!
                                             [default: false]
+          taskclusterProxyAuditLog          If true, tasks with the taskclusterProxy feature
+                                            enabled will publish a record of each request made
+                                            through the taskcluster-proxy (method, service,
+                                            path, status and duration), one json object per
+                                            line, as artifact
+                                            public/logs/taskcluster-proxy-audit.jsonl.
+                                            [default: false]
           taskclusterProxyExecutable        Filepath of taskcluster-proxy executable to use; see
                                             https://github.com/taskcluster/taskcluster/tree/master/tools/taskcluster-proxy
                                             [default: "taskcluster-proxy"]
           taskclusterProxyPort              Port number for taskcluster-proxy HTTP requests.
                                             [default: 80]
+          taskclusterProxyUnixSocket        If true, taskcluster-proxy listens on a unix domain
+                                            socket in the task directory, which only the task
+                                            user can access, rather than on
+                                            taskclusterProxyPort. The path of the socket is
+                                            given to tasks in environment variable
+                                            TASKCLUSTER_PROXY_SOCKET, and
+                                            TASKCLUSTER_PROXY_URL is not set. On Windows, this
+                                            requires Windows 10 version 1803 or later.
+                                            [default: false]
           tasksDir                          The location where task directories should be
                                             created on the worker. [default: ` + fmt.Sprintf("%q", defaultTasksDir()) + `]
           workerGroup                       Typically this would be an aws region - an
@@ -306,5 +345,6 @@ and reports back results to the queue.
     76     Not able to save generic-worker config file after fetching it from AWS provisioner
            or Google Cloud metadata.` + exitCode77() + `
     78     Not able to connect to --worker-runner-protocol-pipe.
+    79     Not able to read an ed25519 private key.
 `
 }